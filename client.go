@@ -1,11 +1,36 @@
 package volman
 
-import "code.cloudfoundry.org/lager"
+import (
+	"context"
+
+	"code.cloudfoundry.org/lager"
+)
+
+type configOverrideKey struct{}
+
+// WithConfigOverride returns a context under which Mount recreates a volume that is already mounted with a different
+// config, rather than failing with a ConfigConflictError.  Containers already using the volume keep it, now created
+// with the new config.
+func WithConfigOverride(ctx context.Context) context.Context {
+	return context.WithValue(ctx, configOverrideKey{}, true)
+}
+
+// ConfigOverride reports whether the context was returned by WithConfigOverride
+func ConfigOverride(ctx context.Context) bool {
+	override, _ := ctx.Value(configOverrideKey{}).(bool)
+	return override
+}
 
 //go:generate counterfeiter -o volmanfakes/fake_manager_client.go . Manager
 
 type Manager interface {
-	ListDrivers(logger lager.Logger) (ListDriversResponse, error)
-	Mount(logger lager.Logger, driverId string, volumeId string, config map[string]interface{}) (MountResponse, error)
-	Unmount(logger lager.Logger, driverId string, volumeId string) error
+	ListDrivers(logger lager.Logger, ctx context.Context) (ListDriversResponse, error)
+	Discover(logger lager.Logger, ctx context.Context) (ListDriversResponse, error)
+	ListMounts(logger lager.Logger, ctx context.Context, filter ListMountsFilter) (ListMountsResponse, error)
+	ListPendingUnmounts(logger lager.Logger, ctx context.Context) (ListPendingUnmountsResponse, error)
+	Mount(logger lager.Logger, ctx context.Context, driverId string, volumeId string, containerId string, config map[string]interface{}) (MountResponse, error)
+	Unmount(logger lager.Logger, ctx context.Context, driverId string, volumeId string, containerId string) error
+	Path(logger lager.Logger, ctx context.Context, driverId string, volumeId string) (PathResponse, error)
+	Get(logger lager.Logger, ctx context.Context, driverId string, volumeId string) (GetResponse, error)
+	Purge(logger lager.Logger, ctx context.Context, dryRun bool) (PurgeResponse, error)
 }
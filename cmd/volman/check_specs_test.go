@@ -0,0 +1,106 @@
+package main_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+
+	"code.cloudfoundry.org/volman/vollocal"
+)
+
+var _ = Describe("check-specs", func() {
+	var (
+		firstDriverPath, secondDriverPath string
+	)
+
+	BeforeEach(func() {
+		var err error
+		firstDriverPath, err = ioutil.TempDir("", "check-specs")
+		Expect(err).NotTo(HaveOccurred())
+		secondDriverPath, err = ioutil.TempDir("", "check-specs")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(ioutil.WriteFile(filepath.Join(firstDriverPath, "fakedriver.json"), []byte(`{"Addr":"http://127.0.0.1:8750","TLSConfig":{"CAFile":"/var/vcap/jobs/fakedriver/ca.crt"}}`), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(secondDriverPath, "fakedriver.spec"), []byte("http://127.0.0.1:9750"), 0644)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(firstDriverPath)
+		os.RemoveAll(secondDriverPath)
+	})
+
+	volman := func(args ...string) *gexec.Session {
+		command := exec.Command(volmanPath, args...)
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(session, 10*time.Second).Should(gexec.Exit())
+		return session
+	}
+
+	It("reports each spec file and the one that wins", func() {
+		session := volman("check-specs", firstDriverPath, secondDriverPath)
+		Expect(session.ExitCode()).To(Equal(0))
+		Expect(session.Out).To(gbytes.Say(`DRIVER\s+SPEC FILE\s+ADDRESS\s+CANONICAL URL\s+TLS\s+WINNER`))
+		Expect(session.Out).To(gbytes.Say(`fakedriver\s+\S+fakedriver.json\s+http://127.0.0.1:8750\s+http://127.0.0.1:8750\s+ca=/var/vcap/jobs/fakedriver/ca.crt\s+true`))
+		Expect(session.Out).To(gbytes.Say(`fakedriver\s+\S+fakedriver.spec\s+http://127.0.0.1:9750\s+http://127.0.0.1:9750\s+-\s+false`))
+		Expect(session.Out).To(gbytes.Say(`COLLISION\s+CHOSEN`))
+	})
+
+	It("prints the report as json", func() {
+		session := volman("check-specs", "-json", firstDriverPath)
+		Expect(session.ExitCode()).To(Equal(0))
+
+		var report vollocal.SpecCheckReport
+		Expect(json.Unmarshal(session.Out.Contents(), &report)).To(Succeed())
+		Expect(report.Specs).To(HaveLen(1))
+		Expect(report.Specs[0].Driver).To(Equal("fakedriver"))
+		Expect(report.Specs[0].Winner).To(BeTrue())
+	})
+
+	Context("when conflicts are errors", func() {
+		It("fails naming the conflict", func() {
+			session := volman("check-specs", "-precedence", "error-on-conflict", firstDriverPath, secondDriverPath)
+			Expect(session.ExitCode()).To(Equal(1))
+			Expect(session.Err).To(gbytes.Say("named by 2 spec files"))
+		})
+	})
+
+	Context("when a spec file can not be read", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(filepath.Join(firstDriverPath, "brokendriver.json"), []byte(`{"invalid"}`), 0644)).To(Succeed())
+		})
+
+		It("fails naming the spec file", func() {
+			session := volman("check-specs", firstDriverPath)
+			Expect(session.ExitCode()).To(Equal(1))
+			Expect(session.Err).To(gbytes.Say("brokendriver.json"))
+			Expect(session.Err).To(gbytes.Say("driver 'brokendriver' has no spec file volman would activate it from"))
+		})
+	})
+
+	It("needs a driver path", func() {
+		session := volman("check-specs")
+		Expect(session.ExitCode()).To(Equal(2))
+		Expect(session.Err).To(gbytes.Say("no driver path given"))
+	})
+
+	It("rejects driver paths that are not directories", func() {
+		session := volman("check-specs", filepath.Join(firstDriverPath, "fakedriver.json"))
+		Expect(session.ExitCode()).To(Equal(2))
+		Expect(session.Err).To(gbytes.Say("is not a directory"))
+	})
+
+	It("rejects unknown commands", func() {
+		session := volman("frobnicate")
+		Expect(session.ExitCode()).To(Equal(2))
+		Expect(session.Err).To(gbytes.Say("unknown command 'frobnicate'"))
+	})
+})
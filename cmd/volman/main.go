@@ -0,0 +1,218 @@
+// volman holds commands that work on a cell's volman configuration without a volman running, such as checking
+// driver spec files before volman reads them.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/voldriver"
+	"code.cloudfoundry.org/volman/vollocal"
+)
+
+const usage = `usage: volman <command> [flags] [args]
+
+commands:
+  check-specs [-activate] [-json] [-precedence <policy>] [-prefer-format <format>] <driver path> [<driver path>...]
+        read every .sock, .spec and .json file on the driver paths as volman would, and report what each gives and
+        which wins for its driver.  Exits 1 when a spec file can not be read or a driver would not be activated.
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs the command line and returns the exit code: 0 on success, 1 when the command finds problems or fails and
+// 2 when the command line is wrong
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	switch args[0] {
+	case "check-specs":
+		return checkSpecs(args[1:], stdout, stderr)
+	default:
+		fmt.Fprintf(stderr, "volman: unknown command '%s'\n", args[0])
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+}
+
+func checkSpecs(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("check-specs", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	activate := flags.Bool("activate", false, "also activate each driver and ask for its capabilities")
+	jsonOutput := flags.Bool("json", false, "print the report as json rather than a table")
+	precedence := flags.String("precedence", vollocal.PrecedenceFirstWins, "how to choose between spec files naming the same driver: first-wins, error-on-conflict or prefer-format")
+	preferFormat := flags.String("prefer-format", "", "spec format, sock, spec or json, preferred by the prefer-format policy")
+	timeout := flags.Duration("timeout", time.Minute, "how long to wait for the whole check")
+	verbose := flags.Bool("verbose", false, "log to stderr")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(stderr, "volman check-specs: no driver path given")
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	for _, driverPath := range flags.Args() {
+		if info, err := os.Stat(driverPath); err != nil || !info.IsDir() {
+			fmt.Fprintf(stderr, "volman check-specs: '%s' is not a directory\n", driverPath)
+			return 2
+		}
+	}
+
+	logger := lager.NewLogger("volman")
+	if *verbose {
+		logger.RegisterSink(lager.NewWriterSink(stderr, lager.DEBUG))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	// the same factory, policies and precedence volman discovers drivers with
+	config := vollocal.NewDriverConfig()
+	driverPrecedence := vollocal.DriverPrecedence{Policy: *precedence, Format: *preferFormat}
+
+	report, err := vollocal.CheckSpecs(logger, ctx, vollocal.NewDriverFactory(), flags.Args(), config.DriverPolicies, driverPrecedence, *activate)
+	if err != nil {
+		fmt.Fprintf(stderr, "volman check-specs: %s\n", err.Error())
+		return 1
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			fmt.Fprintf(stderr, "volman check-specs: %s\n", err.Error())
+			return 1
+		}
+	} else {
+		printSpecCheckReport(stdout, report, *activate)
+	}
+
+	problems := specProblems(report, *activate)
+	for _, problem := range problems {
+		fmt.Fprintf(stderr, "volman check-specs: %s\n", problem)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// specProblems lists the spec files volman would skip and the drivers it would not activate
+func specProblems(report vollocal.SpecCheckReport, activate bool) []string {
+	var problems []string
+
+	won := map[string]bool{}
+	var drivers []string
+	for _, check := range report.Specs {
+		if _, ok := won[check.Driver]; !ok {
+			drivers = append(drivers, check.Driver)
+		}
+		won[check.Driver] = won[check.Driver] || check.Winner
+
+		if check.Error != "" {
+			problems = append(problems, fmt.Sprintf("%s: %s", check.SpecFile, check.Error))
+		}
+	}
+
+	refused := map[string]bool{}
+	for _, collision := range report.Collisions {
+		if collision.Error != "" {
+			problems = append(problems, collision.Error)
+			refused[collision.Name] = true
+		}
+	}
+
+	for _, driver := range drivers {
+		if won[driver] || refused[driver] {
+			continue
+		}
+		if activate {
+			problems = append(problems, fmt.Sprintf("driver '%s' did not activate from any of its spec files", driver))
+		} else {
+			problems = append(problems, fmt.Sprintf("driver '%s' has no spec file volman would activate it from", driver))
+		}
+	}
+
+	return problems
+}
+
+func printSpecCheckReport(out io.Writer, report vollocal.SpecCheckReport, activate bool) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	if activate {
+		fmt.Fprintln(w, "DRIVER\tSPEC FILE\tADDRESS\tCANONICAL URL\tTLS\tWINNER\tIMPLEMENTS\tSCOPE\tERROR")
+	} else {
+		fmt.Fprintln(w, "DRIVER\tSPEC FILE\tADDRESS\tCANONICAL URL\tTLS\tWINNER\tERROR")
+	}
+
+	for _, check := range report.Specs {
+		problem := check.Error
+		var implements, scope string
+		if check.Activation != nil {
+			implements, scope = strings.Join(check.Activation.Implements, ","), check.Activation.Scope
+			if check.Activation.Error != "" {
+				problem = "activate: " + check.Activation.Error
+			}
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t", check.Driver, check.SpecFile, dash(check.SpecAddress), dash(check.Address), formatTLS(check.TLSConfig), check.Winner)
+		if activate {
+			fmt.Fprintf(w, "\t%s\t%s", dash(implements), dash(scope))
+		}
+		fmt.Fprintf(w, "\t%s\n", dash(problem))
+	}
+
+	if len(report.Collisions) > 0 {
+		fmt.Fprintln(w, "\nCOLLISION\tCHOSEN\tSPEC FILES\tERROR")
+		for _, collision := range report.Collisions {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", collision.Name, dash(collision.Chosen), strings.Join(collision.SpecFiles, ","), dash(collision.Error))
+		}
+	}
+}
+
+func formatTLS(tlsConfig *voldriver.TLSConfig) string {
+	if tlsConfig == nil {
+		return "-"
+	}
+
+	var parts []string
+	if tlsConfig.CAFile != "" {
+		parts = append(parts, "ca="+tlsConfig.CAFile)
+	}
+	if tlsConfig.CertFile != "" {
+		parts = append(parts, "cert="+tlsConfig.CertFile)
+	}
+	if tlsConfig.KeyFile != "" {
+		parts = append(parts, "key="+tlsConfig.KeyFile)
+	}
+	if tlsConfig.InsecureSkipVerify {
+		parts = append(parts, "insecure")
+	}
+	if len(parts) == 0 {
+		return "on"
+	}
+	return strings.Join(parts, ",")
+}
+
+func dash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,26 @@
+package main_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gexec"
+
+	"testing"
+)
+
+var volmanPath string
+
+func TestVolman(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Volman Command Suite")
+}
+
+var _ = BeforeSuite(func() {
+	var err error
+	volmanPath, err = gexec.Build("code.cloudfoundry.org/volman/cmd/volman")
+	Expect(err).NotTo(HaveOccurred())
+})
+
+var _ = AfterSuite(func() {
+	gexec.CleanupBuildArtifacts()
+})
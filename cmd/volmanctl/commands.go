@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/volman"
+)
+
+type volmanctl struct {
+	logger  lager.Logger
+	ctx     context.Context
+	manager volman.Manager
+	out     io.Writer
+	json    bool
+}
+
+// print writes the response as json, or as the table the printer writes
+func (ctl *volmanctl) print(response interface{}, printTable func(w io.Writer)) error {
+	if ctl.json {
+		encoder := json.NewEncoder(ctl.out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(response)
+	}
+
+	w := tabwriter.NewWriter(ctl.out, 0, 4, 2, ' ', 0)
+	printTable(w)
+	return w.Flush()
+}
+
+func (ctl *volmanctl) drivers(args []string) error {
+	flags := flag.NewFlagSet("drivers", flag.ContinueOnError)
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	drivers, err := ctl.manager.ListDrivers(ctl.logger, ctl.ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctl.print(drivers, func(w io.Writer) {
+		printDrivers(w, drivers)
+	})
+}
+
+func (ctl *volmanctl) discover(args []string) error {
+	flags := flag.NewFlagSet("discover", flag.ContinueOnError)
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	drivers, err := ctl.manager.Discover(ctl.logger, ctl.ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctl.print(drivers, func(w io.Writer) {
+		printDrivers(w, drivers)
+	})
+}
+
+func printDrivers(w io.Writer, drivers volman.ListDriversResponse) {
+	fmt.Fprintln(w, "NAME\tADDRESS\tSPEC FILE\tTLS\tSCOPE\tACTIVE MOUNTS\tLAST HEALTHY")
+	for _, driver := range drivers.Drivers {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\t%d\t%s\n", driver.Name, dash(driver.Address), dash(driver.SpecFile), driver.TLS, dash(driver.Scope), driver.ActiveMounts, formatTime(driver.LastHealthyAt))
+	}
+
+	if len(drivers.Collisions) > 0 {
+		fmt.Fprintln(w, "\nCOLLISION\tCHOSEN\tSPEC FILES\tERROR")
+		for _, collision := range drivers.Collisions {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", collision.Name, dash(collision.Chosen), strings.Join(collision.SpecFiles, ","), dash(collision.Error))
+		}
+	}
+}
+
+func (ctl *volmanctl) mounts(args []string) error {
+	var filter volman.ListMountsFilter
+	flags := flag.NewFlagSet("mounts", flag.ContinueOnError)
+	flags.StringVar(&filter.DriverId, "driver", "", "only list mounts of this driver")
+	flags.StringVar(&filter.VolumeId, "volume", "", "only list mounts of this volume")
+	flags.StringVar(&filter.ContainerId, "container", "", "only list mounts used by this container")
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	mounts, err := ctl.manager.ListMounts(ctl.logger, ctl.ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	return ctl.print(mounts, func(w io.Writer) {
+		printMounts(w, mounts.Mounts)
+		printDriverErrors(w, mounts.DriverErrors)
+	})
+}
+
+func printMounts(w io.Writer, mounts []volman.MountInfo) {
+	fmt.Fprintln(w, "DRIVER\tVOLUME\tSTATE\tMOUNTPOINT\tOWNERS\tRECORDED\tLISTED")
+	for _, mount := range mounts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\t%t\n", mount.DriverId, mount.VolumeId, dash(mount.State), dash(mount.Mountpoint), dash(strings.Join(mount.Owners, ",")), mount.Recorded, mount.Listed)
+	}
+}
+
+func printDriverErrors(w io.Writer, driverErrors map[string]string) {
+	if len(driverErrors) == 0 {
+		return
+	}
+
+	var driverIds []string
+	for driverId := range driverErrors {
+		driverIds = append(driverIds, driverId)
+	}
+	sort.Strings(driverIds)
+
+	fmt.Fprintln(w, "\nDRIVER\tLIST ERROR")
+	for _, driverId := range driverIds {
+		fmt.Fprintf(w, "%s\t%s\n", driverId, driverErrors[driverId])
+	}
+}
+
+func (ctl *volmanctl) mount(args []string) error {
+	var driverId, volumeId, containerId, config string
+	var override bool
+	flags := flag.NewFlagSet("mount", flag.ContinueOnError)
+	flags.StringVar(&driverId, "driver", "", "driver to mount the volume with")
+	flags.StringVar(&volumeId, "volume", "", "volume to mount")
+	flags.StringVar(&containerId, "container", "", "container to mount the volume for")
+	flags.StringVar(&config, "config", "", "volume config, as a json object")
+	flags.BoolVar(&override, "override", false, "recreate the volume if it is already mounted with a different config")
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	if driverId == "" || volumeId == "" || containerId == "" {
+		return usageError{"-driver, -volume and -container are required"}
+	}
+
+	var volumeConfig map[string]interface{}
+	if config != "" {
+		if err := json.Unmarshal([]byte(config), &volumeConfig); err != nil {
+			return usageError{fmt.Sprintf("-config is not a json object: %s", err.Error())}
+		}
+	}
+
+	ctx := ctl.ctx
+	if override {
+		ctx = volman.WithConfigOverride(ctx)
+	}
+
+	mountResponse, err := ctl.manager.Mount(ctl.logger, ctx, driverId, volumeId, containerId, volumeConfig)
+	if err != nil {
+		return err
+	}
+
+	return ctl.print(mountResponse, func(w io.Writer) {
+		fmt.Fprintln(w, mountResponse.Path)
+	})
+}
+
+func (ctl *volmanctl) unmount(args []string) error {
+	var driverId, volumeId, containerId string
+	flags := flag.NewFlagSet("unmount", flag.ContinueOnError)
+	flags.StringVar(&driverId, "driver", "", "driver the volume is mounted with")
+	flags.StringVar(&volumeId, "volume", "", "volume to unmount")
+	flags.StringVar(&containerId, "container", "", "container to release the mount for")
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	if driverId == "" || volumeId == "" || containerId == "" {
+		return usageError{"-driver, -volume and -container are required"}
+	}
+
+	if err := ctl.manager.Unmount(ctl.logger, ctl.ctx, driverId, volumeId, containerId); err != nil {
+		return err
+	}
+
+	return ctl.print(struct{}{}, func(w io.Writer) {
+		fmt.Fprintf(w, "released %s's mount of %s\n", containerId, volumeId)
+	})
+}
+
+func (ctl *volmanctl) purge(args []string) error {
+	var dryRun bool
+	flags := flag.NewFlagSet("purge", flag.ContinueOnError)
+	flags.BoolVar(&dryRun, "dry-run", false, "only list the volumes a purge would unmount")
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	purgeResponse, err := ctl.manager.Purge(ctl.logger, ctl.ctx, dryRun)
+	if err != nil {
+		return err
+	}
+
+	return ctl.print(purgeResponse, func(w io.Writer) {
+		printPurge(w, purgeResponse)
+	})
+}
+
+// printPurge lists, for each volume a driver listed, what the purge did with it
+func printPurge(w io.Writer, purgeResponse volman.PurgeResponse) {
+	unmounted := "unmounted"
+	if purgeResponse.DryRun {
+		unmounted = "would unmount"
+	}
+
+	var driverIds []string
+	for driverId := range purgeResponse.Drivers {
+		driverIds = append(driverIds, driverId)
+	}
+	sort.Strings(driverIds)
+
+	fmt.Fprintln(w, "DRIVER\tVOLUME\tOUTCOME")
+	for _, driverId := range driverIds {
+		report := purgeResponse.Drivers[driverId]
+
+		switch {
+		case report.Skipped:
+			fmt.Fprintf(w, "%s\t-\tskipped, global scope\n", driverId)
+			continue
+		case report.ListError != "":
+			fmt.Fprintf(w, "%s\t-\tfailed listing volumes: %s\n", driverId, report.ListError)
+			continue
+		}
+
+		for _, volumeId := range report.Listed {
+			outcome := "kept, recorded by volman"
+			if failure, ok := report.Failed[volumeId]; ok {
+				outcome = "failed: " + failure
+			} else if containsString(report.Unmounted, volumeId) {
+				outcome = unmounted
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", driverId, volumeId, outcome)
+		}
+	}
+}
+
+func dash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"code.cloudfoundry.org/volman"
+)
+
+// explanation gathers what volman knows about one driver, with findings that point out what looks wrong
+type explanation struct {
+	Driver     string                  `json:"driver"`
+	Registered bool                    `json:"registered"`
+	Info       *volman.InfoResponse    `json:"info,omitempty"`
+	Collision  *volman.DriverCollision `json:"collision,omitempty"`
+
+	Mounts []volman.MountInfo `json:"mounts"`
+
+	// ListError is why the driver could not list its volumes
+	ListError string `json:"listError,omitempty"`
+
+	PendingUnmounts []volman.PendingUnmount `json:"pendingUnmounts"`
+	Findings        []string                `json:"findings"`
+}
+
+func (ctl *volmanctl) explain(args []string) error {
+	flags := flag.NewFlagSet("explain", flag.ContinueOnError)
+	args, err := parseFlags(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	driverId := args[0]
+
+	drivers, err := ctl.manager.ListDrivers(ctl.logger, ctl.ctx)
+	if err != nil {
+		return err
+	}
+
+	mounts, err := ctl.manager.ListMounts(ctl.logger, ctl.ctx, volman.ListMountsFilter{DriverId: driverId})
+	if err != nil {
+		return err
+	}
+
+	pending, err := ctl.manager.ListPendingUnmounts(ctl.logger, ctl.ctx)
+	if err != nil {
+		return err
+	}
+
+	explanation := explain(driverId, drivers, mounts, pending)
+
+	return ctl.print(explanation, func(w io.Writer) {
+		printExplanation(w, explanation)
+	})
+}
+
+func explain(driverId string, drivers volman.ListDriversResponse, mounts volman.ListMountsResponse, pending volman.ListPendingUnmountsResponse) explanation {
+	e := explanation{
+		Driver:          driverId,
+		Mounts:          mounts.Mounts,
+		ListError:       mounts.DriverErrors[driverId],
+		PendingUnmounts: []volman.PendingUnmount{},
+		Findings:        []string{},
+	}
+	if e.Mounts == nil {
+		e.Mounts = []volman.MountInfo{}
+	}
+
+	for i := range drivers.Drivers {
+		if drivers.Drivers[i].Name == driverId {
+			e.Registered = true
+			e.Info = &drivers.Drivers[i]
+		}
+	}
+	for i := range drivers.Collisions {
+		if drivers.Collisions[i].Name == driverId {
+			e.Collision = &drivers.Collisions[i]
+		}
+	}
+	for _, unmount := range pending.PendingUnmounts {
+		if unmount.DriverId == driverId {
+			e.PendingUnmounts = append(e.PendingUnmounts, unmount)
+		}
+	}
+
+	finding := func(format string, args ...interface{}) {
+		e.Findings = append(e.Findings, fmt.Sprintf(format, args...))
+	}
+
+	switch {
+	case !e.Registered && e.Collision != nil && e.Collision.Error != "":
+		finding("volman did not activate the driver: %s", e.Collision.Error)
+	case !e.Registered:
+		finding("volman has no driver named '%s'. Check that %s.sock, %s.spec or %s.json is in one of volman's driver paths and that the driver answers Activate, then run 'volmanctl discover'", driverId, driverId, driverId, driverId)
+	case e.Collision != nil:
+		finding("the driver is named by %d spec files, %s; volman activated it from %s", len(e.Collision.SpecFiles), strings.Join(e.Collision.SpecFiles, ", "), e.Collision.Chosen)
+	}
+
+	if e.ListError != "" {
+		finding("the driver failed to list its volumes, so mounts only it knows about are missing: %s", e.ListError)
+	}
+
+	for _, mount := range e.Mounts {
+		switch {
+		case mount.Recorded && mount.State != "" && mount.State != "mounted" && mount.MountedAt.IsZero():
+			// volman only records when a mount completed, so a mount still in flight has no time to show
+			finding("volume '%s' is still %s; volman finishes or rolls this back when it restarts", mount.VolumeId, mount.State)
+		case mount.Recorded && mount.State != "" && mount.State != "mounted":
+			finding("volume '%s', mounted since %s, is still %s; volman finishes or rolls this back when it restarts", mount.VolumeId, formatTime(mount.MountedAt), mount.State)
+		case mount.Recorded && !mount.Listed && e.ListError == "" && e.Registered:
+			finding("volume '%s' is mounted for %s as far as volman knows, but the driver does not list it; the driver may have lost the mount", mount.VolumeId, dash(strings.Join(mount.Owners, ",")))
+		case !mount.Recorded && mount.Listed:
+			finding("volume '%s' is listed by the driver but volman has no record of it; 'volmanctl purge --dry-run' shows whether a purge would unmount it", mount.VolumeId)
+		}
+	}
+
+	for _, unmount := range e.PendingUnmounts {
+		finding("unmounting volume '%s' has failed %d time(s), last with: %s; volman tries again at %s", unmount.VolumeId, unmount.Attempts, unmount.LastError, formatTime(unmount.NextAttemptAt))
+	}
+
+	if len(e.Findings) > 0 {
+		finding("TROUBLESHOOTING.md describes how to find the driver's own logs on the cell")
+	}
+
+	return e
+}
+
+func printExplanation(w io.Writer, e explanation) {
+	fmt.Fprintf(w, "Driver:\t%s\n", e.Driver)
+	fmt.Fprintf(w, "Registered:\t%t\n", e.Registered)
+	if e.Info != nil {
+		fmt.Fprintf(w, "Spec file:\t%s\n", dash(e.Info.SpecFile))
+		fmt.Fprintf(w, "Address:\t%s\n", dash(e.Info.Address))
+		fmt.Fprintf(w, "TLS:\t%t\n", e.Info.TLS)
+		fmt.Fprintf(w, "Scope:\t%s\n", dash(e.Info.Scope))
+		fmt.Fprintf(w, "Implements:\t%s\n", dash(strings.Join(e.Info.Implements, ",")))
+		fmt.Fprintf(w, "Discovered:\t%s\n", formatTime(e.Info.DiscoveredAt))
+		fmt.Fprintf(w, "Last healthy:\t%s\n", formatTime(e.Info.LastHealthyAt))
+		fmt.Fprintf(w, "Active mounts:\t%d\n", e.Info.ActiveMounts)
+	}
+
+	if len(e.Mounts) > 0 {
+		fmt.Fprintln(w)
+		printMounts(w, e.Mounts)
+	}
+
+	if len(e.PendingUnmounts) > 0 {
+		fmt.Fprintln(w, "\nPENDING UNMOUNT\tATTEMPTS\tFAILING SINCE\tNEXT ATTEMPT\tLAST ERROR")
+		for _, unmount := range e.PendingUnmounts {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", unmount.VolumeId, unmount.Attempts, formatTime(unmount.FirstFailedAt), formatTime(unmount.NextAttemptAt), unmount.LastError)
+		}
+	}
+
+	if len(e.Findings) > 0 {
+		fmt.Fprintln(w, "\nFindings:")
+		for _, finding := range e.Findings {
+			fmt.Fprintf(w, "  - %s\n", finding)
+		}
+	}
+}
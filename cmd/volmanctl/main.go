@@ -0,0 +1,239 @@
+// volmanctl shows operators on a cell what a running volman knows about its drivers and mounts, and lets them mount,
+// unmount, purge and rediscover drivers by hand, through volman's http or unix socket API.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/volman/volhttp"
+)
+
+const usage = `usage: volmanctl [flags] <command> [command flags] [args]
+
+mount, unmount, purge and discover wait on drivers, so by default they wait for as long as volman takes; a volman
+still working on a mount when volmanctl gives up finishes it regardless.
+
+commands:
+`
+
+// queryTimeout is how long commands that only read volman's state wait for it unless -timeout is given
+const queryTimeout = 30 * time.Second
+
+// usageError is returned by commands given arguments they can not make sense of
+type usageError struct {
+	message string
+}
+
+func (e usageError) Error() string {
+	return e.message
+}
+
+type command struct {
+	usage       string
+	description string
+	run         func(ctl *volmanctl, args []string) error
+
+	// waitsOnDrivers is set for commands that have volman call drivers, which may take longer than any default
+	// timeout of volmanctl's, so they are given no deadline unless -timeout is set
+	waitsOnDrivers bool
+}
+
+var commands = map[string]command{
+	"drivers": {
+		usage:       "drivers",
+		description: "list the drivers volman has registered and the spec files that name the same driver",
+		run:         (*volmanctl).drivers,
+	},
+	"mounts": {
+		usage:       "mounts [-driver <driver>] [-volume <volume>] [-container <container>]",
+		description: "list the mounts volman has recorded and the volumes drivers list as mounted",
+		run:         (*volmanctl).mounts,
+	},
+	"mount": {
+		usage:          "mount -driver <driver> -volume <volume> -container <container> [-config <json>] [-override]",
+		description:    "mount a volume for a container",
+		run:            (*volmanctl).mount,
+		waitsOnDrivers: true,
+	},
+	"unmount": {
+		usage:          "unmount -driver <driver> -volume <volume> -container <container>",
+		description:    "release a container's mount of a volume, unmounting it once no container uses it",
+		run:            (*volmanctl).unmount,
+		waitsOnDrivers: true,
+	},
+	"purge": {
+		usage:          "purge [--dry-run]",
+		description:    "unmount the volumes drivers list that volman has no record of",
+		run:            (*volmanctl).purge,
+		waitsOnDrivers: true,
+	},
+	"discover": {
+		usage:          "discover",
+		description:    "discover drivers now rather than at volman's next scan of its driver paths",
+		run:            (*volmanctl).discover,
+		waitsOnDrivers: true,
+	},
+	"explain": {
+		usage:       "explain <driver>",
+		description: "describe a driver, its mounts and pending unmounts, and point out what looks wrong",
+		run:         (*volmanctl).explain,
+	},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs the command line and returns the exit code: 0 on success, 1 when the command fails and 2 when the command
+// line is wrong
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("volmanctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	volmanURL := flags.String("url", os.Getenv("VOLMAN_URL"), "volman API url: http://host:port, https://host:port, tcp://host:port or unix:///path/to/volman.sock (defaults to $VOLMAN_URL)")
+	jsonOutput := flags.Bool("json", false, "print volman's responses as json rather than tables")
+	timeout := flags.Duration("timeout", 0, "how long to wait for volman to respond (defaults to 30s, and to no limit for mount, unmount, purge and discover)")
+	caCertFile := flags.String("ca-cert", "", "CA certificate to verify a volman served over tls")
+	clientCertFile := flags.String("client-cert", "", "client certificate to present to a volman served over tls")
+	clientKeyFile := flags.String("client-key", "", "key of the client certificate")
+	verbose := flags.Bool("verbose", false, "log the requests made to volman to stderr")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(stderr, "  %s\n        %s\n", commands[name].usage, commands[name].description)
+		}
+		fmt.Fprint(stderr, "\nflags:\n")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	name := flags.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "volmanctl: unknown command '%s'\n", name)
+		flags.Usage()
+		return 2
+	}
+
+	if *volmanURL == "" {
+		fmt.Fprintln(stderr, "volmanctl: no volman url, set -url or $VOLMAN_URL")
+		return 2
+	}
+
+	tlsConfig, err := newTLSConfig(*caCertFile, *clientCertFile, *clientKeyFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "volmanctl: %s\n", err.Error())
+		return 2
+	}
+
+	manager, err := volhttp.NewRemoteClient(*volmanURL, tlsConfig)
+	if err != nil {
+		fmt.Fprintf(stderr, "volmanctl: %s\n", err.Error())
+		return 2
+	}
+
+	logger := lager.NewLogger("volmanctl")
+	if *verbose {
+		logger.RegisterSink(lager.NewWriterSink(stderr, lager.DEBUG))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	switch {
+	case *timeout > 0:
+		ctx, cancel = context.WithTimeout(context.Background(), *timeout)
+	case !cmd.waitsOnDrivers:
+		ctx, cancel = context.WithTimeout(context.Background(), queryTimeout)
+	}
+	defer cancel()
+
+	ctl := &volmanctl{
+		logger:  logger,
+		ctx:     ctx,
+		manager: manager,
+		out:     stdout,
+		json:    *jsonOutput,
+	}
+
+	err = cmd.run(ctl, flags.Args()[1:])
+	if _, ok := err.(usageError); ok {
+		fmt.Fprintf(stderr, "volmanctl %s: %s\nusage: volmanctl [flags] %s\n", name, err.Error(), cmd.usage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "volmanctl %s: %s\n", name, err.Error())
+		return 1
+	}
+
+	return 0
+}
+
+// newTLSConfig returns the tls config to reach volman with, or nil when no certificates are given
+func newTLSConfig(caCertFile string, clientCertFile string, clientKeyFile string) (*tls.Config, error) {
+	if caCertFile == "" && clientCertFile == "" && clientKeyFile == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{}
+
+	if caCertFile != "" {
+		caCert, err := ioutil.ReadFile(caCertFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in '%s'", caCertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if clientCertFile != "" || clientKeyFile != "" {
+		if clientCertFile == "" || clientKeyFile == "" {
+			return nil, errors.New("both -client-cert and -client-key are needed")
+		}
+
+		cert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// parseFlags parses a command's flags, failing with a usage error on flags or arguments it does not expect
+func parseFlags(flags *flag.FlagSet, args []string, wantArgs int) ([]string, error) {
+	flags.SetOutput(ioutil.Discard)
+	if err := flags.Parse(args); err != nil {
+		return nil, usageError{err.Error()}
+	}
+	if flags.NArg() != wantArgs {
+		return nil, usageError{fmt.Sprintf("expected %d argument(s), got '%s'", wantArgs, strings.Join(flags.Args(), " "))}
+	}
+	return flags.Args(), nil
+}
@@ -0,0 +1,26 @@
+package main_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gexec"
+
+	"testing"
+)
+
+var volmanctlPath string
+
+func TestVolmanctl(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Volmanctl Suite")
+}
+
+var _ = BeforeSuite(func() {
+	var err error
+	volmanctlPath, err = gexec.Build("code.cloudfoundry.org/volman/cmd/volmanctl")
+	Expect(err).NotTo(HaveOccurred())
+})
+
+var _ = AfterSuite(func() {
+	gexec.CleanupBuildArtifacts()
+})
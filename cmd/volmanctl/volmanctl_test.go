@@ -0,0 +1,235 @@
+package main_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/volman"
+	"code.cloudfoundry.org/volman/volhttp"
+	"code.cloudfoundry.org/volman/volmanfakes"
+	"github.com/tedsuo/ifrit"
+	"github.com/tedsuo/ifrit/ginkgomon"
+)
+
+var _ = Describe("volmanctl", func() {
+	var (
+		fakeManager *volmanfakes.FakeManager
+		socketDir   string
+		volmanURL   string
+		process     ifrit.Process
+	)
+
+	BeforeEach(func() {
+		fakeManager = new(volmanfakes.FakeManager)
+		fakeManager.ListDriversReturns(volman.ListDriversResponse{
+			Drivers:    []volman.InfoResponse{{Name: "fakedriver", Address: "http://127.0.0.1:8750", SpecFile: "/var/vcap/data/voldrivers/fakedriver.json", ActiveMounts: 1}},
+			Collisions: []volman.DriverCollision{{Name: "otherdriver", SpecFiles: []string{"/a/otherdriver.sock", "/b/otherdriver.json"}, Error: "driver 'otherdriver' is named by 2 spec files"}},
+		}, nil)
+
+		var err error
+		socketDir, err = ioutil.TempDir("", "volmanctl")
+		Expect(err).NotTo(HaveOccurred())
+		volmanURL = "unix://" + filepath.Join(socketDir, "volman.sock")
+
+		process = ginkgomon.Invoke(volhttp.NewServer(lagertest.NewTestLogger("volmanctl-test"), volmanURL, fakeManager))
+	})
+
+	AfterEach(func() {
+		ginkgomon.Kill(process)
+		os.RemoveAll(socketDir)
+	})
+
+	volmanctl := func(args ...string) *gexec.Session {
+		command := exec.Command(volmanctlPath, append([]string{"-url", volmanURL}, args...)...)
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(session, 10*time.Second).Should(gexec.Exit())
+		return session
+	}
+
+	Context("drivers", func() {
+		It("lists the drivers and collisions as tables", func() {
+			session := volmanctl("drivers")
+			Expect(session.ExitCode()).To(Equal(0))
+			Expect(session.Out).To(gbytes.Say(`NAME\s+ADDRESS\s+SPEC FILE`))
+			Expect(session.Out).To(gbytes.Say(`fakedriver\s+http://127.0.0.1:8750\s+/var/vcap/data/voldrivers/fakedriver.json`))
+			Expect(session.Out).To(gbytes.Say(`otherdriver\s+-\s+/a/otherdriver.sock,/b/otherdriver.json`))
+		})
+
+		It("prints volman's response as json", func() {
+			session := volmanctl("-json", "drivers")
+			Expect(session.ExitCode()).To(Equal(0))
+
+			var drivers volman.ListDriversResponse
+			Expect(json.Unmarshal(session.Out.Contents(), &drivers)).To(Succeed())
+			Expect(drivers.Drivers).To(HaveLen(1))
+			Expect(drivers.Drivers[0].Name).To(Equal("fakedriver"))
+		})
+	})
+
+	Context("mounts", func() {
+		It("lists the mounts matching the filter", func() {
+			fakeManager.ListMountsReturns(volman.ListMountsResponse{Mounts: []volman.MountInfo{{DriverId: "fakedriver", VolumeId: "fake-volume", State: "mounted", Owners: []string{"some-container"}, Recorded: true, Listed: true}}}, nil)
+
+			session := volmanctl("mounts", "-driver", "fakedriver")
+			Expect(session.ExitCode()).To(Equal(0))
+			Expect(session.Out).To(gbytes.Say(`fakedriver\s+fake-volume\s+mounted`))
+
+			_, _, filter := fakeManager.ListMountsArgsForCall(0)
+			Expect(filter).To(Equal(volman.ListMountsFilter{DriverId: "fakedriver"}))
+		})
+	})
+
+	Context("mount", func() {
+		It("mounts the volume with its config and prints where", func() {
+			fakeManager.MountReturns(volman.MountResponse{Path: "/var/vcap/data/mounts/fake-volume"}, nil)
+
+			session := volmanctl("mount", "-driver", "fakedriver", "-volume", "fake-volume", "-container", "some-container", "-config", `{"source":"nfs://server/export"}`)
+			Expect(session.ExitCode()).To(Equal(0))
+			Expect(session.Out).To(gbytes.Say("/var/vcap/data/mounts/fake-volume"))
+
+			_, _, driverId, volumeId, containerId, config := fakeManager.MountArgsForCall(0)
+			Expect(driverId).To(Equal("fakedriver"))
+			Expect(volumeId).To(Equal("fake-volume"))
+			Expect(containerId).To(Equal("some-container"))
+			Expect(config).To(Equal(map[string]interface{}{"source": "nfs://server/export"}))
+		})
+
+		It("gives up on a mount after the timeout when one is given", func() {
+			fakeManager.MountStub = func(logger lager.Logger, ctx context.Context, driverId string, volumeId string, containerId string, config map[string]interface{}) (volman.MountResponse, error) {
+				time.Sleep(time.Second)
+				return volman.MountResponse{Path: "/var/vcap/data/mounts/fake-volume"}, nil
+			}
+
+			session := volmanctl("-timeout", "100ms", "mount", "-driver", "fakedriver", "-volume", "fake-volume", "-container", "some-container")
+			Expect(session.ExitCode()).To(Equal(1))
+			Expect(session.Err).To(gbytes.Say("volmanctl mount: .*timed out"))
+		})
+
+		It("refuses to mount without a container", func() {
+			session := volmanctl("mount", "-driver", "fakedriver", "-volume", "fake-volume")
+			Expect(session.ExitCode()).To(Equal(2))
+			Expect(session.Err).To(gbytes.Say("-driver, -volume and -container are required"))
+			Expect(fakeManager.MountCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("unmount", func() {
+		It("fails with volman's error", func() {
+			fakeManager.UnmountReturns(volman.DriverNotFoundError{DriverId: "fakedriver"})
+
+			session := volmanctl("unmount", "-driver", "fakedriver", "-volume", "fake-volume", "-container", "some-container")
+			Expect(session.ExitCode()).To(Equal(1))
+			Expect(session.Err).To(gbytes.Say("volmanctl unmount: .*fakedriver"))
+		})
+	})
+
+	Context("purge", func() {
+		It("reports what a dry run would unmount", func() {
+			fakeManager.PurgeReturns(volman.PurgeResponse{DryRun: true, Drivers: map[string]volman.DriverPurgeReport{
+				"fakedriver": {Listed: []string{"fake-volume", "orphaned-volume"}, Unmounted: []string{"orphaned-volume"}},
+			}}, nil)
+
+			session := volmanctl("purge", "--dry-run")
+			Expect(session.ExitCode()).To(Equal(0))
+			Expect(session.Out).To(gbytes.Say(`fakedriver\s+fake-volume\s+kept, recorded by volman`))
+			Expect(session.Out).To(gbytes.Say(`fakedriver\s+orphaned-volume\s+would unmount`))
+
+			_, _, dryRun := fakeManager.PurgeArgsForCall(0)
+			Expect(dryRun).To(BeTrue())
+		})
+	})
+
+	Context("discover", func() {
+		It("lists the drivers volman discovered", func() {
+			fakeManager.DiscoverReturns(volman.ListDriversResponse{Drivers: []volman.InfoResponse{{Name: "newdriver"}}}, nil)
+
+			session := volmanctl("discover")
+			Expect(session.ExitCode()).To(Equal(0))
+			Expect(session.Out).To(gbytes.Say("newdriver"))
+			Expect(fakeManager.DiscoverCallCount()).To(Equal(1))
+		})
+	})
+
+	Context("explain", func() {
+		BeforeEach(func() {
+			fakeManager.ListMountsReturns(volman.ListMountsResponse{Mounts: []volman.MountInfo{
+				{DriverId: "fakedriver", VolumeId: "lost-volume", State: "mounted", Owners: []string{"some-container"}, Recorded: true},
+				{DriverId: "fakedriver", VolumeId: "orphaned-volume", Listed: true},
+				{DriverId: "fakedriver", VolumeId: "mounting-volume", State: "mounting", Owners: []string{"some-container"}, Recorded: true},
+				{DriverId: "fakedriver", VolumeId: "unmounting-volume", State: "unmounting", MountedAt: time.Unix(1500000000, 0), Recorded: true, Listed: true},
+			}}, nil)
+			fakeManager.ListPendingUnmountsReturns(volman.ListPendingUnmountsResponse{PendingUnmounts: []volman.PendingUnmount{
+				{DriverId: "fakedriver", VolumeId: "stuck-volume", Attempts: 3, LastError: "device busy"},
+				{DriverId: "otherdriver", VolumeId: "other-volume", Attempts: 1, LastError: "badness"},
+			}}, nil)
+		})
+
+		It("points out the driver's mounts that have drifted and its failing unmounts", func() {
+			session := volmanctl("explain", "fakedriver")
+			Expect(session.ExitCode()).To(Equal(0))
+			Expect(session.Out).To(gbytes.Say(`Address:\s+http://127.0.0.1:8750`))
+			Expect(session.Out).To(gbytes.Say("stuck-volume"))
+			Expect(session.Out).To(gbytes.Say("'lost-volume' is mounted for some-container as far as volman knows, but the driver does not list it"))
+			Expect(session.Out).To(gbytes.Say("'orphaned-volume' is listed by the driver but volman has no record of it"))
+			Expect(session.Out).To(gbytes.Say(`volume 'mounting-volume' is still mounting; volman finishes`))
+			Expect(session.Out).To(gbytes.Say(`volume 'unmounting-volume', mounted since \S+, is still unmounting`))
+			Expect(session.Out).To(gbytes.Say("unmounting volume 'stuck-volume' has failed 3 time"))
+			Expect(session.Out).To(gbytes.Say("TROUBLESHOOTING.md"))
+
+			_, _, filter := fakeManager.ListMountsArgsForCall(0)
+			Expect(filter.DriverId).To(Equal("fakedriver"))
+		})
+
+		It("explains why a colliding driver was not activated", func() {
+			session := volmanctl("-json", "explain", "otherdriver")
+			Expect(session.ExitCode()).To(Equal(0))
+
+			var explanation struct {
+				Registered      bool                    `json:"registered"`
+				PendingUnmounts []volman.PendingUnmount `json:"pendingUnmounts"`
+				Findings        []string                `json:"findings"`
+			}
+			Expect(json.Unmarshal(session.Out.Contents(), &explanation)).To(Succeed())
+			Expect(explanation.Registered).To(BeFalse())
+			Expect(explanation.PendingUnmounts).To(HaveLen(1))
+			Expect(explanation.Findings[0]).To(Equal("volman did not activate the driver: driver 'otherdriver' is named by 2 spec files"))
+		})
+
+		It("needs a driver", func() {
+			session := volmanctl("explain")
+			Expect(session.ExitCode()).To(Equal(2))
+		})
+	})
+
+	Context("when volman fails", func() {
+		BeforeEach(func() {
+			fakeManager.ListDriversReturns(volman.ListDriversResponse{}, errors.New("badness"))
+		})
+
+		It("fails with the error", func() {
+			session := volmanctl("drivers")
+			Expect(session.ExitCode()).To(Equal(1))
+			Expect(session.Err).To(gbytes.Say("volmanctl drivers: badness"))
+		})
+	})
+
+	It("rejects unknown commands", func() {
+		session := volmanctl("frobnicate")
+		Expect(session.ExitCode()).To(Equal(2))
+		Expect(session.Err).To(gbytes.Say("unknown command 'frobnicate'"))
+	})
+})
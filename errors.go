@@ -0,0 +1,121 @@
+package volman
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeoutError is returned when volman stops waiting on a driver because the caller's context was cancelled or its
+// deadline passed.  The driver may still complete the operation.
+type TimeoutError struct {
+	DriverId  string
+	Operation string
+	Cause     string
+}
+
+func (e TimeoutError) Error() string {
+	if e.DriverId == "" {
+		return fmt.Sprintf("volman timed out waiting to %s: %s", e.Operation, e.Cause)
+	}
+	return fmt.Sprintf("volman timed out waiting for driver '%s' to %s: %s", e.DriverId, e.Operation, e.Cause)
+}
+
+// DriverError is returned when a driver responds to a request with an error.  Message is the driver's own error, which
+// may carry details such as share addresses that should not be shown to app developers; SafeDescription is fit to show
+// them.
+type DriverError struct {
+	DriverId        string
+	Operation       string
+	Message         string
+	SafeDescription string
+}
+
+func (e DriverError) Error() string {
+	return e.Message
+}
+
+// DriverNotFoundError is returned when volman has no registered driver with the requested id
+type DriverNotFoundError struct {
+	DriverId string
+}
+
+func (e DriverNotFoundError) Error() string {
+	return "Driver '" + e.DriverId + "' not found in list of known drivers"
+}
+
+// VolumeNotFoundError is returned when a driver can not find the requested volume
+type VolumeNotFoundError struct {
+	DriverId string
+	VolumeId string
+	Cause    string
+}
+
+func (e VolumeNotFoundError) Error() string {
+	return fmt.Sprintf("volume '%s' not found by driver '%s': %s", e.VolumeId, e.DriverId, e.Cause)
+}
+
+// MissingContainerIdError is returned when a volume is mounted or unmounted without saying for which container.
+// Volman counts a volume's mounts by container, so callers that do not name one would share a single mount.
+type MissingContainerIdError struct {
+	DriverId  string
+	VolumeId  string
+	Operation string
+}
+
+func (e MissingContainerIdError) Error() string {
+	return fmt.Sprintf("no container id given to %s volume '%s' on driver '%s'", e.Operation, e.VolumeId, e.DriverId)
+}
+
+// ConfigConflictError is returned when a volume that is already mounted is mounted again with a config that differs
+// from the one it was created with.  DifferingKeys names, in order, the keys that were changed or left out; their
+// values are never reported, as any of them may carry credentials.
+type ConfigConflictError struct {
+	DriverId      string
+	VolumeId      string
+	DifferingKeys []string
+}
+
+func (e ConfigConflictError) Error() string {
+	return fmt.Sprintf("volume '%s' on driver '%s' is already mounted with a different config: differing keys %s", e.VolumeId, e.DriverId, strings.Join(e.DifferingKeys, ", "))
+}
+
+// UnsafeMountpointError is returned when a driver mounts a volume somewhere volman will not hand to a container.
+// Volman unmounts the volume before returning it.
+type UnsafeMountpointError struct {
+	DriverId   string
+	VolumeId   string
+	Mountpoint string
+	Reason     string
+}
+
+func (e UnsafeMountpointError) Error() string {
+	return fmt.Sprintf("driver '%s' mounted volume '%s' at unsafe mountpoint '%s': %s", e.DriverId, e.VolumeId, e.Mountpoint, e.Reason)
+}
+
+// DriverUnhealthyError is returned without calling the driver while volman considers it unhealthy because too many of
+// its recent calls failed
+type DriverUnhealthyError struct {
+	DriverId   string
+	Failures   int
+	Calls      int
+	RetryAfter time.Duration
+}
+
+func (e DriverUnhealthyError) Error() string {
+	return fmt.Sprintf("driver '%s' is unhealthy: %d of its last %d calls failed", e.DriverId, e.Failures, e.Calls)
+}
+
+// IsRetryable reports whether an operation that failed with the error may succeed if it is tried again later, because
+// the driver was slow, unhealthy or not yet discovered.  Errors about the request itself, such as conflicting configs,
+// unknown volumes and unsafe mountpoints, are not retryable, and neither are errors returned by the driver.  Errors
+// wrapping one of these, including an Error received over the wire, are classified as the error they wrap.
+func IsRetryable(err error) bool {
+	return errors.As(err, new(TimeoutError)) || errors.As(err, new(DriverUnhealthyError)) || errors.As(err, new(DriverNotFoundError))
+}
+
+// IsNotFound reports whether the error is due to an unknown driver or volume
+func IsNotFound(err error) bool {
+	return errors.As(err, new(DriverNotFoundError)) || errors.As(err, new(VolumeNotFoundError))
+}
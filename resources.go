@@ -1,13 +1,41 @@
 package volman
 
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"time"
+)
+
 type ListDriversResponse struct {
 	Drivers []InfoResponse `json:"drivers"`
+
+	// Collisions lists the drivers named by more than one spec file across volman's driver paths
+	Collisions []DriverCollision `json:"collisions,omitempty"`
+}
+
+// DriverCollision describes several spec files that name the same driver
+type DriverCollision struct {
+	Name string `json:"name"`
+
+	// SpecFiles are the colliding spec files, in the order they were tried
+	SpecFiles []string `json:"specFiles"`
+
+	// Chosen is the spec file the driver was activated from, empty when none was
+	Chosen string `json:"chosen,omitempty"`
+
+	// Error is why the driver was not activated at all, such as the precedence policy refusing to choose
+	Error string `json:"error,omitempty"`
 }
 
 type MountRequest struct {
-	DriverId string                 `json:"driverId"`
-	VolumeId string                 `json:"volumeId"`
-	Config   map[string]interface{} `json:"config"`
+	DriverId    string                 `json:"driverId"`
+	VolumeId    string                 `json:"volumeId"`
+	ContainerId string                 `json:"containerId"`
+	Config      map[string]interface{} `json:"config"`
+
+	// OverrideConfig recreates a volume already mounted with a different config, as volman.WithConfigOverride does
+	OverrideConfig bool `json:"overrideConfig,omitempty"`
 }
 
 type MountResponse struct {
@@ -15,10 +43,191 @@ type MountResponse struct {
 }
 
 type InfoResponse struct {
-	Name string `json:"name"`
+	Name          string    `json:"name"`
+	SpecFile      string    `json:"specFile,omitempty"`
+	SpecFormat    string    `json:"specFormat,omitempty"`
+	Address       string    `json:"address,omitempty"`
+	TLS           bool      `json:"tls"`
+	Implements    []string  `json:"implements,omitempty"`
+	Scope         string    `json:"scope,omitempty"`
+	DiscoveredAt  time.Time `json:"discoveredAt"`
+	LastHealthyAt time.Time `json:"lastHealthyAt"`
+	ActiveMounts  int       `json:"activeMounts"`
+}
+
+// ListMountsFilter narrows the mounts listed to those matching every field that is set
+type ListMountsFilter struct {
+	DriverId    string `json:"driverId,omitempty"`
+	VolumeId    string `json:"volumeId,omitempty"`
+	ContainerId string `json:"containerId,omitempty"`
+}
+
+type ListMountsResponse struct {
+	Mounts []MountInfo `json:"mounts"`
+
+	// DriverErrors holds, by driver, why a driver could not be listed.  Mounts volman has recorded against such a
+	// driver are still listed, but mounts only the driver knows about are missing.
+	DriverErrors map[string]string `json:"driverErrors,omitempty"`
+}
+
+// MountInfo describes a mount known to volman, to the driver that made it, or to both.  A mount only one side knows
+// about is a sign that the two have drifted apart.
+type MountInfo struct {
+	DriverId   string    `json:"driverId"`
+	VolumeId   string    `json:"volumeId"`
+	Mountpoint string    `json:"mountpoint"`
+	Owners     []string  `json:"owners"`
+	MountedAt  time.Time `json:"mountedAt"`
+	State      string    `json:"state,omitempty"`
+
+	// Recorded is true when volman has a record of the mount
+	Recorded bool `json:"recorded"`
+	// Listed is true when the driver lists the volume as mounted
+	Listed bool `json:"listed"`
+}
+
+type ListPendingUnmountsResponse struct {
+	PendingUnmounts []PendingUnmount `json:"pendingUnmounts"`
+}
+
+// PendingUnmount describes an unmount a driver failed that volman is still retrying
+type PendingUnmount struct {
+	DriverId      string    `json:"driverId"`
+	VolumeId      string    `json:"volumeId"`
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"lastError"`
+	FirstFailedAt time.Time `json:"firstFailedAt"`
+	LastAttemptAt time.Time `json:"lastAttemptAt"`
+	NextAttemptAt time.Time `json:"nextAttemptAt"`
+}
+
+type PurgeRequest struct {
+	// DryRun only reports the volumes a purge would unmount
+	DryRun bool `json:"dryRun"`
+}
+
+type PurgeResponse struct {
+	DryRun  bool                         `json:"dryRun"`
+	Drivers map[string]DriverPurgeReport `json:"drivers"`
+}
+
+// DriverPurgeReport describes what a purge found and did for one driver.  Listed holds every volume the driver listed
+// as mounted, including those volman has a record of and so left alone.  In a dry run, Unmounted holds the volumes
+// the purge would have unmounted.
+type DriverPurgeReport struct {
+	// Skipped is true for drivers whose volumes are never purged, such as global scope drivers and those of unknown scope
+	Skipped bool `json:"skipped,omitempty"`
+
+	// ListError is why the driver's volumes could not be listed
+	ListError string `json:"listError,omitempty"`
+
+	Listed    []string `json:"listed"`
+	Unmounted []string `json:"unmounted"`
+
+	// Failed holds, by volume, why the driver failed to unmount it
+	Failed map[string]string `json:"failed,omitempty"`
 }
 
 type UnmountRequest struct {
+	DriverId    string `json:"driverId"`
+	VolumeId    string `json:"volumeId"`
+	ContainerId string `json:"containerId"`
+}
+
+type PathRequest struct {
 	DriverId string `json:"driverId"`
 	VolumeId string `json:"volumeId"`
 }
+
+// PathResponse holds where the driver has the volume mounted, which is empty when it is not mounted
+type PathResponse struct {
+	Path string `json:"path"`
+}
+
+type GetRequest struct {
+	DriverId string `json:"driverId"`
+	VolumeId string `json:"volumeId"`
+}
+
+type GetResponse struct {
+	Name       string `json:"name"`
+	Mountpoint string `json:"mountpoint"`
+}
+
+// Error is how errors are sent over the wire.  Errors of the types in this package also carry their type and fields so
+// that the receiver can recover them with Typed.
+type Error struct {
+	Description string          `json:"description"`
+	Type        string          `json:"type,omitempty"`
+	Details     json.RawMessage `json:"details,omitempty"`
+}
+
+func (e Error) Error() string {
+	return e.Description
+}
+
+// wireErrorTypes are the error types whose fields are sent over the wire, under the name of their type
+var wireErrorTypes = errorTypesByName(
+	TimeoutError{},
+	DriverError{},
+	DriverNotFoundError{},
+	VolumeNotFoundError{},
+	MissingContainerIdError{},
+	ConfigConflictError{},
+	UnsafeMountpointError{},
+	DriverUnhealthyError{},
+)
+
+func errorTypesByName(errs ...error) map[string]reflect.Type {
+	types := map[string]reflect.Type{}
+	for _, err := range errs {
+		errType := reflect.TypeOf(err)
+		types[errType.Name()] = errType
+	}
+	return types
+}
+
+// NewError returns the wire form of the error.  An error that wraps one of the types in this package is sent with the
+// type and fields of the outermost one it wraps.
+func NewError(err error) Error {
+	wireErr := Error{Description: err.Error()}
+
+	for typed := err; typed != nil; typed = errors.Unwrap(typed) {
+		errType := reflect.TypeOf(typed)
+		if wireErrorTypes[errType.Name()] != errType {
+			continue
+		}
+
+		details, marshalErr := json.Marshal(typed)
+		if marshalErr != nil {
+			return wireErr
+		}
+		wireErr.Type, wireErr.Details = errType.Name(), details
+		return wireErr
+	}
+
+	return wireErr
+}
+
+// Typed returns the error NewError was given, or the Error itself when its type is unknown
+func (e Error) Typed() error {
+	errType, ok := wireErrorTypes[e.Type]
+	if !ok {
+		return e
+	}
+
+	typed := reflect.New(errType)
+	if json.Unmarshal(e.Details, typed.Interface()) != nil {
+		return e
+	}
+	return typed.Elem().Interface().(error)
+}
+
+// Unwrap returns the typed error, so that errors.As finds the typed error behind an Error received over the wire
+func (e Error) Unwrap() error {
+	typed := e.Typed()
+	if _, untyped := typed.(Error); untyped {
+		return nil
+	}
+	return typed
+}
@@ -0,0 +1,27 @@
+package volman
+
+import "github.com/tedsuo/rata"
+
+const (
+	ListDriversRoute         = "drivers"
+	DiscoverRoute            = "discover"
+	ListMountsRoute          = "mounts"
+	ListPendingUnmountsRoute = "pending_unmounts"
+	MountRoute               = "mount"
+	UnmountRoute             = "unmount"
+	PathRoute                = "path"
+	GetRoute                 = "get"
+	PurgeRoute               = "purge"
+)
+
+var Routes = rata.Routes{
+	{Path: "/drivers", Method: "GET", Name: ListDriversRoute},
+	{Path: "/drivers/discover", Method: "POST", Name: DiscoverRoute},
+	{Path: "/mounts", Method: "GET", Name: ListMountsRoute},
+	{Path: "/unmounts/pending", Method: "GET", Name: ListPendingUnmountsRoute},
+	{Path: "/drivers/mount", Method: "POST", Name: MountRoute},
+	{Path: "/drivers/unmount", Method: "POST", Name: UnmountRoute},
+	{Path: "/drivers/path", Method: "POST", Name: PathRoute},
+	{Path: "/drivers/get", Method: "POST", Name: GetRoute},
+	{Path: "/mounts/purge", Method: "POST", Name: PurgeRoute},
+}
@@ -0,0 +1,286 @@
+package volhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/volman"
+	"github.com/tedsuo/rata"
+)
+
+// NewHandler returns an http.Handler serving the volman routes on behalf of the given manager
+func NewHandler(logger lager.Logger, client volman.Manager) (http.Handler, error) {
+	logger = logger.Session("server")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	var handlers = rata.Handlers{
+		volman.ListDriversRoute:         newListDriversHandler(logger, client),
+		volman.DiscoverRoute:            newDiscoverHandler(logger, client),
+		volman.ListMountsRoute:          newListMountsHandler(logger, client),
+		volman.ListPendingUnmountsRoute: newListPendingUnmountsHandler(logger, client),
+		volman.MountRoute:               newMountHandler(logger, client),
+		volman.UnmountRoute:             newUnmountHandler(logger, client),
+		volman.PathRoute:                newPathHandler(logger, client),
+		volman.GetRoute:                 newGetHandler(logger, client),
+		volman.PurgeRoute:               newPurgeHandler(logger, client),
+	}
+
+	return rata.NewRouter(volman.Routes, handlers)
+}
+
+func newListDriversHandler(logger lager.Logger, client volman.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		logger := logger.Session("list-drivers")
+		logger.Info("start")
+		defer logger.Info("end")
+
+		drivers, err := client.ListDrivers(logger, req.Context())
+		if err != nil {
+			logger.Error("failed-listing-drivers", err)
+			writeJSONResponse(logger, w, errorStatusCode(err), volman.NewError(err))
+			return
+		}
+
+		writeJSONResponse(logger, w, http.StatusOK, drivers)
+	}
+}
+
+func newDiscoverHandler(logger lager.Logger, client volman.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		logger := logger.Session("discover")
+		logger.Info("start")
+		defer logger.Info("end")
+
+		drivers, err := client.Discover(logger, req.Context())
+		if err != nil {
+			logger.Error("failed-discovering-drivers", err)
+			writeJSONResponse(logger, w, errorStatusCode(err), volman.NewError(err))
+			return
+		}
+
+		writeJSONResponse(logger, w, http.StatusOK, drivers)
+	}
+}
+
+func newListMountsHandler(logger lager.Logger, client volman.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		logger := logger.Session("list-mounts")
+		logger.Info("start")
+		defer logger.Info("end")
+
+		query := req.URL.Query()
+		filter := volman.ListMountsFilter{
+			DriverId:    query.Get("driverId"),
+			VolumeId:    query.Get("volumeId"),
+			ContainerId: query.Get("containerId"),
+		}
+
+		mounts, err := client.ListMounts(logger, req.Context(), filter)
+		if err != nil {
+			logger.Error("failed-listing-mounts", err)
+			writeJSONResponse(logger, w, errorStatusCode(err), volman.NewError(err))
+			return
+		}
+
+		writeJSONResponse(logger, w, http.StatusOK, mounts)
+	}
+}
+
+func newListPendingUnmountsHandler(logger lager.Logger, client volman.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		logger := logger.Session("list-pending-unmounts")
+		logger.Info("start")
+		defer logger.Info("end")
+
+		pending, err := client.ListPendingUnmounts(logger, req.Context())
+		if err != nil {
+			logger.Error("failed-listing-pending-unmounts", err)
+			writeJSONResponse(logger, w, errorStatusCode(err), volman.NewError(err))
+			return
+		}
+
+		writeJSONResponse(logger, w, http.StatusOK, pending)
+	}
+}
+
+func newMountHandler(logger lager.Logger, client volman.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		logger := logger.Session("mount")
+		logger.Info("start")
+		defer logger.Info("end")
+
+		var mountRequest volman.MountRequest
+		if err := unmarshalBody(req, &mountRequest); err != nil {
+			logger.Error("failed-reading-mount-request", err)
+			writeJSONResponse(logger, w, http.StatusBadRequest, volman.Error{Description: err.Error()})
+			return
+		}
+
+		if mountRequest.ContainerId == "" {
+			err := volman.MissingContainerIdError{DriverId: mountRequest.DriverId, VolumeId: mountRequest.VolumeId, Operation: "mount"}
+			logger.Error("invalid-mount-request", err)
+			writeJSONResponse(logger, w, http.StatusBadRequest, volman.NewError(err))
+			return
+		}
+
+		ctx := req.Context()
+		if mountRequest.OverrideConfig {
+			ctx = volman.WithConfigOverride(ctx)
+		}
+
+		mountResponse, err := client.Mount(logger, ctx, mountRequest.DriverId, mountRequest.VolumeId, mountRequest.ContainerId, mountRequest.Config)
+		if err != nil {
+			logger.Error("failed-mounting-volume", err, lager.Data{"driverId": mountRequest.DriverId, "volumeId": mountRequest.VolumeId})
+			writeJSONResponse(logger, w, errorStatusCode(err), volman.NewError(err))
+			return
+		}
+
+		writeJSONResponse(logger, w, http.StatusOK, mountResponse)
+	}
+}
+
+func newUnmountHandler(logger lager.Logger, client volman.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		logger := logger.Session("unmount")
+		logger.Info("start")
+		defer logger.Info("end")
+
+		var unmountRequest volman.UnmountRequest
+		if err := unmarshalBody(req, &unmountRequest); err != nil {
+			logger.Error("failed-reading-unmount-request", err)
+			writeJSONResponse(logger, w, http.StatusBadRequest, volman.Error{Description: err.Error()})
+			return
+		}
+
+		if unmountRequest.ContainerId == "" {
+			err := volman.MissingContainerIdError{DriverId: unmountRequest.DriverId, VolumeId: unmountRequest.VolumeId, Operation: "unmount"}
+			logger.Error("invalid-unmount-request", err)
+			writeJSONResponse(logger, w, http.StatusBadRequest, volman.NewError(err))
+			return
+		}
+
+		err := client.Unmount(logger, req.Context(), unmountRequest.DriverId, unmountRequest.VolumeId, unmountRequest.ContainerId)
+		if err != nil {
+			logger.Error("failed-unmounting-volume", err, lager.Data{"driverId": unmountRequest.DriverId, "volumeId": unmountRequest.VolumeId})
+			writeJSONResponse(logger, w, errorStatusCode(err), volman.NewError(err))
+			return
+		}
+
+		writeJSONResponse(logger, w, http.StatusOK, struct{}{})
+	}
+}
+
+func newPathHandler(logger lager.Logger, client volman.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		logger := logger.Session("path")
+		logger.Info("start")
+		defer logger.Info("end")
+
+		var pathRequest volman.PathRequest
+		if err := unmarshalBody(req, &pathRequest); err != nil {
+			logger.Error("failed-reading-path-request", err)
+			writeJSONResponse(logger, w, http.StatusBadRequest, volman.Error{Description: err.Error()})
+			return
+		}
+
+		pathResponse, err := client.Path(logger, req.Context(), pathRequest.DriverId, pathRequest.VolumeId)
+		if err != nil {
+			logger.Error("failed-getting-volume-path", err, lager.Data{"driverId": pathRequest.DriverId, "volumeId": pathRequest.VolumeId})
+			writeJSONResponse(logger, w, errorStatusCode(err), volman.NewError(err))
+			return
+		}
+
+		writeJSONResponse(logger, w, http.StatusOK, pathResponse)
+	}
+}
+
+func newGetHandler(logger lager.Logger, client volman.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		logger := logger.Session("get")
+		logger.Info("start")
+		defer logger.Info("end")
+
+		var getRequest volman.GetRequest
+		if err := unmarshalBody(req, &getRequest); err != nil {
+			logger.Error("failed-reading-get-request", err)
+			writeJSONResponse(logger, w, http.StatusBadRequest, volman.Error{Description: err.Error()})
+			return
+		}
+
+		getResponse, err := client.Get(logger, req.Context(), getRequest.DriverId, getRequest.VolumeId)
+		if err != nil {
+			logger.Error("failed-getting-volume", err, lager.Data{"driverId": getRequest.DriverId, "volumeId": getRequest.VolumeId})
+			writeJSONResponse(logger, w, errorStatusCode(err), volman.NewError(err))
+			return
+		}
+
+		writeJSONResponse(logger, w, http.StatusOK, getResponse)
+	}
+}
+
+func newPurgeHandler(logger lager.Logger, client volman.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		logger := logger.Session("purge")
+		logger.Info("start")
+		defer logger.Info("end")
+
+		var purgeRequest volman.PurgeRequest
+		if err := unmarshalBody(req, &purgeRequest); err != nil {
+			logger.Error("failed-reading-purge-request", err)
+			writeJSONResponse(logger, w, http.StatusBadRequest, volman.Error{Description: err.Error()})
+			return
+		}
+
+		purgeResponse, err := client.Purge(logger, req.Context(), purgeRequest.DryRun)
+		if err != nil {
+			logger.Error("failed-purging-mounts", err, lager.Data{"dryRun": purgeRequest.DryRun})
+			writeJSONResponse(logger, w, errorStatusCode(err), volman.NewError(err))
+			return
+		}
+
+		writeJSONResponse(logger, w, http.StatusOK, purgeResponse)
+	}
+}
+
+func errorStatusCode(err error) int {
+	switch {
+	case volman.IsNotFound(err):
+		return http.StatusNotFound
+	case errors.As(err, new(volman.MissingContainerIdError)):
+		return http.StatusBadRequest
+	case errors.As(err, new(volman.ConfigConflictError)):
+		return http.StatusConflict
+	case errors.As(err, new(volman.TimeoutError)):
+		return http.StatusGatewayTimeout
+	case errors.As(err, new(volman.DriverUnhealthyError)):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func unmarshalBody(req *http.Request, v interface{}) error {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+func writeJSONResponse(logger lager.Logger, w http.ResponseWriter, statusCode int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		logger.Error("failed-marshalling-response", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
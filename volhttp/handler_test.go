@@ -0,0 +1,407 @@
+package volhttp_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/volman"
+	"code.cloudfoundry.org/volman/volhttp"
+	"code.cloudfoundry.org/volman/volmanfakes"
+)
+
+var _ = Describe("Volman Handlers", func() {
+	var (
+		testLogger  *lagertest.TestLogger
+		fakeManager *volmanfakes.FakeManager
+		handler     http.Handler
+		recorder    *httptest.ResponseRecorder
+	)
+
+	BeforeEach(func() {
+		testLogger = lagertest.NewTestLogger("HandlersTest")
+		fakeManager = new(volmanfakes.FakeManager)
+		recorder = httptest.NewRecorder()
+
+		var err error
+		handler, err = volhttp.NewHandler(testLogger, fakeManager)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Context("ListDrivers", func() {
+		var request *http.Request
+
+		BeforeEach(func() {
+			var err error
+			request, err = http.NewRequest("GET", "/drivers", nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("returns the drivers listed by the manager", func() {
+			fakeManager.ListDriversReturns(volman.ListDriversResponse{Drivers: []volman.InfoResponse{{Name: "fakedriver"}}}, nil)
+
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+
+			var response volman.ListDriversResponse
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+			Expect(response.Drivers).To(ConsistOf(volman.InfoResponse{Name: "fakedriver"}))
+		})
+
+		It("returns a json error when the manager fails", func() {
+			fakeManager.ListDriversReturns(volman.ListDriversResponse{}, errors.New("badness"))
+
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+
+			var response volman.Error
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+			Expect(response.Description).To(Equal("badness"))
+		})
+	})
+
+	Context("ListMounts", func() {
+		It("passes the filter through to the manager", func() {
+			request, err := http.NewRequest("GET", "/mounts?driverId=fakedriver&containerId=some-container", nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+
+			Expect(fakeManager.ListMountsCallCount()).To(Equal(1))
+			_, _, filter := fakeManager.ListMountsArgsForCall(0)
+			Expect(filter).To(Equal(volman.ListMountsFilter{DriverId: "fakedriver", ContainerId: "some-container"}))
+		})
+
+		It("returns the mounts listed by the manager", func() {
+			fakeManager.ListMountsReturns(volman.ListMountsResponse{Mounts: []volman.MountInfo{{DriverId: "fakedriver", VolumeId: "fake-volume", Recorded: true}}}, nil)
+
+			request, err := http.NewRequest("GET", "/mounts", nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+
+			var response volman.ListMountsResponse
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+			Expect(response.Mounts).To(HaveLen(1))
+			Expect(response.Mounts[0].VolumeId).To(Equal("fake-volume"))
+			Expect(response.Mounts[0].Recorded).To(BeTrue())
+		})
+	})
+
+	Context("Discover", func() {
+		It("returns the drivers registered once the manager has discovered them", func() {
+			fakeManager.DiscoverReturns(volman.ListDriversResponse{Drivers: []volman.InfoResponse{{Name: "fakedriver"}}}, nil)
+
+			request, err := http.NewRequest("POST", "/drivers/discover", nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(fakeManager.DiscoverCallCount()).To(Equal(1))
+
+			var response volman.ListDriversResponse
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+			Expect(response.Drivers).To(HaveLen(1))
+			Expect(response.Drivers[0].Name).To(Equal("fakedriver"))
+		})
+	})
+
+	Context("ListPendingUnmounts", func() {
+		It("returns the unmounts the manager is still retrying", func() {
+			fakeManager.ListPendingUnmountsReturns(volman.ListPendingUnmountsResponse{PendingUnmounts: []volman.PendingUnmount{{DriverId: "fakedriver", VolumeId: "fake-volume", Attempts: 3, LastError: "badness"}}}, nil)
+
+			request, err := http.NewRequest("GET", "/unmounts/pending", nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+
+			var response volman.ListPendingUnmountsResponse
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+			Expect(response.PendingUnmounts).To(HaveLen(1))
+			Expect(response.PendingUnmounts[0].VolumeId).To(Equal("fake-volume"))
+			Expect(response.PendingUnmounts[0].Attempts).To(Equal(3))
+		})
+	})
+
+	Context("Purge", func() {
+		It("passes on a dry run and returns the report", func() {
+			fakeManager.PurgeReturns(volman.PurgeResponse{DryRun: true, Drivers: map[string]volman.DriverPurgeReport{"fakedriver": {Listed: []string{"fake-volume"}, Unmounted: []string{"fake-volume"}}}}, nil)
+
+			body, err := json.Marshal(volman.PurgeRequest{DryRun: true})
+			Expect(err).NotTo(HaveOccurred())
+			request, err := http.NewRequest("POST", "/mounts/purge", bytes.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+
+			Expect(fakeManager.PurgeCallCount()).To(Equal(1))
+			_, _, dryRun := fakeManager.PurgeArgsForCall(0)
+			Expect(dryRun).To(BeTrue())
+
+			var response volman.PurgeResponse
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+			Expect(response.DryRun).To(BeTrue())
+			Expect(response.Drivers["fakedriver"].Unmounted).To(Equal([]string{"fake-volume"}))
+		})
+	})
+
+	Context("Mount", func() {
+		var body []byte
+
+		BeforeEach(func() {
+			var err error
+			body, err = json.Marshal(volman.MountRequest{DriverId: "fakedriver", VolumeId: "fake-volume", ContainerId: "some-container", Config: map[string]interface{}{"volume_id": "fake-volume"}})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		JustBeforeEach(func() {
+			request, err := http.NewRequest("POST", "/drivers/mount", bytes.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+
+			handler.ServeHTTP(recorder, request)
+		})
+
+		Context("when the mount succeeds", func() {
+			BeforeEach(func() {
+				fakeManager.MountReturns(volman.MountResponse{Path: "/var/vcap/data/mounts/fake-volume"}, nil)
+			})
+
+			It("passes the request through to the manager", func() {
+				Expect(fakeManager.MountCallCount()).To(Equal(1))
+				_, ctx, driverId, volumeId, containerId, config := fakeManager.MountArgsForCall(0)
+				Expect(driverId).To(Equal("fakedriver"))
+				Expect(volumeId).To(Equal("fake-volume"))
+				Expect(containerId).To(Equal("some-container"))
+				Expect(config).To(Equal(map[string]interface{}{"volume_id": "fake-volume"}))
+				Expect(volman.ConfigOverride(ctx)).To(BeFalse())
+			})
+
+			It("returns the mount path", func() {
+				Expect(recorder.Code).To(Equal(http.StatusOK))
+
+				var response volman.MountResponse
+				Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+				Expect(response.Path).To(Equal("/var/vcap/data/mounts/fake-volume"))
+			})
+		})
+
+		Context("when the mount fails", func() {
+			BeforeEach(func() {
+				fakeManager.MountReturns(volman.MountResponse{}, errors.New("mount failed"))
+			})
+
+			It("returns a json error", func() {
+				Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+
+				var response volman.Error
+				Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+				Expect(response.Description).To(Equal("mount failed"))
+			})
+		})
+
+		Context("when the manager times out waiting on the driver", func() {
+			BeforeEach(func() {
+				fakeManager.MountReturns(volman.MountResponse{}, volman.TimeoutError{DriverId: "fakedriver", Operation: "mount", Cause: "context deadline exceeded"})
+			})
+
+			It("returns a gateway timeout", func() {
+				Expect(recorder.Code).To(Equal(http.StatusGatewayTimeout))
+			})
+		})
+
+		Context("when the request does not name a container", func() {
+			BeforeEach(func() {
+				var err error
+				body, err = json.Marshal(volman.MountRequest{DriverId: "fakedriver", VolumeId: "fake-volume"})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns a bad request without mounting", func() {
+				Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+				Expect(fakeManager.MountCallCount()).To(Equal(0))
+
+				var response volman.Error
+				Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+				Expect(response.Typed()).To(Equal(volman.MissingContainerIdError{DriverId: "fakedriver", VolumeId: "fake-volume", Operation: "mount"}))
+			})
+		})
+
+		Context("when the config conflicts with the mounted volume", func() {
+			BeforeEach(func() {
+				fakeManager.MountReturns(volman.MountResponse{}, volman.ConfigConflictError{DriverId: "fakedriver", VolumeId: "fake-volume"})
+			})
+
+			It("returns a conflict", func() {
+				Expect(recorder.Code).To(Equal(http.StatusConflict))
+			})
+		})
+
+		Context("when the manager wraps a typed error", func() {
+			BeforeEach(func() {
+				fakeManager.MountReturns(volman.MountResponse{}, fmt.Errorf("mounting fake-volume: %w", volman.ConfigConflictError{DriverId: "fakedriver", VolumeId: "fake-volume", DifferingKeys: []string{"uid"}}))
+			})
+
+			It("returns the status and type of the error it wraps", func() {
+				Expect(recorder.Code).To(Equal(http.StatusConflict))
+
+				var response volman.Error
+				Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+				Expect(response.Description).To(HavePrefix("mounting fake-volume: "))
+				Expect(response.Typed()).To(Equal(volman.ConfigConflictError{DriverId: "fakedriver", VolumeId: "fake-volume", DifferingKeys: []string{"uid"}}))
+				Expect(errors.As(response, new(volman.ConfigConflictError))).To(BeTrue())
+				Expect(volman.IsRetryable(fmt.Errorf("retrying: %w", response))).To(BeFalse())
+			})
+		})
+
+		Context("when the request overrides the config", func() {
+			BeforeEach(func() {
+				var err error
+				body, err = json.Marshal(volman.MountRequest{DriverId: "fakedriver", VolumeId: "fake-volume", ContainerId: "some-container", OverrideConfig: true})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("passes the override to the manager", func() {
+				_, ctx, _, _, _, _ := fakeManager.MountArgsForCall(0)
+				Expect(volman.ConfigOverride(ctx)).To(BeTrue())
+			})
+		})
+
+		Context("when the driver is unhealthy", func() {
+			BeforeEach(func() {
+				fakeManager.MountReturns(volman.MountResponse{}, volman.DriverUnhealthyError{DriverId: "fakedriver", Failures: 5, Calls: 5})
+			})
+
+			It("returns service unavailable", func() {
+				Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+			})
+		})
+
+		Context("when the request body is invalid", func() {
+			BeforeEach(func() {
+				body = []byte("not json")
+			})
+
+			It("returns a bad request without calling the manager", func() {
+				Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+				Expect(fakeManager.MountCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Context("Path", func() {
+		var body []byte
+
+		BeforeEach(func() {
+			var err error
+			body, err = json.Marshal(volman.PathRequest{DriverId: "fakedriver", VolumeId: "fake-volume"})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("returns the path from the manager", func() {
+			fakeManager.PathReturns(volman.PathResponse{Path: "/var/vcap/data/mounts/fake-volume"}, nil)
+
+			request, err := http.NewRequest("POST", "/drivers/path", bytes.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+
+			_, _, driverId, volumeId := fakeManager.PathArgsForCall(0)
+			Expect(driverId).To(Equal("fakedriver"))
+			Expect(volumeId).To(Equal("fake-volume"))
+
+			var response volman.PathResponse
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+			Expect(response.Path).To(Equal("/var/vcap/data/mounts/fake-volume"))
+		})
+
+		It("returns not found when the volume is not found", func() {
+			fakeManager.PathReturns(volman.PathResponse{}, volman.VolumeNotFoundError{DriverId: "fakedriver", VolumeId: "fake-volume"})
+
+			request, err := http.NewRequest("POST", "/drivers/path", bytes.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Context("Get", func() {
+		It("returns not found when the driver is not found", func() {
+			fakeManager.GetReturns(volman.GetResponse{}, volman.DriverNotFoundError{DriverId: "fakedriver"})
+
+			body, err := json.Marshal(volman.GetRequest{DriverId: "fakedriver", VolumeId: "fake-volume"})
+			Expect(err).NotTo(HaveOccurred())
+			request, err := http.NewRequest("POST", "/drivers/get", bytes.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusNotFound))
+
+			var response volman.Error
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+			Expect(response.Description).To(Equal("Driver 'fakedriver' not found in list of known drivers"))
+		})
+	})
+
+	Context("Unmount", func() {
+		var body []byte
+
+		BeforeEach(func() {
+			var err error
+			body, err = json.Marshal(volman.UnmountRequest{DriverId: "fakedriver", VolumeId: "fake-volume", ContainerId: "some-container"})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		JustBeforeEach(func() {
+			request, err := http.NewRequest("POST", "/drivers/unmount", bytes.NewReader(body))
+			Expect(err).NotTo(HaveOccurred())
+
+			handler.ServeHTTP(recorder, request)
+		})
+
+		It("passes the request through to the manager", func() {
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(fakeManager.UnmountCallCount()).To(Equal(1))
+			_, _, driverId, volumeId, containerId := fakeManager.UnmountArgsForCall(0)
+			Expect(driverId).To(Equal("fakedriver"))
+			Expect(volumeId).To(Equal("fake-volume"))
+			Expect(containerId).To(Equal("some-container"))
+		})
+
+		Context("when the unmount fails", func() {
+			BeforeEach(func() {
+				fakeManager.UnmountReturns(errors.New("unmount failed"))
+			})
+
+			It("returns a json error", func() {
+				Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+
+				var response volman.Error
+				Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+				Expect(response.Description).To(Equal("unmount failed"))
+			})
+		})
+
+		Context("when the request does not name a container", func() {
+			BeforeEach(func() {
+				var err error
+				body, err = json.Marshal(volman.UnmountRequest{DriverId: "fakedriver", VolumeId: "fake-volume"})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns a bad request without unmounting", func() {
+				Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+				Expect(fakeManager.UnmountCallCount()).To(Equal(0))
+			})
+		})
+	})
+})
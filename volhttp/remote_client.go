@@ -0,0 +1,267 @@
+package volhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/volman"
+	"github.com/tedsuo/rata"
+)
+
+const (
+	maxIdleConnsPerHost = 10
+	idleConnTimeout     = 90 * time.Second
+	dialTimeout         = 10 * time.Second
+)
+
+type remoteClient struct {
+	httpClient *http.Client
+	reqGen     *rata.RequestGenerator
+}
+
+// NewRemoteClient returns a volman.Manager that talks to a volman server at the given url.  The url may be
+// http://, https:// or tcp:// for a tcp listener or unix:///path/to/volman.sock for a unix socket.  The tls config
+// is optional and is used for https and for unix sockets served over tls.
+func NewRemoteClient(volmanURL string, tlsConfig *tls.Config) (volman.Manager, error) {
+	u, err := url.Parse(volmanURL)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{
+		MaxIdleConnsPerHost: maxIdleConnsPerHost,
+		IdleConnTimeout:     idleConnTimeout,
+		TLSClientConfig:     tlsConfig,
+	}
+
+	var host string
+	switch u.Scheme {
+	case "unix":
+		socketPath := u.Path
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{Timeout: dialTimeout}).DialContext(ctx, "unix", socketPath)
+		}
+		host = "http://volman"
+		if tlsConfig != nil {
+			host = "https://volman"
+		}
+	case "tcp":
+		host = "http://" + u.Host
+		if tlsConfig != nil {
+			host = "https://" + u.Host
+		}
+	case "http", "https":
+		host = u.Scheme + "://" + u.Host
+	default:
+		return nil, fmt.Errorf("unsupported volman url scheme '%s' in '%s'", u.Scheme, volmanURL)
+	}
+
+	return &remoteClient{
+		httpClient: &http.Client{Transport: transport},
+		reqGen:     rata.NewRequestGenerator(host, volman.Routes),
+	}, nil
+}
+
+func (r *remoteClient) ListDrivers(logger lager.Logger, ctx context.Context) (volman.ListDriversResponse, error) {
+	logger = logger.Session("list-drivers")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	var drivers volman.ListDriversResponse
+	if err := r.do(logger, ctx, "", "list drivers", volman.ListDriversRoute, nil, nil, &drivers); err != nil {
+		return volman.ListDriversResponse{}, err
+	}
+
+	return drivers, nil
+}
+
+func (r *remoteClient) Discover(logger lager.Logger, ctx context.Context) (volman.ListDriversResponse, error) {
+	logger = logger.Session("discover")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	var drivers volman.ListDriversResponse
+	if err := r.do(logger, ctx, "", "discover", volman.DiscoverRoute, nil, struct{}{}, &drivers); err != nil {
+		return volman.ListDriversResponse{}, err
+	}
+
+	return drivers, nil
+}
+
+func (r *remoteClient) ListMounts(logger lager.Logger, ctx context.Context, filter volman.ListMountsFilter) (volman.ListMountsResponse, error) {
+	logger = logger.Session("list-mounts", lager.Data{"filter": filter})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	query := url.Values{}
+	if filter.DriverId != "" {
+		query.Set("driverId", filter.DriverId)
+	}
+	if filter.VolumeId != "" {
+		query.Set("volumeId", filter.VolumeId)
+	}
+	if filter.ContainerId != "" {
+		query.Set("containerId", filter.ContainerId)
+	}
+
+	var mounts volman.ListMountsResponse
+	if err := r.do(logger, ctx, filter.DriverId, "list mounts", volman.ListMountsRoute, query, nil, &mounts); err != nil {
+		return volman.ListMountsResponse{}, err
+	}
+
+	return mounts, nil
+}
+
+func (r *remoteClient) ListPendingUnmounts(logger lager.Logger, ctx context.Context) (volman.ListPendingUnmountsResponse, error) {
+	logger = logger.Session("list-pending-unmounts")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	var pending volman.ListPendingUnmountsResponse
+	if err := r.do(logger, ctx, "", "list pending unmounts", volman.ListPendingUnmountsRoute, nil, nil, &pending); err != nil {
+		return volman.ListPendingUnmountsResponse{}, err
+	}
+
+	return pending, nil
+}
+
+func (r *remoteClient) Mount(logger lager.Logger, ctx context.Context, driverId string, volumeId string, containerId string, config map[string]interface{}) (volman.MountResponse, error) {
+	logger = logger.Session("mount", lager.Data{"driverId": driverId, "volumeId": volumeId, "containerId": containerId})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	mountRequest := volman.MountRequest{DriverId: driverId, VolumeId: volumeId, ContainerId: containerId, Config: config, OverrideConfig: volman.ConfigOverride(ctx)}
+
+	var mountResponse volman.MountResponse
+	if err := r.do(logger, ctx, driverId, "mount", volman.MountRoute, nil, mountRequest, &mountResponse); err != nil {
+		return volman.MountResponse{}, err
+	}
+
+	return mountResponse, nil
+}
+
+func (r *remoteClient) Unmount(logger lager.Logger, ctx context.Context, driverId string, volumeId string, containerId string) error {
+	logger = logger.Session("unmount", lager.Data{"driverId": driverId, "volumeId": volumeId, "containerId": containerId})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	unmountRequest := volman.UnmountRequest{DriverId: driverId, VolumeId: volumeId, ContainerId: containerId}
+
+	return r.do(logger, ctx, driverId, "unmount", volman.UnmountRoute, nil, unmountRequest, nil)
+}
+
+func (r *remoteClient) Path(logger lager.Logger, ctx context.Context, driverId string, volumeId string) (volman.PathResponse, error) {
+	logger = logger.Session("path", lager.Data{"driverId": driverId, "volumeId": volumeId})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	pathRequest := volman.PathRequest{DriverId: driverId, VolumeId: volumeId}
+
+	var pathResponse volman.PathResponse
+	if err := r.do(logger, ctx, driverId, "path", volman.PathRoute, nil, pathRequest, &pathResponse); err != nil {
+		return volman.PathResponse{}, err
+	}
+
+	return pathResponse, nil
+}
+
+func (r *remoteClient) Get(logger lager.Logger, ctx context.Context, driverId string, volumeId string) (volman.GetResponse, error) {
+	logger = logger.Session("get", lager.Data{"driverId": driverId, "volumeId": volumeId})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	getRequest := volman.GetRequest{DriverId: driverId, VolumeId: volumeId}
+
+	var getResponse volman.GetResponse
+	if err := r.do(logger, ctx, driverId, "get", volman.GetRoute, nil, getRequest, &getResponse); err != nil {
+		return volman.GetResponse{}, err
+	}
+
+	return getResponse, nil
+}
+
+func (r *remoteClient) Purge(logger lager.Logger, ctx context.Context, dryRun bool) (volman.PurgeResponse, error) {
+	logger = logger.Session("purge", lager.Data{"dryRun": dryRun})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	purgeRequest := volman.PurgeRequest{DryRun: dryRun}
+
+	var purgeResponse volman.PurgeResponse
+	if err := r.do(logger, ctx, "", "purge", volman.PurgeRoute, nil, purgeRequest, &purgeResponse); err != nil {
+		return volman.PurgeResponse{}, err
+	}
+
+	return purgeResponse, nil
+}
+
+// do sends the query and request body to the named route and decodes a successful response into the result.  Error bodies
+// from the server are returned as the typed volman error they describe, falling back to volman.Error, and requests
+// abandoned because the context is done as volman.TimeoutError.
+func (r *remoteClient) do(logger lager.Logger, ctx context.Context, driverId string, operation string, route string, query url.Values, body interface{}, result interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			logger.Error("failed-marshalling-request", err)
+			return err
+		}
+	}
+
+	request, err := r.reqGen.CreateRequest(route, nil, bytes.NewReader(payload))
+	if err != nil {
+		logger.Error("failed-creating-request", err)
+		return err
+	}
+	request.URL.RawQuery = query.Encode()
+	request.Header.Set("Content-Type", "application/json")
+	request = request.WithContext(ctx)
+
+	response, err := r.httpClient.Do(request)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = volman.TimeoutError{DriverId: driverId, Operation: operation, Cause: ctxErr.Error()}
+		}
+		logger.Error("failed-sending-request", err)
+		return err
+	}
+	defer response.Body.Close()
+
+	responseBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		logger.Error("failed-reading-response", err)
+		return err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		var volmanErr volman.Error
+		if err := json.Unmarshal(responseBytes, &volmanErr); err != nil || volmanErr.Description == "" {
+			err = fmt.Errorf("volman responded with status %d: %s", response.StatusCode, string(responseBytes))
+			logger.Error("unexpected-response", err)
+			return err
+		}
+		logger.Error("volman-error", volmanErr)
+		return volmanErr.Typed()
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(responseBytes, result); err != nil {
+		logger.Error("failed-unmarshalling-response", err)
+		return err
+	}
+
+	return nil
+}
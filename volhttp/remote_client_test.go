@@ -0,0 +1,293 @@
+package volhttp_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	mfakes "code.cloudfoundry.org/go-loggregator/loggregator_v2/fakes"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/voldriver"
+	"code.cloudfoundry.org/voldriver/voldriverfakes"
+	"code.cloudfoundry.org/volman"
+	"code.cloudfoundry.org/volman/volhttp"
+	"code.cloudfoundry.org/volman/vollocal"
+	"github.com/tedsuo/ifrit"
+	"github.com/tedsuo/ifrit/ginkgomon"
+)
+
+var _ = Describe("Volman Remote Client", func() {
+	var (
+		testLogger *lagertest.TestLogger
+		fakeDriver *voldriverfakes.FakeDriver
+		localMgr   volman.Manager
+		client     volman.Manager
+		volumeId   string
+	)
+
+	BeforeEach(func() {
+		testLogger = lagertest.NewTestLogger("RemoteClientTest")
+		volumeId = "fake-volume"
+
+		fakeDriver = new(voldriverfakes.FakeDriver)
+		fakeDriver.MountReturns(voldriver.MountResponse{Mountpoint: "/var/vcap/data/mounts/" + volumeId})
+
+		registry := vollocal.NewDriverRegistryWith(map[string]voldriver.Driver{"fakedriver": fakeDriver})
+		localMgr = vollocal.NewLocalClient(testLogger, registry, new(mfakes.FakeClient), fakeclock.NewFakeClock(time.Unix(123, 456)))
+	})
+
+	behavesLikeAManager := func() {
+		It("should report the registered drivers", func() {
+			drivers, err := client.ListDrivers(testLogger, context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(drivers.Drivers).To(HaveLen(1))
+			Expect(drivers.Drivers[0].Name).To(Equal("fakedriver"))
+		})
+
+		It("should be able to mount", func() {
+			mountResponse, err := client.Mount(testLogger, context.Background(), "fakedriver", volumeId, "some-container", map[string]interface{}{"volume_id": volumeId})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mountResponse.Path).To(Equal("/var/vcap/data/mounts/" + volumeId))
+
+			Expect(fakeDriver.CreateCallCount()).To(Equal(1))
+			_, createRequest := fakeDriver.CreateArgsForCall(0)
+			Expect(createRequest.Name).To(Equal(volumeId))
+			Expect(createRequest.Opts).To(Equal(map[string]interface{}{"volume_id": volumeId}))
+			Expect(fakeDriver.MountCallCount()).To(Equal(1))
+		})
+
+		It("should not be able to mount if mount fails", func() {
+			fakeDriver.MountReturns(voldriver.MountResponse{Err: "an error"})
+
+			_, err := client.Mount(testLogger, context.Background(), "fakedriver", volumeId, "some-container", map[string]interface{}{"volume_id": volumeId})
+			Expect(err).To(MatchError("an error"))
+			Expect(err).To(BeAssignableToTypeOf(volman.DriverError{}))
+			Expect(err.(volman.DriverError).SafeDescription).To(Equal("driver 'fakedriver' failed to mount"))
+		})
+
+		It("should not be able to mount if create fails", func() {
+			fakeDriver.CreateReturns(voldriver.ErrorResponse{Err: "create fails"})
+
+			_, err := client.Mount(testLogger, context.Background(), "fakedriver", volumeId, "some-container", map[string]interface{}{"volume_id": volumeId})
+			Expect(err).To(MatchError("create fails"))
+			Expect(fakeDriver.MountCallCount()).To(Equal(0))
+		})
+
+		It("should list mounts matching the filter", func() {
+			_, err := client.Mount(testLogger, context.Background(), "fakedriver", volumeId, "some-container", map[string]interface{}{"volume_id": volumeId})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = client.Mount(testLogger, context.Background(), "fakedriver", "other-volume", "other-container", map[string]interface{}{"volume_id": "other-volume"})
+			Expect(err).NotTo(HaveOccurred())
+
+			mounts, err := client.ListMounts(testLogger, context.Background(), volman.ListMountsFilter{ContainerId: "some-container"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mounts.Mounts).To(HaveLen(1))
+			Expect(mounts.Mounts[0].VolumeId).To(Equal(volumeId))
+			Expect(mounts.Mounts[0].Owners).To(Equal([]string{"some-container"}))
+		})
+
+		It("should pass on that the manager can neither discover drivers nor purge mounts", func() {
+			_, err := client.Discover(testLogger, context.Background())
+			Expect(err).To(MatchError("driver discovery is not available"))
+
+			_, err = client.Purge(testLogger, context.Background(), true)
+			Expect(err).To(MatchError("purging mounts is not available"))
+		})
+
+		It("should list no pending unmounts when every unmount succeeded", func() {
+			pending, err := client.ListPendingUnmounts(testLogger, context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pending.PendingUnmounts).To(BeEmpty())
+		})
+
+		It("should look up a volume's path and details", func() {
+			fakeDriver.PathReturns(voldriver.PathResponse{Mountpoint: "/var/vcap/data/mounts/" + volumeId})
+			fakeDriver.GetReturns(voldriver.GetResponse{Volume: voldriver.VolumeInfo{Name: volumeId, Mountpoint: "/var/vcap/data/mounts/" + volumeId}})
+
+			pathResponse, err := client.Path(testLogger, context.Background(), "fakedriver", volumeId)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pathResponse.Path).To(Equal("/var/vcap/data/mounts/" + volumeId))
+
+			getResponse, err := client.Get(testLogger, context.Background(), "fakedriver", volumeId)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(getResponse.Name).To(Equal(volumeId))
+			Expect(getResponse.Mountpoint).To(Equal("/var/vcap/data/mounts/" + volumeId))
+		})
+
+		It("should be able to unmount", func() {
+			err := client.Unmount(testLogger, context.Background(), "fakedriver", volumeId, "some-container")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeDriver.UnmountCallCount()).To(Equal(1))
+			Expect(fakeDriver.RemoveCallCount()).To(Equal(0))
+		})
+
+		It("should not be able to unmount when driver unmount fails", func() {
+			fakeDriver.UnmountReturns(voldriver.ErrorResponse{Err: "unmount failure"})
+
+			err := client.Unmount(testLogger, context.Background(), "fakedriver", volumeId, "some-container")
+			Expect(err).To(MatchError("unmount failure"))
+		})
+
+		Context("when driver is not found", func() {
+			It("should not be able to mount", func() {
+				_, err := client.Mount(testLogger, context.Background(), "unknowndriver", volumeId, "some-container", map[string]interface{}{"volume_id": volumeId})
+				Expect(err).To(Equal(volman.DriverNotFoundError{DriverId: "unknowndriver"}))
+				Expect(volman.IsNotFound(err)).To(BeTrue())
+				Expect(volman.IsRetryable(err)).To(BeTrue())
+				Expect(volman.IsNotFound(fmt.Errorf("mounting: %w", err))).To(BeTrue())
+			})
+
+			It("should not be able to unmount", func() {
+				err := client.Unmount(testLogger, context.Background(), "unknowndriver", volumeId, "some-container")
+				Expect(err).To(MatchError("Driver 'unknowndriver' not found in list of known drivers"))
+			})
+		})
+	}
+
+	Context("over tcp", func() {
+		var process ifrit.Process
+
+		BeforeEach(func() {
+			listenAddress := fmt.Sprintf("127.0.0.1:%d", 9900+GinkgoParallelNode())
+			process = ginkgomon.Invoke(volhttp.NewServer(testLogger, listenAddress, localMgr))
+
+			var err error
+			client, err = volhttp.NewRemoteClient("http://"+listenAddress, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			ginkgomon.Kill(process)
+		})
+
+		behavesLikeAManager()
+	})
+
+	Context("over a unix socket", func() {
+		var (
+			process   ifrit.Process
+			socketDir string
+		)
+
+		BeforeEach(func() {
+			var err error
+			socketDir, err = ioutil.TempDir("", "volhttp")
+			Expect(err).NotTo(HaveOccurred())
+			socketPath := filepath.Join(socketDir, "volman.sock")
+
+			process = ginkgomon.Invoke(volhttp.NewServer(testLogger, "unix://"+socketPath, localMgr))
+
+			client, err = volhttp.NewRemoteClient("unix://"+socketPath, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			ginkgomon.Kill(process)
+			os.RemoveAll(socketDir)
+		})
+
+		behavesLikeAManager()
+	})
+
+	Context("over tls", func() {
+		var server *httptest.Server
+
+		BeforeEach(func() {
+			handler, err := volhttp.NewHandler(testLogger, localMgr)
+			Expect(err).NotTo(HaveOccurred())
+			server = httptest.NewTLSServer(handler)
+
+			certPool := x509.NewCertPool()
+			certPool.AddCert(server.Certificate())
+
+			client, err = volhttp.NewRemoteClient(server.URL, &tls.Config{RootCAs: certPool})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		behavesLikeAManager()
+
+		It("should fail when the server certificate is not trusted", func() {
+			untrusted, err := volhttp.NewRemoteClient(server.URL, &tls.Config{})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = untrusted.ListDrivers(testLogger, context.Background())
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("when the server does not return a volman error", func() {
+		var server *httptest.Server
+
+		BeforeEach(func() {
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+				w.Write([]byte("upstream gone"))
+			}))
+
+			var err error
+			client, err = volhttp.NewRemoteClient(server.URL, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("returns an error describing the response", func() {
+			_, err := client.ListDrivers(testLogger, context.Background())
+			Expect(err).To(MatchError("volman responded with status 502: upstream gone"))
+		})
+	})
+
+	Context("when the server does not respond before the context is done", func() {
+		var (
+			server  *httptest.Server
+			release chan struct{}
+		)
+
+		BeforeEach(func() {
+			release = make(chan struct{})
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				<-release
+			}))
+
+			var err error
+			client, err = volhttp.NewRemoteClient(server.URL, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			close(release)
+			server.Close()
+		})
+
+		It("returns a timeout error", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			err := client.Unmount(testLogger, ctx, "fakedriver", "fake-volume", "some-container")
+			Expect(err).To(BeAssignableToTypeOf(volman.TimeoutError{}))
+			Expect(err.(volman.TimeoutError).Operation).To(Equal("unmount"))
+		})
+	})
+
+	It("rejects unsupported url schemes", func() {
+		_, err := volhttp.NewRemoteClient("ftp://somewhere", nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
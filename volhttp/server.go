@@ -0,0 +1,76 @@
+package volhttp
+
+import (
+	"crypto/tls"
+	"os"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/volman"
+	"github.com/tedsuo/ifrit"
+	"github.com/tedsuo/ifrit/http_server"
+)
+
+const unixScheme = "unix://"
+
+type server struct {
+	logger        lager.Logger
+	listenAddress string
+	client        volman.Manager
+	tlsConfig     *tls.Config
+}
+
+// NewServer returns an ifrit runner serving the volman API for the given manager.  The listen address is either a
+// tcp host:port or a unix socket given as unix:///path/to/volman.sock
+func NewServer(logger lager.Logger, listenAddress string, client volman.Manager) ifrit.Runner {
+	return &server{
+		logger:        logger,
+		listenAddress: listenAddress,
+		client:        client,
+	}
+}
+
+// NewTLSServer is like NewServer but serves the volman API over tls on either a tcp or a unix socket listener
+func NewTLSServer(logger lager.Logger, listenAddress string, tlsConfig *tls.Config, client volman.Manager) ifrit.Runner {
+	return &server{
+		logger:        logger,
+		listenAddress: listenAddress,
+		client:        client,
+		tlsConfig:     tlsConfig,
+	}
+}
+
+func (s *server) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	logger := s.logger.Session("volman-server", lager.Data{"listenAddress": s.listenAddress})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	handler, err := NewHandler(logger, s.client)
+	if err != nil {
+		logger.Error("failed-creating-handler", err)
+		return err
+	}
+
+	var runner ifrit.Runner
+	if strings.HasPrefix(s.listenAddress, unixScheme) {
+		socketPath := strings.TrimPrefix(s.listenAddress, unixScheme)
+
+		// a socket left behind by a previous volman would otherwise make the listen fail
+		if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+			logger.Error("failed-removing-stale-socket", err)
+			return err
+		}
+
+		if s.tlsConfig != nil {
+			runner = http_server.NewUnixTLSServer(socketPath, handler, s.tlsConfig)
+		} else {
+			runner = http_server.NewUnixServer(socketPath, handler)
+		}
+	} else if s.tlsConfig != nil {
+		runner = http_server.NewTLSServer(s.listenAddress, handler, s.tlsConfig)
+	} else {
+		runner = http_server.New(s.listenAddress, handler)
+	}
+
+	return runner.Run(signals, ready)
+}
@@ -0,0 +1,104 @@
+package volhttp_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/volman"
+	"code.cloudfoundry.org/volman/volhttp"
+	"code.cloudfoundry.org/volman/volmanfakes"
+	"github.com/tedsuo/ifrit"
+	"github.com/tedsuo/ifrit/ginkgomon"
+)
+
+var _ = Describe("Volman Server", func() {
+	var (
+		testLogger  *lagertest.TestLogger
+		fakeManager *volmanfakes.FakeManager
+		process     ifrit.Process
+	)
+
+	BeforeEach(func() {
+		testLogger = lagertest.NewTestLogger("ServerTest")
+		fakeManager = new(volmanfakes.FakeManager)
+		fakeManager.ListDriversReturns(volman.ListDriversResponse{Drivers: []volman.InfoResponse{{Name: "fakedriver"}}}, nil)
+	})
+
+	AfterEach(func() {
+		ginkgomon.Kill(process)
+	})
+
+	Context("when listening on tcp", func() {
+		var listenAddress string
+
+		BeforeEach(func() {
+			listenAddress = fmt.Sprintf("127.0.0.1:%d", 9950+GinkgoParallelNode())
+			process = ginkgomon.Invoke(volhttp.NewServer(testLogger, listenAddress, fakeManager))
+		})
+
+		It("serves the volman api", func() {
+			response, err := http.Get("http://" + listenAddress + "/drivers")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(http.StatusOK))
+			Expect(fakeManager.ListDriversCallCount()).To(Equal(1))
+		})
+	})
+
+	Context("when listening on a unix socket", func() {
+		var (
+			socketDir  string
+			socketPath string
+			httpClient *http.Client
+		)
+
+		BeforeEach(func() {
+			var err error
+			socketDir, err = ioutil.TempDir("", "volhttp")
+			Expect(err).NotTo(HaveOccurred())
+			socketPath = filepath.Join(socketDir, "volman.sock")
+
+			httpClient = &http.Client{
+				Transport: &http.Transport{
+					DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+						return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+					},
+				},
+			}
+		})
+
+		JustBeforeEach(func() {
+			process = ginkgomon.Invoke(volhttp.NewServer(testLogger, "unix://"+socketPath, fakeManager))
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(socketDir)
+		})
+
+		It("serves the volman api", func() {
+			response, err := httpClient.Get("http://volman/drivers")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(http.StatusOK))
+		})
+
+		Context("when a stale socket exists", func() {
+			BeforeEach(func() {
+				Expect(ioutil.WriteFile(socketPath, []byte{}, 0600)).To(Succeed())
+			})
+
+			It("replaces it", func() {
+				response, err := httpClient.Get("http://volman/drivers")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(response.StatusCode).To(Equal(http.StatusOK))
+			})
+		})
+	})
+})
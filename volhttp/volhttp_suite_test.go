@@ -0,0 +1,13 @@
+package volhttp_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestVolhttp(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Volhttp Suite")
+}
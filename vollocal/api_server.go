@@ -0,0 +1,91 @@
+package vollocal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/volman"
+	"code.cloudfoundry.org/volman/volhttp"
+	"github.com/tedsuo/ifrit"
+)
+
+// ListenTLSConfig is the tls the volman API is served with
+type ListenTLSConfig struct {
+	CertFile string
+	KeyFile  string
+
+	// CAFile, when set, has volman require clients to present a certificate signed by one of its CAs
+	CAFile string
+}
+
+// newAPIServer returns the runner serving the volman API at the listen address.  Without tls, volman refuses to
+// serve the API anywhere but a unix socket or a loopback address, since anything that can reach it can mount and
+// unmount volumes.
+func newAPIServer(logger lager.Logger, listenAddress string, listenTLS *ListenTLSConfig, client volman.Manager) (ifrit.Runner, error) {
+	if listenTLS == nil {
+		if !isLocalListenAddress(listenAddress) {
+			return nil, fmt.Errorf("refusing to serve the volman API without tls on '%s': listen on a unix:// socket or a loopback address, or set ListenTLS", listenAddress)
+		}
+		return volhttp.NewServer(logger, listenAddress, client), nil
+	}
+
+	tlsConfig, err := listenTLS.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	return volhttp.NewTLSServer(logger, listenAddress, tlsConfig, client), nil
+}
+
+func (c *ListenTLSConfig) tlsConfig() (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("ListenTLS needs both a CertFile and a KeyFile")
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.CAFile != "" {
+		caCert, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in '%s'", c.CAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// isLocalListenAddress reports whether only processes on the cell can reach the listen address
+func isLocalListenAddress(listenAddress string) bool {
+	if strings.HasPrefix(listenAddress, "unix://") {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(listenAddress)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,96 @@
+package vollocal_test
+
+import (
+	"context"
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/volman/volhttp"
+	"code.cloudfoundry.org/volman/vollocal"
+	"github.com/tedsuo/ifrit"
+	"github.com/tedsuo/ifrit/ginkgomon"
+)
+
+var _ = Describe("API Server", func() {
+	var (
+		logger  *lagertest.TestLogger
+		config  vollocal.DriverConfig
+		port    string
+		runner  ifrit.Runner
+		process ifrit.Process
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("api-server")
+		process = nil
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		_, port, err = net.SplitHostPort(listener.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(listener.Close()).To(Succeed())
+
+		config = vollocal.NewDriverConfig()
+		config.DriverPaths = []string{defaultPluginsDirectory}
+	})
+
+	JustBeforeEach(func() {
+		_, runner = vollocal.NewServer(logger, nil, config)
+	})
+
+	AfterEach(func() {
+		if process != nil {
+			ginkgomon.Kill(process)
+		}
+	})
+
+	listDrivers := func() error {
+		client, err := volhttp.NewRemoteClient("http://127.0.0.1:"+port, nil)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = client.ListDrivers(logger, context.Background())
+		return err
+	}
+
+	Context("on a loopback address without tls", func() {
+		BeforeEach(func() {
+			config.ListenAddress = "127.0.0.1:" + port
+		})
+
+		It("serves the API", func() {
+			process = ginkgomon.Invoke(runner)
+			Expect(listDrivers()).To(Succeed())
+		})
+	})
+
+	Context("on every address without tls", func() {
+		BeforeEach(func() {
+			config.ListenAddress = "0.0.0.0:" + port
+		})
+
+		It("fails to start rather than run without the API", func() {
+			Expect(logger.Buffer()).To(gbytes.Say("volman-api-can-not-be-served"))
+
+			failed := ifrit.Invoke(runner)
+			Eventually(failed.Wait()).Should(Receive(HaveOccurred()))
+			Expect(listDrivers()).NotTo(Succeed())
+		})
+	})
+
+	Context("with tls whose certificate can not be loaded", func() {
+		BeforeEach(func() {
+			config.ListenAddress = "0.0.0.0:" + port
+			config.ListenTLS = &vollocal.ListenTLSConfig{CertFile: "/no/such/cert.pem", KeyFile: "/no/such/key.pem"}
+		})
+
+		It("fails to start rather than run without the API", func() {
+			Expect(logger.Buffer()).To(gbytes.Say("volman-api-can-not-be-served"))
+
+			failed := ifrit.Invoke(runner)
+			Eventually(failed.Wait()).Should(Receive(HaveOccurred()))
+		})
+	})
+})
@@ -1,23 +1,22 @@
 package vollocal
 
 import (
-	"errors"
 	"time"
 
 	"github.com/tedsuo/ifrit"
 
 	"context"
+	"errors"
 
-	"fmt"
 	"os"
+	"sort"
 	"strings"
 
 	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/go-loggregator/loggregator_v2"
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/voldriver"
-	"code.cloudfoundry.org/voldriver/driverhttp"
 	"code.cloudfoundry.org/volman"
-	"code.cloudfoundry.org/go-loggregator/loggregator_v2"
 	"github.com/tedsuo/ifrit/grouper"
 )
 
@@ -26,6 +25,8 @@ const (
 	volmanMountDuration        = "VolmanMountDuration"
 	volmanUnmountErrorsCounter = "VolmanUnmountErrors"
 	volmanUnmountDuration      = "VolmanUnmountDuration"
+	volmanUnsafeMountpoints    = "VolmanUnsafeMountpoints"
+	volmanVolumeLockWait       = "VolmanVolumeLockWaitDuration"
 )
 
 var (
@@ -36,16 +37,118 @@ var (
 type DriverConfig struct {
 	DriverPaths  []string
 	SyncInterval time.Duration
+
+	// WatchDriverPaths, when set, rediscovers drivers as soon as spec files are added, renamed or removed in
+	// DriverPaths instead of waiting for the next sync
+	WatchDriverPaths bool
+
+	// DiscoveryDebounce is how long changes to DriverPaths must settle before drivers are rediscovered
+	DiscoveryDebounce time.Duration
+
+	// DriverPrecedence decides which spec file a driver is activated from when several in DriverPaths name it
+	DriverPrecedence DriverPrecedence
+
+	// EndpointChangePolicy decides which client unmounts the volumes mounted through a driver whose endpoint has
+	// changed; see the EndpointChange constants
+	EndpointChangePolicy string
+
+	// ActivationWorkers is how many drivers are activated at once during discovery, so that one unresponsive driver
+	// does not hold up the others
+	ActivationWorkers int
+
+	// ListenAddress, when set, serves the volman API over http on a tcp host:port or a unix:// socket.  Without
+	// ListenTLS, only a unix socket or a loopback host:port is served; volman fails to start if asked to serve any
+	// other address without tls, or if its tls certificates can't be loaded.
+	ListenAddress string
+
+	// ListenTLS, when set, serves the volman API over tls, and requires client certificates if it has a CA
+	ListenTLS *ListenTLSConfig
+
+	// MountTableDir, when set, is where volman keeps its table of mounts so that after a restart it only purges the
+	// mounts it can't account for.  Volman fails to start if the table, or the unmount queue kept beside it, can't be
+	// read.
+	MountTableDir string
+
+	// DriverPolicies bounds and retries the calls volman makes to drivers
+	DriverPolicies DriverPolicies
+
+	// AllowedMountRoots are the directories drivers may mount volumes under; volumes mounted anywhere else are
+	// unmounted and the mount fails
+	AllowedMountRoots []string
+
+	// HealthCheck tunes the circuit breaker that fails calls fast to drivers that keep failing
+	HealthCheck HealthCheckConfig
+
+	// Purge tunes how volman unmounts the volumes drivers have mounted that it has no record of, at startup and,
+	// optionally, periodically
+	Purge PurgeConfig
+
+	// UnmountRetry tunes the background retries of unmounts drivers failed; the queue of them is kept in
+	// MountTableDir when that is set
+	UnmountRetry UnmountRetryConfig
+
+	// Metrics chooses how volman reports its mount and unmount metrics.  MetricsLoggregator, the default, sends them
+	// through the metron client.  MetricsPrometheus serves mount and unmount durations and errors labeled by driver,
+	// and gauges of the registered drivers and active mounts, on /metrics at MetricsListenAddress instead.  Volman's
+	// other metrics, such as purges, the unmount queue and driver name collisions, go to metron either way.
+	Metrics string
+
+	// MetricsListenAddress is the tcp host:port /metrics is served on with MetricsPrometheus; volman fails to start
+	// without it
+	MetricsListenAddress string
 }
 
 func NewDriverConfig() DriverConfig {
 	return DriverConfig{
-		SyncInterval: time.Second * 30,
+		SyncInterval:         time.Second * 30,
+		DiscoveryDebounce:    time.Millisecond * 500,
+		ActivationWorkers:    4,
+		EndpointChangePolicy: EndpointChangeKeepOld,
+		AllowedMountRoots:    DefaultAllowedMountRoots,
+		Metrics:              MetricsLoggregator,
+		DriverPolicies: DriverPolicies{
+			Default: DriverPolicy{
+				ActivateTimeout: time.Second * 10,
+				CreateTimeout:   time.Minute,
+				MountTimeout:    time.Minute * 2,
+				UnmountTimeout:  time.Minute * 2,
+				ListTimeout:     time.Second * 30,
+				Retries:         2,
+				RetryBackoff:    time.Second,
+				MaxRetryBackoff: time.Second * 10,
+				RetryJitter:     0.2,
+			},
+		},
+		HealthCheck: HealthCheckConfig{
+			WindowSize:    20,
+			MinimumCalls:  5,
+			FailureRatio:  0.5,
+			OpenDuration:  time.Second * 30,
+			ProbeInterval: time.Second * 10,
+		},
+		Purge: PurgeConfig{
+			Workers: 4,
+		},
+		UnmountRetry: UnmountRetryConfig{
+			RetryInterval:  DefaultUnmountRetryInterval,
+			InitialBackoff: time.Second * 30,
+			MaxBackoff:     time.Minute * 10,
+			Jitter:         0.2,
+		},
 	}
 }
 
 type localClient struct {
 	driverRegistry DriverRegistry
+	mountRegistry  MountRegistry
+	driverCaller   driverCaller
+	volumeLocks    *VolumeLocks
+	unmountQueue   UnmountQueue
+	endpoints      DriverEndpointMonitor
+	syncer         DriverSyncer
+	purger         MountPurger
+	mountRoots     []string
+	metrics        VolumeMetrics
 	metronClient   loggregator_v2.Client
 	clock          clock.Clock
 }
@@ -54,82 +157,563 @@ func NewServer(logger lager.Logger, metronClient loggregator_v2.Client, config D
 	clock := clock.NewClock()
 	registry := NewDriverRegistry()
 
-	syncer := NewDriverSyncer(logger, registry, config.DriverPaths, config.SyncInterval, clock)
-	purger := NewMountPurger(logger, registry)
+	// volman must not start without the mounts and unmounts it recorded, or its purge would unmount volumes that are
+	// in use and the unmounts it was retrying would be forgotten
+	var startupErr error
+
+	mountRegistry := NewMountRegistry()
+	if config.MountTableDir != "" {
+		persistentRegistry, err := NewPersistentMountRegistry(logger, config.MountTableDir)
+		if err != nil {
+			logger.Error("failed-loading-mount-table", err)
+			startupErr = err
+		} else {
+			mountRegistry = persistentRegistry
+		}
+	}
+
+	var metrics PrometheusMetrics
+	if config.Metrics == MetricsPrometheus {
+		metrics = NewPrometheusMetrics(registry, mountRegistry)
+	}
+
+	var watchDebounce time.Duration
+	if config.WatchDriverPaths {
+		watchDebounce = config.DiscoveryDebounce
+		if watchDebounce <= 0 {
+			watchDebounce = time.Millisecond * 500
+		}
+	}
+
+	health := NewDriverHealth(logger, registry, config.DriverPolicies, config.HealthCheck, clock)
+
+	// the client, purger and unmount queue take turns on each volume
+	locks := NewVolumeLocks()
+
+	endpoints := NewDriverEndpointMonitor(logger, registry, mountRegistry, config.DriverPolicies, config.EndpointChangePolicy, metronClient, clock)
 
-	grouper := grouper.NewOrdered(os.Kill, grouper.Members{grouper.Member{"volman-syncer", syncer.Runner()}, grouper.Member{"volman-purger", purger.Runner()}})
+	queueOptions := UnmountQueueOptions{
+		MountRegistry: mountRegistry,
+		Policies:      config.DriverPolicies,
+		Health:        health,
+		Endpoints:     endpoints,
+		VolumeLocks:   locks,
+		Retry:         config.UnmountRetry,
+		TableDir:      config.MountTableDir,
+		MetronClient:  metronClient,
+		Clock:         clock,
+	}
+	unmountQueue, err := NewUnmountQueue(logger, registry, queueOptions)
+	if err != nil {
+		logger.Error("failed-loading-unmount-queue", err)
+		if startupErr == nil {
+			startupErr = err
+		}
+		queueOptions.TableDir = ""
+		unmountQueue, _ = NewUnmountQueue(logger, registry, queueOptions)
+	}
+
+	syncer := NewDriverSyncerWithOptions(logger, registry, config.DriverPaths, config.SyncInterval, clock, DriverSyncerOptions{
+		Policies:          config.DriverPolicies,
+		WatchDebounce:     watchDebounce,
+		ActivationWorkers: config.ActivationWorkers,
+		Precedence:        config.DriverPrecedence,
+		MetronClient:      metronClient,
+	})
+	purger := NewMountPurgerWithOptions(logger, registry, MountPurgerOptions{
+		MountRegistry: mountRegistry,
+		Policies:      config.DriverPolicies,
+		UnmountQueue:  unmountQueue,
+		VolumeLocks:   locks,
+		Purge:         config.Purge,
+		MetronClient:  metronClient,
+		Clock:         clock,
+	})
+
+	client := NewLocalClientWithOptions(logger, registry, LocalClientOptions{
+		MountRegistry:     mountRegistry,
+		Policies:          config.DriverPolicies,
+		AllowedMountRoots: config.AllowedMountRoots,
+		Health:            health,
+		UnmountQueue:      unmountQueue,
+		VolumeLocks:       locks,
+		Endpoints:         endpoints,
+		Syncer:            syncer,
+		Purger:            purger,
+		Metrics:           metrics,
+		MetronClient:      metronClient,
+		Clock:             clock,
+	})
+
+	members := grouper.Members{grouper.Member{"volman-syncer", syncer.Runner()}, grouper.Member{"volman-purger", purger.Runner()}, grouper.Member{"volman-health", health.Runner()}, grouper.Member{"volman-endpoint-monitor", endpoints.Runner()}, grouper.Member{"volman-unmount-queue", unmountQueue.Runner()}}
+	if config.ListenAddress != "" {
+		server, err := newAPIServer(logger, config.ListenAddress, config.ListenTLS, client)
+		if err != nil {
+			logger.Error("volman-api-can-not-be-served", err, lager.Data{"listenAddress": config.ListenAddress})
+			if startupErr == nil {
+				startupErr = err
+			}
+		} else {
+			members = append(members, grouper.Member{"volman-server", server})
+		}
+	}
+	if metrics != nil {
+		if config.MetricsListenAddress == "" {
+			err := errors.New("MetricsListenAddress is required with prometheus metrics")
+			logger.Error("metrics-listen-address-not-set", err)
+			if startupErr == nil {
+				startupErr = err
+			}
+		} else {
+			members = append(members, grouper.Member{"volman-metrics-server", NewPrometheusServer(config.MetricsListenAddress, metrics)})
+		}
+	}
+
+	if startupErr != nil {
+		return client, startupFailure(startupErr)
+	}
+
+	grouper := grouper.NewOrdered(os.Kill, members)
 
-	return NewLocalClient(logger, registry, metronClient, clock), grouper
+	return client, grouper
+}
+
+// startupFailure is a runner that fails at once with the error, so that volman exits rather than running without state
+// it could not load
+func startupFailure(err error) ifrit.Runner {
+	return ifrit.RunFunc(func(<-chan os.Signal, chan<- struct{}) error {
+		return err
+	})
 }
 
 func NewLocalClient(logger lager.Logger, registry DriverRegistry, metronClient loggregator_v2.Client, clock clock.Clock) volman.Manager {
+	return NewLocalClientWithOptions(logger, registry, LocalClientOptions{MetronClient: metronClient, Clock: clock})
+}
+
+// LocalClientOptions are the collaborators of a local client beyond its driver registry.  Any left unset fall back
+// to a default: an in-memory mount registry, the default policies and allowed mount roots, volume locks of its own,
+// the real clock, and no health tracking, unmount queue, endpoint monitor, syncer, purger or metrics.
+type LocalClientOptions struct {
+	MountRegistry MountRegistry
+
+	// Policies time out and retry the client's driver calls
+	Policies DriverPolicies
+
+	// AllowedMountRoots are the directories the client hands out mountpoints under
+	AllowedMountRoots []string
+
+	// Health fails calls fast to drivers it considers unhealthy and is told the outcome of the calls the client makes
+	Health DriverHealth
+
+	// UnmountQueue retries in the background the unmounts drivers fail
+	UnmountQueue UnmountQueue
+
+	// VolumeLocks serialize the client's mounts and unmounts of each volume, along with those of any purger and
+	// unmount queue given the same locks
+	VolumeLocks *VolumeLocks
+
+	// Endpoints chooses the driver to unmount a volume with, so that volumes mounted through a driver's old endpoint
+	// can still be unmounted after it changes
+	Endpoints DriverEndpointMonitor
+
+	// Syncer and Purger discover drivers and purge mounts when asked to.  Without them, Discover and Purge fail.
+	Syncer DriverSyncer
+	Purger MountPurger
+
+	// Metrics, when set, record mounts and unmounts labeled by driver in place of the metron client, which is left
+	// the client's other metrics.  MetronClient may be nil.
+	Metrics      VolumeMetrics
+	MetronClient loggregator_v2.Client
+
+	Clock clock.Clock
+}
+
+func NewLocalClientWithOptions(logger lager.Logger, registry DriverRegistry, options LocalClientOptions) volman.Manager {
+	if options.MountRegistry == nil {
+		options.MountRegistry = NewMountRegistry()
+	}
+	if len(options.AllowedMountRoots) == 0 {
+		options.AllowedMountRoots = DefaultAllowedMountRoots
+	}
+	if options.Clock == nil {
+		options.Clock = clock.NewClock()
+	}
+	if options.VolumeLocks == nil {
+		options.VolumeLocks = NewVolumeLocks()
+	}
+
 	return &localClient{
 		driverRegistry: registry,
-		metronClient:   metronClient,
-		clock:          clock,
+		mountRegistry:  options.MountRegistry,
+		driverCaller:   driverCaller{clock: options.Clock, policies: options.Policies, health: options.Health},
+		volumeLocks:    options.VolumeLocks,
+		unmountQueue:   options.UnmountQueue,
+		endpoints:      options.Endpoints,
+		syncer:         options.Syncer,
+		purger:         options.Purger,
+		mountRoots:     options.AllowedMountRoots,
+		metrics:        options.Metrics,
+		metronClient:   options.MetronClient,
+		clock:          options.Clock,
 	}
 }
 
-func (client *localClient) ListDrivers(logger lager.Logger) (volman.ListDriversResponse, error) {
+func (client *localClient) ListDrivers(logger lager.Logger, ctx context.Context) (volman.ListDriversResponse, error) {
 	logger = logger.Session("list-drivers")
 	logger.Info("start")
 	defer logger.Info("end")
 
 	var infoResponses []volman.InfoResponse
-	drivers := client.driverRegistry.Drivers()
+	drivers, infos, collisions := client.driverRegistry.Snapshot()
+
+	activeMounts := map[string]int{}
+	for _, mount := range client.mountRegistry.Mounts() {
+		if mount.State == MountStateMounted {
+			activeMounts[mount.DriverId]++
+		}
+	}
 
 	for name, _ := range drivers {
-		infoResponses = append(infoResponses, volman.InfoResponse{Name: name})
+		info := infos[name]
+		infoResponses = append(infoResponses, volman.InfoResponse{
+			Name:          name,
+			SpecFile:      info.SpecFile,
+			SpecFormat:    info.SpecFormat,
+			Address:       info.Address,
+			TLS:           info.TLSConfig != nil,
+			Implements:    info.Implements,
+			Scope:         info.Scope,
+			DiscoveredAt:  info.DiscoveredAt,
+			LastHealthyAt: info.LastHealthyAt,
+			ActiveMounts:  activeMounts[name],
+		})
 	}
+	sort.Slice(infoResponses, func(i, j int) bool { return infoResponses[i].Name < infoResponses[j].Name })
 
 	logger.Debug("listing-drivers", lager.Data{"drivers": infoResponses})
-	return volman.ListDriversResponse{infoResponses}, nil
+	return volman.ListDriversResponse{Drivers: infoResponses, Collisions: collisions}, nil
+}
+
+// Discover has the syncer discover drivers now and lists the drivers registered as a result
+func (client *localClient) Discover(logger lager.Logger, ctx context.Context) (volman.ListDriversResponse, error) {
+	logger = logger.Session("discover")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if client.syncer == nil {
+		err := errors.New("driver discovery is not available")
+		logger.Error("failed-discovering-drivers", err)
+		return volman.ListDriversResponse{}, err
+	}
+
+	if err := client.syncer.Sync(logger, ctx); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = volman.TimeoutError{Operation: "discover", Cause: ctxErr.Error()}
+		}
+		logger.Error("failed-discovering-drivers", err)
+		return volman.ListDriversResponse{}, err
+	}
+
+	return client.ListDrivers(logger, ctx)
+}
+
+// ListMounts merges volman's mount records with the volumes each driver lists as mounted.  Drivers know nothing of
+// the containers using their volumes, so a mount volman has no record of is only listed when no container is
+// filtered on.
+func (client *localClient) ListMounts(logger lager.Logger, ctx context.Context, filter volman.ListMountsFilter) (volman.ListMountsResponse, error) {
+	logger = logger.Session("list-mounts", lager.Data{"filter": filter})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	mounts := map[mountKey]*volman.MountInfo{}
+	for _, record := range client.mountRegistry.Mounts() {
+		if !matchesMountFilter(filter, record.DriverId, record.VolumeId) {
+			continue
+		}
+		mounts[mountKey{record.DriverId, record.VolumeId}] = &volman.MountInfo{
+			DriverId:   record.DriverId,
+			VolumeId:   record.VolumeId,
+			Mountpoint: record.Path,
+			Owners:     record.Owners,
+			MountedAt:  record.MountedAt,
+			State:      record.State,
+			Recorded:   true,
+		}
+	}
+
+	driverErrors := map[string]string{}
+	for driverId, driver := range client.driverRegistry.Drivers() {
+		if filter.DriverId != "" && filter.DriverId != driverId {
+			continue
+		}
+
+		listResponse, err := client.driverCaller.list(logger, ctx, driverId, driver)
+		if err != nil {
+			logger.Error("failed-listing-driver-volumes", err, lager.Data{"driverId": driverId})
+			driverErrors[driverId] = err.Error()
+			continue
+		}
+
+		for _, volume := range listResponse.Volumes {
+			if volume.Mountpoint == "" || !matchesMountFilter(filter, driverId, volume.Name) {
+				continue
+			}
+
+			key := mountKey{driverId, volume.Name}
+			mount, ok := mounts[key]
+			if !ok {
+				mount = &volman.MountInfo{DriverId: driverId, VolumeId: volume.Name, Owners: []string{}}
+				mounts[key] = mount
+			}
+			mount.Mountpoint = volume.Mountpoint
+			mount.Listed = true
+		}
+	}
+
+	response := volman.ListMountsResponse{Mounts: []volman.MountInfo{}}
+	for _, mount := range mounts {
+		if filter.ContainerId != "" && !containsString(mount.Owners, filter.ContainerId) {
+			continue
+		}
+		response.Mounts = append(response.Mounts, *mount)
+	}
+	sort.Slice(response.Mounts, func(i, j int) bool {
+		if response.Mounts[i].DriverId != response.Mounts[j].DriverId {
+			return response.Mounts[i].DriverId < response.Mounts[j].DriverId
+		}
+		return response.Mounts[i].VolumeId < response.Mounts[j].VolumeId
+	})
+	if len(driverErrors) > 0 {
+		response.DriverErrors = driverErrors
+	}
+
+	return response, nil
+}
+
+func matchesMountFilter(filter volman.ListMountsFilter, driverId string, volumeId string) bool {
+	return (filter.DriverId == "" || filter.DriverId == driverId) && (filter.VolumeId == "" || filter.VolumeId == volumeId)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
 
-func (client *localClient) Mount(logger lager.Logger, driverId string, volumeId string, config map[string]interface{}) (volman.MountResponse, error) {
+func (client *localClient) Mount(logger lager.Logger, ctx context.Context, driverId string, volumeId string, containerId string, config map[string]interface{}) (volman.MountResponse, error) {
 	logger = logger.Session("mount")
 	logger.Info("start")
 	defer logger.Info("end")
 
+	if containerId == "" {
+		err := volman.MissingContainerIdError{DriverId: driverId, VolumeId: volumeId, Operation: "mount"}
+		logger.Error("missing-container-id", err)
+		client.countMountError(driverId, err)
+		return volman.MountResponse{}, err
+	}
+
 	mountStart := client.clock.Now()
 
 	defer func() {
-		sendMountDurationMetrics(logger, client.metronClient, time.Since(mountStart), driverId)
+		client.sendMountDuration(logger, driverId, time.Since(mountStart))
 	}()
 
-	logger.Debug("driver-mounting-volume", lager.Data{"driverId": driverId, "volumeId": volumeId})
+	logger.Debug("driver-mounting-volume", lager.Data{"driverId": driverId, "volumeId": volumeId, "containerId": containerId})
 
 	driver, found := client.driverRegistry.Driver(driverId)
 	if !found {
-		err := errors.New("Driver '" + driverId + "' not found in list of known drivers")
+		err := volman.DriverNotFoundError{DriverId: driverId}
 		logger.Error("mount-driver-lookup-error", err)
-		client.metronClient.IncrementCounter(volmanMountErrorsCounter)
+		client.countMountError(driverId, err)
 		return volman.MountResponse{}, err
 	}
 
-	err := client.create(logger, driverId, volumeId, config)
+	unlock, err := client.lockVolume(logger, ctx, driverId, volumeId, "mount")
 	if err != nil {
-		client.metronClient.IncrementCounter(volmanMountErrorsCounter)
+		client.countMountError(driverId, err)
 		return volman.MountResponse{}, err
 	}
+	defer unlock()
+
+	record, recorded := client.mountRegistry.Record(driverId, volumeId)
+	recreate := false
+	if recorded && (record.State == MountStateMounted || record.State == MountStateMounting) {
+		if differing := configConflict(record, config); differing != nil {
+			err := volman.ConfigConflictError{DriverId: driverId, VolumeId: volumeId, DifferingKeys: differing}
+			if !volman.ConfigOverride(ctx) {
+				logger.Error("mount-config-conflict", err)
+				client.countMountError(driverId, err)
+				return volman.MountResponse{}, err
+			}
+			logger.Info("recreating-volume-with-overridden-config", lager.Data{"driverId": driverId, "volumeId": volumeId, "keys": err.DifferingKeys})
+			recreate = true
+		}
+	}
 
-	env := driverhttp.NewHttpDriverEnv(logger, context.TODO())
+	if !recreate {
+		path, ok, err := client.mountRegistry.Acquire(driverId, volumeId, containerId)
+		if err != nil {
+			logger.Error("failed-recording-mount", err)
+			client.countMountError(driverId, err)
+			return volman.MountResponse{}, err
+		}
+		if ok {
+			logger.Info("volume-already-mounted", lager.Data{"driverId": driverId, "volumeId": volumeId, "path": path})
+			return volman.MountResponse{Path: path}, nil
+		}
+	}
+
+	if err := client.mountRegistry.BeginMount(driverId, volumeId, containerId, hashConfig(config), hashConfigKeys(config)); err != nil {
+		logger.Error("failed-recording-mount", err)
+		client.countMountError(driverId, err)
+		return volman.MountResponse{}, err
+	}
+
+	if recreate {
+		// the mount stays recorded as in flight if this fails, so that the purger rolls it back
+		if err := client.removeVolume(logger, ctx, driverId, driver, volumeId, record.State == MountStateMounted); err != nil {
+			client.countMountError(driverId, err)
+			return volman.MountResponse{}, err
+		}
+	}
+
+	err = client.create(logger, ctx, driverId, volumeId, config)
+	if err != nil {
+		// after a timeout the driver may still create the volume, so the mount stays recorded as in flight
+		if _, ok := err.(volman.TimeoutError); !ok {
+			client.abandonMount(logger, driverId, volumeId, containerId)
+		}
+		client.countMountError(driverId, err)
+		return volman.MountResponse{}, err
+	}
 
 	mountRequest := voldriver.MountRequest{Name: volumeId}
 	logger.Debug("calling-driver-with-mount-request", lager.Data{"driverId": driverId, "mountRequest": mountRequest})
-	mountResponse := driver.Mount(env, mountRequest)
+	mountResponse, err := client.driverCaller.mount(logger, ctx, driverId, driver, mountRequest)
+	if err != nil {
+		logger.Error("mount-failed", err)
+		// after a timeout the driver may still mount the volume, so the mount stays recorded as in flight
+		if _, ok := err.(volman.TimeoutError); !ok {
+			client.abandonMount(logger, driverId, volumeId, containerId)
+		}
+		client.countMountError(driverId, err)
+		return volman.MountResponse{}, err
+	}
 	logger.Debug("response-from-driver", lager.Data{"response": mountResponse})
 
-	if !strings.HasPrefix(mountResponse.Mountpoint, "/var/vcap/data") {
-		logger.Info("invalid-mountpath", lager.Data{"detail": fmt.Sprintf("Invalid or dangerous mountpath %s outside of /var/vcap/data", mountResponse.Mountpoint)})
+	if reason := checkMountpoint(mountResponse.Mountpoint, client.mountRoots); reason != "" {
+		err := volman.UnsafeMountpointError{DriverId: driverId, VolumeId: volumeId, Mountpoint: mountResponse.Mountpoint, Reason: reason}
+		logger.Error("unsafe-mountpoint", err)
+		if client.metronClient != nil {
+			client.metronClient.IncrementCounter(volmanUnsafeMountpoints)
+		}
+		client.countMountError(driverId, err)
+
+		if unmountErr := client.driverCaller.unmount(logger, ctx, driverId, driver, voldriver.UnmountRequest{Name: volumeId}); unmountErr != nil {
+			// the mount stays recorded as in flight so that the purger retries the unmount
+			logger.Error("failed-unmounting-unsafe-mountpoint", unmountErr)
+		} else {
+			client.abandonMount(logger, driverId, volumeId, containerId)
+		}
+		return volman.MountResponse{}, err
+	}
+
+	if err := client.mountRegistry.CompleteMount(driverId, volumeId, mountResponse.Mountpoint, client.clock.Now()); err != nil {
+		logger.Error("failed-recording-mount", err)
+	}
+	if client.unmountQueue != nil {
+		// the volume is in use again, so any unmount still queued for it must not be retried
+		client.unmountQueue.Remove(logger, driverId, volumeId)
 	}
 
-	if mountResponse.Err != "" {
+	return volman.MountResponse{Path: mountResponse.Mountpoint}, nil
+}
+
+// lockVolume waits for any other mount or unmount of the volume to finish, reporting how long it waited
+func (client *localClient) lockVolume(logger lager.Logger, ctx context.Context, driverId string, volumeId string, operation string) (func(), error) {
+	lockStart := client.clock.Now()
+
+	unlock, err := client.volumeLocks.lock(ctx, driverId, volumeId)
+	if err != nil {
+		err = volman.TimeoutError{DriverId: driverId, Operation: operation, Cause: "waiting for another operation on volume '" + volumeId + "': " + err.Error()}
+		logger.Error("failed-locking-volume", err)
+		return nil, err
+	}
+
+	if client.metronClient != nil {
+		if err := client.metronClient.SendDuration(volmanVolumeLockWait, client.clock.Since(lockStart)); err != nil {
+			logger.Error("failed-to-send-volume-lock-wait-metric", err)
+		}
+	}
+	return unlock, nil
+}
+
+// removeVolume unmounts the volume if it is mounted and has the driver remove it, so that it can be created again
+func (client *localClient) removeVolume(logger lager.Logger, ctx context.Context, driverId string, driver voldriver.Driver, volumeId string, mounted bool) error {
+	logger = logger.Session("remove-volume", lager.Data{"driverId": driverId, "volumeId": volumeId})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if mounted {
+		if err := client.driverCaller.unmount(logger, ctx, driverId, driver, voldriver.UnmountRequest{Name: volumeId}); err != nil {
+			logger.Error("unmount-failed", err)
+			return err
+		}
+	}
+
+	if err := client.driverCaller.remove(logger, ctx, driverId, driver, voldriver.RemoveRequest{Name: volumeId}); err != nil {
+		logger.Error("remove-failed", err)
+		return err
+	}
+	return nil
+}
+
+func (client *localClient) forgetMount(logger lager.Logger, driverId string, volumeId string) {
+	if err := client.mountRegistry.Remove(driverId, volumeId); err != nil {
+		logger.Error("failed-removing-mount-record", err, lager.Data{"driverId": driverId, "volumeId": volumeId})
+	}
+}
+
+// abandonMount drops the container's reference to a volume it failed to mount, leaving those of any other containers
+func (client *localClient) abandonMount(logger lager.Logger, driverId string, volumeId string, containerId string) {
+	if err := client.mountRegistry.AbandonMount(driverId, volumeId, containerId); err != nil {
+		logger.Error("failed-removing-mount-record", err, lager.Data{"driverId": driverId, "volumeId": volumeId, "containerId": containerId})
+	}
+}
+
+func (client *localClient) sendMountDuration(logger lager.Logger, driverId string, duration time.Duration) {
+	if client.metrics != nil {
+		client.metrics.MountDuration(driverId, duration)
+	} else if client.metronClient != nil {
+		sendMountDurationMetrics(logger, client.metronClient, duration, driverId)
+	}
+}
+
+func (client *localClient) sendUnmountDuration(logger lager.Logger, driverId string, duration time.Duration) {
+	if client.metrics != nil {
+		client.metrics.UnmountDuration(driverId, duration)
+	} else if client.metronClient != nil {
+		sendUnmountDurationMetrics(logger, client.metronClient, duration, driverId)
+	}
+}
+
+func (client *localClient) countMountError(driverId string, err error) {
+	if client.metrics != nil {
+		client.metrics.MountError(driverId, err)
+	} else if client.metronClient != nil {
 		client.metronClient.IncrementCounter(volmanMountErrorsCounter)
-		return volman.MountResponse{}, errors.New(mountResponse.Err)
 	}
+}
 
-	return volman.MountResponse{mountResponse.Mountpoint}, nil
+func (client *localClient) countUnmountError(driverId string, err error) {
+	if client.metrics != nil {
+		client.metrics.UnmountError(driverId, err)
+	} else if client.metronClient != nil {
+		client.metronClient.IncrementCounter(volmanUnmountErrorsCounter)
+	}
 }
 
 func sendMountDurationMetrics(logger lager.Logger, metronClient loggregator_v2.Client, duration time.Duration, driverId string) {
@@ -166,55 +750,207 @@ func sendUnmountDurationMetrics(logger lager.Logger, metronClient loggregator_v2
 	}
 }
 
-func (client *localClient) Unmount(logger lager.Logger, driverId string, volumeName string) error {
+func (client *localClient) Unmount(logger lager.Logger, ctx context.Context, driverId string, volumeName string, containerId string) error {
 	logger = logger.Session("unmount")
 	logger.Info("start")
 	defer logger.Info("end")
-	logger.Debug("unmounting-volume", lager.Data{"volumeName": volumeName})
+	logger.Debug("unmounting-volume", lager.Data{"volumeName": volumeName, "containerId": containerId})
+
+	if containerId == "" {
+		err := volman.MissingContainerIdError{DriverId: driverId, VolumeId: volumeName, Operation: "unmount"}
+		logger.Error("missing-container-id", err)
+		client.countUnmountError(driverId, err)
+		return err
+	}
 
 	unmountStart := client.clock.Now()
 
 	defer func() {
-		sendUnmountDurationMetrics(logger, client.metronClient, time.Since(unmountStart), driverId)
+		client.sendUnmountDuration(logger, driverId, time.Since(unmountStart))
 	}()
 
-	driver, found := client.driverRegistry.Driver(driverId)
+	driver, found := client.unmountDriver(driverId, volumeName)
 	if !found {
-		err := errors.New("Driver '" + driverId + "' not found in list of known drivers")
+		err := volman.DriverNotFoundError{DriverId: driverId}
 		logger.Error("mount-driver-lookup-error", err)
-		client.metronClient.IncrementCounter(volmanUnmountErrorsCounter)
+		client.countUnmountError(driverId, err)
 		return err
 	}
 
-	env := driverhttp.NewHttpDriverEnv(logger, context.TODO())
+	unlock, err := client.lockVolume(logger, ctx, driverId, volumeName, "unmount")
+	if err != nil {
+		client.countUnmountError(driverId, err)
+		return err
+	}
+	defer unlock()
 
-	if response := driver.Unmount(env, voldriver.UnmountRequest{Name: volumeName}); response.Err != "" {
-		err := errors.New(response.Err)
+	remaining, found, err := client.mountRegistry.Release(driverId, volumeName, containerId)
+	if err != nil {
+		logger.Error("failed-recording-unmount", err)
+		client.countUnmountError(driverId, err)
+		return err
+	}
+	if found && remaining > 0 {
+		logger.Info("volume-still-in-use", lager.Data{"driverId": driverId, "volumeName": volumeName, "remainingOwners": remaining})
+		return nil
+	}
+
+	if err := client.driverCaller.unmount(logger, ctx, driverId, driver, voldriver.UnmountRequest{Name: volumeName}); err != nil {
 		logger.Error("unmount-failed", err)
-		client.metronClient.IncrementCounter(volmanUnmountErrorsCounter)
+		client.countUnmountError(driverId, err)
+		// after a timeout the driver may still finish the unmount, so it stays recorded as in flight
+		if _, ok := err.(volman.TimeoutError); !ok && found {
+			if err := client.mountRegistry.AbortUnmount(driverId, volumeName); err != nil {
+				logger.Error("failed-recording-unmount", err)
+			}
+		}
+		if client.unmountQueue != nil {
+			client.unmountQueue.Add(logger, driverId, volumeName, err)
+		}
 		return err
 	}
 
+	client.forgetMount(logger, driverId, volumeName)
+	if client.endpoints != nil {
+		client.endpoints.Unmounted(driverId, volumeName)
+	}
+	if client.unmountQueue != nil {
+		client.unmountQueue.Remove(logger, driverId, volumeName)
+	}
+
 	return nil
 }
 
-func (client *localClient) create(logger lager.Logger, driverId string, volumeName string, opts map[string]interface{}) error {
+// unmountDriver returns the driver to unmount the volume with, which after a change of endpoint may not be the one
+// registered
+func (client *localClient) unmountDriver(driverId string, volumeId string) (voldriver.Driver, bool) {
+	if client.endpoints != nil {
+		return client.endpoints.UnmountDriver(driverId, volumeId)
+	}
+	return client.driverRegistry.Driver(driverId)
+}
+
+func (client *localClient) ListPendingUnmounts(logger lager.Logger, ctx context.Context) (volman.ListPendingUnmountsResponse, error) {
+	logger = logger.Session("list-pending-unmounts")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	pending := []volman.PendingUnmount{}
+	if client.unmountQueue != nil {
+		pending = client.unmountQueue.Pending()
+	}
+
+	return volman.ListPendingUnmountsResponse{PendingUnmounts: pending}, nil
+}
+
+func (client *localClient) Purge(logger lager.Logger, ctx context.Context, dryRun bool) (volman.PurgeResponse, error) {
+	logger = logger.Session("purge", lager.Data{"dryRun": dryRun})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if client.purger == nil {
+		err := errors.New("purging mounts is not available")
+		logger.Error("failed-purging-mounts", err)
+		return volman.PurgeResponse{}, err
+	}
+
+	report, err := client.purger.Purge(logger, ctx, dryRun)
+	if err != nil {
+		logger.Error("failed-purging-mounts", err)
+		return volman.PurgeResponse{}, err
+	}
+
+	return volman.PurgeResponse{DryRun: dryRun, Drivers: report.Drivers}, nil
+}
+
+func (client *localClient) Path(logger lager.Logger, ctx context.Context, driverId string, volumeId string) (volman.PathResponse, error) {
+	logger = logger.Session("path", lager.Data{"driverId": driverId, "volumeId": volumeId})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	driver, found := client.driverRegistry.Driver(driverId)
+	if !found {
+		err := volman.DriverNotFoundError{DriverId: driverId}
+		logger.Error("path-driver-lookup-error", err)
+		return volman.PathResponse{}, err
+	}
+
+	pathResponse, err := client.driverCaller.path(logger, ctx, driverId, driver, voldriver.PathRequest{Name: volumeId})
+	if err != nil {
+		err = volumeLookupError(driverId, volumeId, err)
+		logger.Error("path-failed", err)
+		return volman.PathResponse{}, err
+	}
+
+	return volman.PathResponse{Path: pathResponse.Mountpoint}, nil
+}
+
+func (client *localClient) Get(logger lager.Logger, ctx context.Context, driverId string, volumeId string) (volman.GetResponse, error) {
+	logger = logger.Session("get", lager.Data{"driverId": driverId, "volumeId": volumeId})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	driver, found := client.driverRegistry.Driver(driverId)
+	if !found {
+		err := volman.DriverNotFoundError{DriverId: driverId}
+		logger.Error("get-driver-lookup-error", err)
+		return volman.GetResponse{}, err
+	}
+
+	getResponse, err := client.driverCaller.get(logger, ctx, driverId, driver, voldriver.GetRequest{Name: volumeId})
+	if err != nil {
+		err = volumeLookupError(driverId, volumeId, err)
+		logger.Error("get-failed", err)
+		return volman.GetResponse{}, err
+	}
+
+	return volman.GetResponse{Name: getResponse.Volume.Name, Mountpoint: getResponse.Volume.Mountpoint}, nil
+}
+
+// volumeNotFoundMessages are the whole messages, ignoring case, that drivers reply to a Path or Get with when they do not
+// know the volume: those of the cloudfoundry drivers, such as "Volume 'my-volume' not found", and of docker volume
+// plugins.  A message that only contains one of them, such as "credentials file not found", is about something else.
+func volumeNotFoundMessages(volumeId string) []string {
+	return []string{
+		"volume not found",
+		"volume '" + volumeId + "' not found",
+		"no such volume",
+		"no such volume: " + volumeId,
+	}
+}
+
+// volumeLookupError reports an error returned by the driver for a Path or Get as the volume not being found when the
+// driver says that is why it failed.  Any other driver error, timeout or unhealthy driver is returned as it is.
+func volumeLookupError(driverId string, volumeId string, err error) error {
+	driverErr, ok := err.(volman.DriverError)
+	if !ok {
+		return err
+	}
+
+	message := strings.TrimSpace(driverErr.Message)
+	for _, notFound := range volumeNotFoundMessages(volumeId) {
+		if strings.EqualFold(message, notFound) {
+			return volman.VolumeNotFoundError{DriverId: driverId, VolumeId: volumeId, Cause: driverErr.Message}
+		}
+	}
+	return err
+}
+
+func (client *localClient) create(logger lager.Logger, ctx context.Context, driverId string, volumeName string, opts map[string]interface{}) error {
 	logger = logger.Session("create")
 	logger.Info("start")
 	defer logger.Info("end")
 	driver, found := client.driverRegistry.Driver(driverId)
 	if !found {
-		err := errors.New("Driver '" + driverId + "' not found in list of known drivers")
+		err := volman.DriverNotFoundError{DriverId: driverId}
 		logger.Error("mount-driver-lookup-error", err)
 		return err
 	}
 
-	env := driverhttp.NewHttpDriverEnv(logger, context.TODO())
-
 	logger.Debug("creating-volume", lager.Data{"volumeName": volumeName, "driverId": driverId})
-	response := driver.Create(env, voldriver.CreateRequest{Name: volumeName, Opts: opts})
-	if response.Err != "" {
-		return errors.New(response.Err)
+	if err := client.driverCaller.create(logger, ctx, driverId, driver, voldriver.CreateRequest{Name: volumeName, Opts: opts}); err != nil {
+		logger.Error("create-failed", err)
+		return err
 	}
 	return nil
 }
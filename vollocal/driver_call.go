@@ -0,0 +1,304 @@
+package vollocal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/voldriver"
+	"code.cloudfoundry.org/voldriver/driverhttp"
+	"code.cloudfoundry.org/volman"
+)
+
+// driverCaller makes driver calls under the timeout and retry policy of the driver being called.  Each attempt runs in
+// the background so that a hung driver can not block the caller past the timeout or the caller's context; the driver
+// is handed a context that is cancelled when volman stops waiting, so well behaved drivers abandon the request too.
+// When there is a health tracker, attempts fail fast while the driver is unhealthy and their outcomes are reported to
+// it.
+type driverCaller struct {
+	clock    clock.Clock
+	policies DriverPolicies
+	health   DriverHealth
+}
+
+func (c driverCaller) activate(logger lager.Logger, ctx context.Context, driverId string, driver voldriver.Driver) (voldriver.ActivateResponse, error) {
+	response, err := c.call(logger, ctx, driverId, "activate", func(env voldriver.Env) (interface{}, string) {
+		response := driver.Activate(env)
+		return response, response.Err
+	})
+	if err != nil {
+		return voldriver.ActivateResponse{}, err
+	}
+	return response.(voldriver.ActivateResponse), nil
+}
+
+func (c driverCaller) capabilities(logger lager.Logger, ctx context.Context, driverId string, driver voldriver.Driver) (voldriver.CapabilitiesResponse, error) {
+	response, err := c.call(logger, ctx, driverId, "capabilities", func(env voldriver.Env) (interface{}, string) {
+		return driver.Capabilities(env), ""
+	})
+	if err != nil {
+		return voldriver.CapabilitiesResponse{}, err
+	}
+	return response.(voldriver.CapabilitiesResponse), nil
+}
+
+func (c driverCaller) create(logger lager.Logger, ctx context.Context, driverId string, driver voldriver.Driver, request voldriver.CreateRequest) error {
+	_, err := c.call(logger, ctx, driverId, "create", func(env voldriver.Env) (interface{}, string) {
+		response := driver.Create(env, request)
+		return response, response.Err
+	})
+	return err
+}
+
+func (c driverCaller) mount(logger lager.Logger, ctx context.Context, driverId string, driver voldriver.Driver, request voldriver.MountRequest) (voldriver.MountResponse, error) {
+	response, err := c.call(logger, ctx, driverId, "mount", func(env voldriver.Env) (interface{}, string) {
+		response := driver.Mount(env, request)
+		return response, response.Err
+	})
+	if err != nil {
+		return voldriver.MountResponse{}, err
+	}
+	return response.(voldriver.MountResponse), nil
+}
+
+func (c driverCaller) unmount(logger lager.Logger, ctx context.Context, driverId string, driver voldriver.Driver, request voldriver.UnmountRequest) error {
+	_, err := c.call(logger, ctx, driverId, "unmount", func(env voldriver.Env) (interface{}, string) {
+		response := driver.Unmount(env, request)
+		return response, response.Err
+	})
+	return err
+}
+
+func (c driverCaller) list(logger lager.Logger, ctx context.Context, driverId string, driver voldriver.Driver) (voldriver.ListResponse, error) {
+	response, err := c.call(logger, ctx, driverId, "list", func(env voldriver.Env) (interface{}, string) {
+		response := driver.List(env)
+		return response, response.Err
+	})
+	if err != nil {
+		return voldriver.ListResponse{}, err
+	}
+	return response.(voldriver.ListResponse), nil
+}
+
+func (c driverCaller) remove(logger lager.Logger, ctx context.Context, driverId string, driver voldriver.Driver, request voldriver.RemoveRequest) error {
+	_, err := c.call(logger, ctx, driverId, "remove", func(env voldriver.Env) (interface{}, string) {
+		response := driver.Remove(env, request)
+		return response, response.Err
+	})
+	return err
+}
+
+func (c driverCaller) path(logger lager.Logger, ctx context.Context, driverId string, driver voldriver.Driver, request voldriver.PathRequest) (voldriver.PathResponse, error) {
+	response, err := c.call(logger, ctx, driverId, "path", func(env voldriver.Env) (interface{}, string) {
+		response := driver.Path(env, request)
+		return response, response.Err
+	})
+	if err != nil {
+		return voldriver.PathResponse{}, err
+	}
+	return response.(voldriver.PathResponse), nil
+}
+
+func (c driverCaller) get(logger lager.Logger, ctx context.Context, driverId string, driver voldriver.Driver, request voldriver.GetRequest) (voldriver.GetResponse, error) {
+	response, err := c.call(logger, ctx, driverId, "get", func(env voldriver.Env) (interface{}, string) {
+		response := driver.Get(env, request)
+		return response, response.Err
+	})
+	if err != nil {
+		return voldriver.GetResponse{}, err
+	}
+	return response.(voldriver.GetResponse), nil
+}
+
+// call attempts the request until it succeeds, fails in a way that is not worth retrying, the retries run out or the
+// caller's context is done.  Failures are returned as volman.TimeoutError when volman stopped waiting and as
+// volman.DriverError when the driver returned an error.
+func (c driverCaller) call(logger lager.Logger, ctx context.Context, driverId string, operation string, request func(voldriver.Env) (interface{}, string)) (interface{}, error) {
+	policy := c.policies.For(driverId)
+
+	for retry := 0; ; retry++ {
+		response, err := c.attempt(logger, ctx, driverId, operation, policy, request)
+		if err == nil || !retryable(operation, err) || ctx.Err() != nil || retry >= policy.Retries {
+			return response, err
+		}
+
+		backoff := policy.backoff(retry)
+		logger.Info("retrying-driver-call", lager.Data{"driverId": driverId, "operation": operation, "retry": retry + 1, "backoff": backoff.String(), "error": err.Error()})
+
+		timer := c.clock.NewTimer(backoff)
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, newTimeoutError(driverId, operation, ctx.Err())
+		}
+	}
+}
+
+func (c driverCaller) attempt(logger lager.Logger, ctx context.Context, driverId string, operation string, policy DriverPolicy, request func(voldriver.Env) (interface{}, string)) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, newTimeoutError(driverId, operation, err)
+	}
+
+	if c.health == nil {
+		return c.attemptRequest(logger, ctx, driverId, operation, policy, request)
+	}
+
+	if err := c.health.Allow(driverId); err != nil {
+		return nil, err
+	}
+
+	response, err := c.attemptRequest(logger, ctx, driverId, operation, policy, request)
+	switch {
+	case ctx.Err() != nil && err != nil:
+		c.health.Abandoned(driverId)
+	case err != nil && unresponsive(err):
+		c.health.Failed(driverId)
+	default:
+		// any reply from the driver, even an error about the request, shows that it is responsive
+		c.health.Succeeded(driverId)
+	}
+	return response, err
+}
+
+func (c driverCaller) attemptRequest(logger lager.Logger, ctx context.Context, driverId string, operation string, policy DriverPolicy, request func(voldriver.Env) (interface{}, string)) (interface{}, error) {
+	callCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var timedOut <-chan time.Time
+	timeout := policy.timeout(operation)
+	if timeout > 0 {
+		timer := c.clock.NewTimer(timeout)
+		defer timer.Stop()
+		timedOut = timer.C()
+	}
+
+	type result struct {
+		response interface{}
+		err      string
+	}
+
+	env := driverhttp.NewHttpDriverEnv(logger, callCtx)
+	done := make(chan result, 1)
+	go func() {
+		response, err := request(env)
+		done <- result{response, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != "" {
+			return nil, newDriverError(driverId, operation, r.err)
+		}
+		return r.response, nil
+	case <-timedOut:
+		return nil, newTimeoutError(driverId, operation, errors.New("no response within "+timeout.String()))
+	case <-ctx.Done():
+		return nil, newTimeoutError(driverId, operation, ctx.Err())
+	}
+}
+
+// unsentErrorMessages are found in the errors drivers' clients return when they could not connect to the driver, so
+// the request never reached it.  Drivers' clients hand volman these errors as text, so their messages are all there is
+// to go on once the structured checks in unsent find nothing.
+var unsentErrorMessages = []string{
+	"dial ",
+	"connection refused",
+}
+
+// transportErrorMessages are found in the errors drivers' clients return when a request did not get a reply from the
+// driver, as opposed to the driver replying with an error.  Like unsentErrorMessages, they are only a fallback.
+var transportErrorMessages = []string{
+	"connection reset",
+	"broken pipe",
+	"i/o timeout",
+	"unexpected eof",
+	"no route to host",
+	"network is unreachable",
+}
+
+// retryable reports whether a failed call is worth attempting again: only timeouts and failures to reach the driver
+// are, since an error the driver replied with will most likely be replied again.  Creates and mounts are only retried
+// when the request never reached the driver, as otherwise the driver may have acted on a request that volman gave up
+// waiting on and they are not safe to repeat.
+func retryable(operation string, err error) bool {
+	if unsent(err) {
+		return true
+	}
+
+	if operation == "create" || operation == "mount" {
+		return false
+	}
+	return unresponsive(err)
+}
+
+// unresponsive reports whether a failed call says the driver is unhealthy: it timed out or could not be reached.  Errors
+// the driver replied with, such as a bad config or an unknown volume, are about the request and not the driver.
+func unresponsive(err error) bool {
+	var netErr net.Error
+	switch {
+	case errors.As(err, new(volman.TimeoutError)), errors.Is(err, context.DeadlineExceeded):
+		return true
+	case unsent(err):
+		return true
+	case errors.As(err, &netErr), errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE), errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	}
+
+	var driverErr volman.DriverError
+	return errors.As(err, &driverErr) && containsAny(driverErr.Message, transportErrorMessages)
+}
+
+// unsent reports whether a failed call never reached the driver because volman could not connect to it
+func unsent(err error) bool {
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return true
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return true
+	}
+
+	var driverErr volman.DriverError
+	return errors.As(err, &driverErr) && containsAny(driverErr.Message, unsentErrorMessages)
+}
+
+func containsAny(message string, substrings []string) bool {
+	message = strings.ToLower(message)
+	for _, substring := range substrings {
+		if strings.Contains(message, substring) {
+			return true
+		}
+	}
+	return false
+}
+
+// newDriverError takes the safe description from drivers that return their errors as voldriver.SafeError json, and
+// otherwise describes the failure without repeating the driver's message
+func newDriverError(driverId string, operation string, message string) volman.DriverError {
+	var safeError struct {
+		SafeDescription string
+	}
+	if err := json.Unmarshal([]byte(message), &safeError); err == nil && safeError.SafeDescription != "" {
+		return volman.DriverError{DriverId: driverId, Operation: operation, Message: safeError.SafeDescription, SafeDescription: safeError.SafeDescription}
+	}
+
+	return volman.DriverError{
+		DriverId:        driverId,
+		Operation:       operation,
+		Message:         message,
+		SafeDescription: fmt.Sprintf("driver '%s' failed to %s", driverId, operation),
+	}
+}
+
+func newTimeoutError(driverId string, operation string, err error) volman.TimeoutError {
+	return volman.TimeoutError{DriverId: driverId, Operation: operation, Cause: err.Error()}
+}
@@ -0,0 +1,218 @@
+package vollocal
+
+import (
+	"context"
+	"os"
+	"sync"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/go-loggregator/loggregator_v2"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/voldriver"
+	"github.com/tedsuo/ifrit"
+)
+
+const volmanEndpointChangesWithActiveMounts = "VolmanDriverEndpointChangesWithActiveMounts"
+
+// Policies for the volumes still mounted through a driver's old endpoint once the driver is reached at a new one.
+// Every policy logs the change and counts it as VolmanDriverEndpointChangesWithActiveMounts.
+const (
+	// EndpointChangeAlert only alerts, leaving the new endpoint to unmount the volumes
+	EndpointChangeAlert = "alert"
+
+	// EndpointChangeKeepOld keeps the old endpoint's client to unmount the volumes mounted through it
+	EndpointChangeKeepOld = "keep-old"
+
+	// EndpointChangeRelist lists the volumes through the new endpoint, leaving it the volumes it knows are mounted and
+	// keeping the old endpoint's client for the rest
+	EndpointChangeRelist = "relist"
+)
+
+// DriverEndpointMonitor watches the driver registry for drivers whose endpoint changes while volumes are mounted
+// through them, and decides which client unmounts those volumes
+type DriverEndpointMonitor interface {
+	Runner() ifrit.Runner
+
+	// UnmountDriver returns the driver to unmount the volume with: the old endpoint's client if the policy kept it
+	// for the volume, and the registered driver otherwise
+	UnmountDriver(driverId string, volumeId string) (voldriver.Driver, bool)
+
+	// Unmounted lets go of any old endpoint's client kept for the volume
+	Unmounted(driverId string, volumeId string)
+}
+
+type driverEndpointMonitor struct {
+	sync.RWMutex
+	logger        lager.Logger
+	registry      DriverRegistry
+	mountRegistry MountRegistry
+	driverCaller  driverCaller
+	policy        string
+	metronClient  loggregator_v2.Client
+
+	// retained holds the old endpoints' clients kept for the volumes mounted through them
+	retained map[mountKey]voldriver.Driver
+}
+
+// NewDriverEndpointMonitor returns a monitor that follows the policy, one of the EndpointChange constants, for volumes
+// mounted through a driver whose endpoint changes.  An empty or unknown policy only alerts.
+func NewDriverEndpointMonitor(logger lager.Logger, registry DriverRegistry, mountRegistry MountRegistry, policies DriverPolicies, policy string, metronClient loggregator_v2.Client, clock clock.Clock) DriverEndpointMonitor {
+	return &driverEndpointMonitor{
+		logger:        logger,
+		registry:      registry,
+		mountRegistry: mountRegistry,
+		driverCaller:  driverCaller{clock: clock, policies: policies},
+		policy:        policy,
+		metronClient:  metronClient,
+		retained:      map[mountKey]voldriver.Driver{},
+	}
+}
+
+func (m *driverEndpointMonitor) Runner() ifrit.Runner {
+	return m
+}
+
+func (m *driverEndpointMonitor) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	logger := m.logger.Session("driver-endpoint-monitor", lager.Data{"policy": m.policy})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// old endpoints' clients are kept before the registry hands out the new one, so that no unmount in between goes to
+	// the new endpoint
+	var relists sync.WaitGroup
+	unregister := m.registry.BeforeChange(func(event DriverEvent) {
+		if changed, ok := event.(DriverEndpointChanged); ok {
+			m.endpointChanged(logger, ctx, &relists, changed)
+		}
+	})
+
+	close(ready)
+
+	signal := <-signals
+	logger.Info("received-signal", lager.Data{"signal": signal.String()})
+
+	unregister()
+	cancel()
+	relists.Wait()
+	return nil
+}
+
+func (m *driverEndpointMonitor) UnmountDriver(driverId string, volumeId string) (voldriver.Driver, bool) {
+	m.RLock()
+	driver, ok := m.retained[mountKey{driverId, volumeId}]
+	m.RUnlock()
+
+	if ok {
+		return driver, true
+	}
+	return m.registry.Driver(driverId)
+}
+
+func (m *driverEndpointMonitor) Unmounted(driverId string, volumeId string) {
+	m.Lock()
+	defer m.Unlock()
+
+	delete(m.retained, mountKey{driverId, volumeId})
+}
+
+func (m *driverEndpointMonitor) endpointChanged(logger lager.Logger, ctx context.Context, relists *sync.WaitGroup, event DriverEndpointChanged) {
+	logger = logger.Session("endpoint-changed", lager.Data{"driverId": event.DriverId})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	var volumeIds []string
+	for _, record := range m.mountRegistry.Mounts() {
+		if record.DriverId == event.DriverId {
+			volumeIds = append(volumeIds, record.VolumeId)
+		}
+	}
+	if len(volumeIds) == 0 {
+		return
+	}
+
+	logger.Info("driver-endpoint-changed-with-active-mounts", lager.Data{"volumeIds": volumeIds})
+	if m.metronClient != nil {
+		m.metronClient.IncrementCounter(volmanEndpointChangesWithActiveMounts)
+	}
+
+	switch m.policy {
+	case EndpointChangeKeepOld:
+		m.retain(logger, event.DriverId, volumeIds, event.Old)
+
+	case EndpointChangeRelist:
+		// the old endpoint keeps every volume until the new one has said which it knows, without holding up the
+		// registry on a slow driver
+		m.retain(logger, event.DriverId, volumeIds, event.Old)
+
+		relists.Add(1)
+		go func() {
+			defer relists.Done()
+			m.relist(logger, ctx, event, volumeIds)
+		}()
+	}
+}
+
+// relist hands the new endpoint the volumes it knows are mounted, leaving the old endpoint the rest
+func (m *driverEndpointMonitor) relist(logger lager.Logger, ctx context.Context, event DriverEndpointChanged, volumeIds []string) {
+	logger = logger.Session("relist")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	listResponse, err := m.driverCaller.list(logger, ctx, event.DriverId, event.New)
+	if err != nil {
+		logger.Error("failed-listing-volumes-through-new-endpoint", err)
+		return
+	}
+
+	known := map[string]bool{}
+	for _, volume := range listResponse.Volumes {
+		known[volume.Name] = true
+	}
+
+	var released []string
+	for _, volumeId := range volumeIds {
+		if known[volumeId] {
+			released = append(released, volumeId)
+		}
+	}
+	m.release(logger, event.DriverId, released, event.Old)
+}
+
+// retain keeps the old endpoint's client for the volumes, unless a client from an even older endpoint is already kept
+func (m *driverEndpointMonitor) retain(logger lager.Logger, driverId string, volumeIds []string, old voldriver.Driver) {
+	if len(volumeIds) == 0 {
+		return
+	}
+	logger.Info("keeping-old-endpoint-for-volumes", lager.Data{"volumeIds": volumeIds})
+
+	m.Lock()
+	defer m.Unlock()
+
+	for _, volumeId := range volumeIds {
+		key := mountKey{driverId, volumeId}
+		if _, ok := m.retained[key]; !ok {
+			m.retained[key] = old
+		}
+	}
+}
+
+// release lets go of the old endpoint's client for the volumes, where it is the one kept for them
+func (m *driverEndpointMonitor) release(logger lager.Logger, driverId string, volumeIds []string, old voldriver.Driver) {
+	if len(volumeIds) == 0 {
+		return
+	}
+	logger.Info("releasing-old-endpoint-for-volumes", lager.Data{"volumeIds": volumeIds})
+
+	m.Lock()
+	defer m.Unlock()
+
+	for _, volumeId := range volumeIds {
+		key := mountKey{driverId, volumeId}
+		if kept, ok := m.retained[key]; ok && sameDriver(kept, old) {
+			delete(m.retained, key)
+		}
+	}
+}
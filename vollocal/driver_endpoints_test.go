@@ -0,0 +1,136 @@
+package vollocal_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	mfakes "code.cloudfoundry.org/go-loggregator/loggregator_v2/fakes"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/voldriver"
+	"code.cloudfoundry.org/voldriver/voldriverfakes"
+	"code.cloudfoundry.org/volman/vollocal"
+	"github.com/tedsuo/ifrit"
+	"github.com/tedsuo/ifrit/ginkgomon"
+)
+
+var _ = Describe("DriverEndpointMonitor", func() {
+	var (
+		logger        *lagertest.TestLogger
+		fakeClock     *fakeclock.FakeClock
+		fakeMetron    *mfakes.FakeClient
+		oldDriver     *voldriverfakes.FakeDriver
+		newDriver     *voldriverfakes.FakeDriver
+		registry      vollocal.DriverRegistry
+		mountRegistry vollocal.MountRegistry
+		policy        string
+		monitor       vollocal.DriverEndpointMonitor
+		process       ifrit.Process
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("driver-endpoint-monitor")
+		fakeClock = fakeclock.NewFakeClock(time.Unix(123, 456))
+		fakeMetron = new(mfakes.FakeClient)
+		oldDriver = new(voldriverfakes.FakeDriver)
+		newDriver = new(voldriverfakes.FakeDriver)
+		registry = vollocal.NewDriverRegistryWith(map[string]voldriver.Driver{"fakedriver": oldDriver})
+
+		mountRegistry = vollocal.NewMountRegistry()
+		for _, volumeId := range []string{"fake-volume", "other-volume"} {
+			Expect(mountRegistry.BeginMount("fakedriver", volumeId, "some-container", "hash", nil)).To(Succeed())
+			Expect(mountRegistry.CompleteMount("fakedriver", volumeId, "/var/vcap/data/mounts/"+volumeId, time.Unix(123, 456))).To(Succeed())
+		}
+	})
+
+	JustBeforeEach(func() {
+		monitor = vollocal.NewDriverEndpointMonitor(logger, registry, mountRegistry, vollocal.DriverPolicies{}, policy, fakeMetron, fakeClock)
+		process = ginkgomon.Invoke(monitor.Runner())
+
+		registry.Set(map[string]voldriver.Driver{"fakedriver": newDriver})
+		Eventually(fakeMetron.IncrementCounterCallCount).Should(Equal(1))
+	})
+
+	AfterEach(func() {
+		ginkgomon.Kill(process)
+	})
+
+	unmountDriver := func(volumeId string) func() voldriver.Driver {
+		return func() voldriver.Driver {
+			driver, _ := monitor.UnmountDriver("fakedriver", volumeId)
+			return driver
+		}
+	}
+
+	Context("when alerting", func() {
+		BeforeEach(func() {
+			policy = vollocal.EndpointChangeAlert
+		})
+
+		It("counts the change and leaves the new endpoint to unmount the volumes", func() {
+			Expect(fakeMetron.IncrementCounterArgsForCall(0)).To(Equal("VolmanDriverEndpointChangesWithActiveMounts"))
+			Consistently(unmountDriver("fake-volume")).Should(BeIdenticalTo(newDriver))
+		})
+	})
+
+	Context("when keeping the old endpoint", func() {
+		BeforeEach(func() {
+			policy = vollocal.EndpointChangeKeepOld
+		})
+
+		It("unmounts the volumes mounted through the old endpoint with it", func() {
+			// kept before the registry hands out the new endpoint
+			Expect(unmountDriver("fake-volume")()).To(BeIdenticalTo(oldDriver))
+			Expect(unmountDriver("other-volume")()).To(BeIdenticalTo(oldDriver))
+			Expect(unmountDriver("new-volume")()).To(BeIdenticalTo(newDriver))
+		})
+
+		It("lets go of the old endpoint once a volume is unmounted", func() {
+			Eventually(unmountDriver("fake-volume")).Should(BeIdenticalTo(oldDriver))
+
+			monitor.Unmounted("fakedriver", "fake-volume")
+			Expect(unmountDriver("fake-volume")()).To(BeIdenticalTo(newDriver))
+		})
+
+		Context("when used by the local client", func() {
+			It("unmounts through the old endpoint", func() {
+				Eventually(unmountDriver("fake-volume")).Should(BeIdenticalTo(oldDriver))
+
+				client := vollocal.NewLocalClientWithOptions(logger, registry, vollocal.LocalClientOptions{MountRegistry: mountRegistry, Endpoints: monitor, MetronClient: fakeMetron, Clock: fakeClock})
+				Expect(client.Unmount(logger, context.Background(), "fakedriver", "fake-volume", "some-container")).To(Succeed())
+
+				Expect(oldDriver.UnmountCallCount()).To(Equal(1))
+				Expect(newDriver.UnmountCallCount()).To(Equal(0))
+				Expect(unmountDriver("fake-volume")()).To(BeIdenticalTo(newDriver))
+			})
+		})
+	})
+
+	Context("when relisting through the new endpoint", func() {
+		var listed chan struct{}
+
+		BeforeEach(func() {
+			policy = vollocal.EndpointChangeRelist
+
+			listed = make(chan struct{})
+			newDriver.ListStub = func(env voldriver.Env) voldriver.ListResponse {
+				<-listed
+				return voldriver.ListResponse{Volumes: []voldriver.VolumeInfo{{Name: "fake-volume", Mountpoint: "/var/vcap/data/mounts/fake-volume"}}}
+			}
+		})
+
+		It("keeps the old endpoint for every volume until the new one has listed them, without holding up the registry", func() {
+			Expect(unmountDriver("fake-volume")()).To(BeIdenticalTo(oldDriver))
+			Expect(unmountDriver("other-volume")()).To(BeIdenticalTo(oldDriver))
+
+			close(listed)
+
+			Eventually(unmountDriver("fake-volume")).Should(BeIdenticalTo(newDriver))
+			Expect(unmountDriver("other-volume")()).To(BeIdenticalTo(oldDriver))
+			Expect(newDriver.ListCallCount()).To(Equal(1))
+		})
+	})
+})
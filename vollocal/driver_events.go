@@ -0,0 +1,144 @@
+package vollocal
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+
+	"code.cloudfoundry.org/voldriver"
+)
+
+// DriverEvent is a change to the drivers in a DriverRegistry: a DriverAdded, DriverRemoved or DriverEndpointChanged
+type DriverEvent interface {
+	driverEvent()
+}
+
+type DriverAdded struct {
+	DriverId string
+	Driver   voldriver.Driver
+}
+
+type DriverRemoved struct {
+	DriverId string
+	Driver   voldriver.Driver
+}
+
+// DriverEndpointChanged is sent when a driver is replaced by another client, typically because its spec now points at
+// a different address.  Old is still the client for whatever was mounted through it.
+type DriverEndpointChanged struct {
+	DriverId string
+	Old      voldriver.Driver
+	New      voldriver.Driver
+}
+
+func (DriverAdded) driverEvent()           {}
+func (DriverRemoved) driverEvent()         {}
+func (DriverEndpointChanged) driverEvent() {}
+
+// diffDrivers returns the events that turn the old drivers into the new ones, ordered by driver
+func diffDrivers(old, new map[string]voldriver.Driver) []DriverEvent {
+	var driverIds []string
+	for driverId := range old {
+		driverIds = append(driverIds, driverId)
+	}
+	for driverId := range new {
+		if _, ok := old[driverId]; !ok {
+			driverIds = append(driverIds, driverId)
+		}
+	}
+	sort.Strings(driverIds)
+
+	var events []DriverEvent
+	for _, driverId := range driverIds {
+		oldDriver, wasRegistered := old[driverId]
+		newDriver, isRegistered := new[driverId]
+
+		switch {
+		case !wasRegistered:
+			events = append(events, DriverAdded{DriverId: driverId, Driver: newDriver})
+		case !isRegistered:
+			events = append(events, DriverRemoved{DriverId: driverId, Driver: oldDriver})
+		case !sameDriver(oldDriver, newDriver):
+			events = append(events, DriverEndpointChanged{DriverId: driverId, Old: oldDriver, New: newDriver})
+		}
+	}
+	return events
+}
+
+// sameDriver compares drivers without panicking on driver types that can not be compared
+func sameDriver(a, b voldriver.Driver) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	typ := reflect.TypeOf(a)
+	if typ != reflect.TypeOf(b) || !typ.Comparable() {
+		return false
+	}
+	return a == b
+}
+
+// driverSubscription queues events for a subscriber so that setting the registry never waits on a slow reader
+type driverSubscription struct {
+	sync.Mutex
+	pending []DriverEvent
+	notify  chan struct{}
+	events  chan DriverEvent
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newDriverSubscription() *driverSubscription {
+	subscription := &driverSubscription{
+		notify: make(chan struct{}, 1),
+		events: make(chan DriverEvent),
+		done:   make(chan struct{}),
+	}
+	go subscription.run()
+	return subscription
+}
+
+func (s *driverSubscription) publish(events []DriverEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	s.Lock()
+	s.pending = append(s.pending, events...)
+	s.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *driverSubscription) run() {
+	defer close(s.events)
+
+	for {
+		s.Lock()
+		if len(s.pending) == 0 {
+			s.Unlock()
+			select {
+			case <-s.notify:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+		event := s.pending[0]
+		s.pending = s.pending[1:]
+		s.Unlock()
+
+		select {
+		case s.events <- event:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *driverSubscription) close() {
+	s.once.Do(func() { close(s.done) })
+}
@@ -21,6 +21,25 @@ import (
 type DriverFactory interface {
 	// Given a driver id, path and config filename returns a remote client implementation of the voldriver.Driver interface
 	Driver(logger lager.Logger, driverId string, driverPath, driverFileName string, existing map[string]voldriver.Driver) (voldriver.Driver, error)
+
+	// Given a driver id, path and config filename returns where the driver is specified and how it is reached.  When
+	// the address can not be canonicalized, the spec is returned without an Address along with the error.
+	Spec(logger lager.Logger, driverId string, driverPath, driverFileName string) (DriverSpec, error)
+
+	// Given a driver id and a spec already read by Spec returns a remote client implementation of the voldriver.Driver
+	// interface, so that discovery reads each spec file only once
+	DriverFromSpec(logger lager.Logger, driverId string, spec DriverSpec, existing map[string]voldriver.Driver) (voldriver.Driver, error)
+}
+
+// DriverSpec describes a driver's spec file and the canonical address volman reaches the driver on
+type DriverSpec struct {
+	SpecFile   string
+	SpecFormat string
+	Address    string
+	TLSConfig  *voldriver.TLSConfig
+
+	// SpecAddress is the address as the spec file gives it, before it is canonicalized
+	SpecAddress string
 }
 
 type realDriverFactory struct {
@@ -48,83 +67,110 @@ func (r *realDriverFactory) Driver(logger lager.Logger, driverId string, driverP
 	logger.Info("start")
 	defer logger.Info("end")
 
-	var driver voldriver.Driver
+	spec, err := r.Spec(logger, driverId, driverPath, driverFileName)
+	if err != nil {
+		return nil, err
+	}
 
-	var address string
-	var tls *voldriver.TLSConfig
-	if strings.Contains(driverFileName, ".") {
-		extension := strings.Split(driverFileName, ".")[1]
-		switch extension {
-		case "sock":
-			address = path.Join(driverPath, driverFileName)
-		case "spec":
-			configFile, err := r.useOs.Open(path.Join(driverPath, driverFileName))
-			if err != nil {
-				logger.Error("error-opening-config", err, lager.Data{"DriverFileName": driverFileName})
-				return nil, err
-			}
-			reader := bufio.NewReader(configFile)
-			addressBytes, _, err := reader.ReadLine()
-			if err != nil { // no real value in faking this as bigger problems exist when this fails
-				logger.Error("error-reading-driver-file", err, lager.Data{"DriverFileName": driverFileName})
-				return nil, err
-			}
-			address = string(addressBytes)
-		case "json":
-			// extract url from json file
-			var driverJsonSpec voldriver.DriverSpec
-			configFile, err := r.useOs.Open(path.Join(driverPath, driverFileName))
-			if err != nil {
-				logger.Error("error-opening-config", err, lager.Data{"DriverFileName": driverFileName})
-				return nil, err
-			}
-			jsonParser := json.NewDecoder(configFile)
-			if err = jsonParser.Decode(&driverJsonSpec); err != nil {
-				logger.Error("parsing-config-file-error", err)
-				return nil, err
-			}
-			address = driverJsonSpec.Address
-			tls = driverJsonSpec.TLSConfig
-		default:
-			err := fmt.Errorf("unknown-driver-extension: %s", extension)
-			logger.Error("driver", err)
-			return nil, err
+	return r.DriverFromSpec(logger, driverId, spec, existing)
+}
 
+func (r *realDriverFactory) DriverFromSpec(logger lager.Logger, driverId string, spec DriverSpec, existing map[string]voldriver.Driver) (voldriver.Driver, error) {
+	address, tls := spec.Address, spec.TLSConfig
+
+	var driver voldriver.Driver
+	var err error
+
+	logger.Info("checking-existing-drivers", lager.Data{"driverId": driverId})
+	var ok bool
+	driver, ok = existing[driverId]
+	if ok {
+		logger.Info("existing-driver-found", lager.Data{"driverId": driverId})
+		matchable, ok := driver.(voldriver.MatchableDriver)
+		if !ok || !matchable.Matches(logger, address, tls) {
+			// the registry reports the replacement as a DriverEndpointChanged event
+			logger.Info("existing-driver-mismatch", lager.Data{"driverId": driverId, "address": address, "tls": tls})
+			driver = nil
+		} else {
+			logger.Info("existing-driver-matches", lager.Data{"driverId": driverId})
 		}
-		var err error
+	}
 
-		address, err = r.canonicalize(logger, address)
+	if driver == nil {
+		logger.Info("getting-driver", lager.Data{"address": address})
+		driver, err = r.Factory.NewRemoteClient(address, tls)
 		if err != nil {
-			logger.Error("invalid-address", err, lager.Data{"address": address})
+			logger.Error("error-building-driver", err, lager.Data{"address": address})
 			return nil, err
 		}
+	}
 
-		logger.Info("checking-existing-drivers", lager.Data{"driverId": driverId})
-		var ok bool
-		driver, ok = existing[driverId]
-		if ok {
-			logger.Info("existing-driver-found", lager.Data{"driverId": driverId})
-			matchable, ok := driver.(voldriver.MatchableDriver)
-			if !ok || !matchable.Matches(logger, address, tls) {
-				logger.Info("existing-driver-mismatch", lager.Data{"driverId": driverId, "address": address, "tls": tls})
-				driver = nil
-			}
-			logger.Info("existing-driver-matches", lager.Data{"driverId": driverId})
-		}
+	return driver, nil
+}
 
-		if driver == nil {
-			logger.Info("getting-driver", lager.Data{"address": address})
-			driver, err = r.Factory.NewRemoteClient(address, tls)
-			if err != nil {
-				logger.Error("error-building-driver", err, lager.Data{"address": address})
-				return nil, err
-			}
+func (r *realDriverFactory) Spec(logger lager.Logger, driverId string, driverPath string, driverFileName string) (DriverSpec, error) {
+	if !strings.Contains(driverFileName, ".") {
+		return DriverSpec{}, fmt.Errorf("Driver '%s' not found in list of known drivers", driverId)
+	}
+
+	var address string
+	var tls *voldriver.TLSConfig
+	extension := strings.Split(driverFileName, ".")[1]
+	switch extension {
+	case "sock":
+		address = path.Join(driverPath, driverFileName)
+	case "spec":
+		configFile, err := r.useOs.Open(path.Join(driverPath, driverFileName))
+		if err != nil {
+			logger.Error("error-opening-config", err, lager.Data{"DriverFileName": driverFileName})
+			return DriverSpec{}, err
 		}
+		defer configFile.Close()
+		reader := bufio.NewReader(configFile)
+		addressBytes, _, err := reader.ReadLine()
+		if err != nil { // no real value in faking this as bigger problems exist when this fails
+			logger.Error("error-reading-driver-file", err, lager.Data{"DriverFileName": driverFileName})
+			return DriverSpec{}, err
+		}
+		address = string(addressBytes)
+	case "json":
+		// extract url from json file
+		var driverJsonSpec voldriver.DriverSpec
+		configFile, err := r.useOs.Open(path.Join(driverPath, driverFileName))
+		if err != nil {
+			logger.Error("error-opening-config", err, lager.Data{"DriverFileName": driverFileName})
+			return DriverSpec{}, err
+		}
+		defer configFile.Close()
+		jsonParser := json.NewDecoder(configFile)
+		if err = jsonParser.Decode(&driverJsonSpec); err != nil {
+			logger.Error("parsing-config-file-error", err)
+			return DriverSpec{}, err
+		}
+		address = driverJsonSpec.Address
+		tls = driverJsonSpec.TLSConfig
+	default:
+		err := fmt.Errorf("unknown-driver-extension: %s", extension)
+		logger.Error("driver", err)
+		return DriverSpec{}, err
+
+	}
+
+	spec := DriverSpec{
+		SpecFile:    path.Join(driverPath, driverFileName),
+		SpecFormat:  extension,
+		TLSConfig:   tls,
+		SpecAddress: address,
+	}
 
-		return driver, nil
+	canonical, err := r.canonicalize(logger, address)
+	if err != nil {
+		logger.Error("invalid-address", err, lager.Data{"address": address})
+		return spec, err
 	}
+	spec.Address = canonical
 
-	return nil, fmt.Errorf("Driver '%s' not found in list of known drivers", driverId)
+	return spec, nil
 }
 
 func (r *realDriverFactory) canonicalize(logger lager.Logger, address string) (string, error) {
@@ -61,6 +61,24 @@ var _ = Describe("DriverFactory", func() {
 			})
 		})
 
+		Context("when describing a json driver spec", func() {
+			BeforeEach(func() {
+				err := voldriver.WriteDriverSpec(testLogger, defaultPluginsDirectory, driverName, "json", []byte("{\"Addr\":\"tcp://0.0.0.0:8080\",\"TLSConfig\":{\"InsecureSkipVerify\":true}}"))
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("should return the spec file, format, canonical address and tls config", func() {
+				spec, err := driverFactory.Spec(testLogger, driverName, defaultPluginsDirectory, driverName+".json")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(spec.SpecFile).To(Equal(path.Join(defaultPluginsDirectory, driverName+".json")))
+				Expect(spec.SpecFormat).To(Equal("json"))
+				Expect(spec.Address).To(Equal("http://0.0.0.0:8080"))
+				Expect(spec.SpecAddress).To(Equal("tcp://0.0.0.0:8080"))
+				Expect(spec.TLSConfig).NotTo(BeNil())
+				Expect(spec.TLSConfig.InsecureSkipVerify).To(BeTrue())
+			})
+		})
+
 		Context("when a json driver spec is rediscovered", func() {
 			var matchableDriver *voldriverfakes.FakeMatchableDriver
 			BeforeEach(func() {
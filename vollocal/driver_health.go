@@ -0,0 +1,298 @@
+package vollocal
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/volman"
+	"github.com/tedsuo/ifrit"
+)
+
+// HealthCheckConfig tunes the circuit breaker volman keeps for each driver.  A driver whose recent calls fail too
+// often has its circuit opened: calls to it fail fast until OpenDuration has passed, when a single trial call is let
+// through to decide whether to close the circuit again.
+type HealthCheckConfig struct {
+	// WindowSize is how many of a driver's most recent calls judge its health; zero disables circuit breaking
+	WindowSize int
+
+	// MinimumCalls is how many calls must be in the window before the circuit can open
+	MinimumCalls int
+
+	// FailureRatio is the fraction of calls in the window that must fail to open the circuit
+	FailureRatio float64
+
+	OpenDuration time.Duration
+
+	// ProbeInterval is how often each driver is probed with Activate between real calls; zero disables probing
+	ProbeInterval time.Duration
+}
+
+type DriverHealth interface {
+	Runner() ifrit.Runner
+
+	// Allow returns a volman.DriverUnhealthyError if calls to the driver should fail fast
+	Allow(driverId string) error
+
+	// Succeeded is reported for a call the driver responded to, which also moves its LastHealthyAt forward
+	Succeeded(driverId string)
+	Failed(driverId string)
+
+	// Abandoned is reported for an allowed call whose outcome says nothing about the driver's health, such as one
+	// the caller cancelled
+	Abandoned(driverId string)
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+type driverCircuit struct {
+	state         circuitState
+	outcomes      []bool
+	openedAt      time.Time
+	trialInFlight bool
+
+	// failures and calls describe the window at the time the circuit opened
+	failures int
+	calls    int
+}
+
+type driverHealth struct {
+	sync.Mutex
+	logger       lager.Logger
+	registry     DriverRegistry
+	driverCaller driverCaller
+	config       HealthCheckConfig
+	clock        clock.Clock
+	circuits     map[string]*driverCircuit
+}
+
+// NewDriverHealth returns a health tracker for the drivers in the registry.  Its runner probes them in the background
+// using the activate timeout from the policies.
+func NewDriverHealth(logger lager.Logger, registry DriverRegistry, policies DriverPolicies, config HealthCheckConfig, clock clock.Clock) DriverHealth {
+	return &driverHealth{
+		logger:       logger,
+		registry:     registry,
+		driverCaller: driverCaller{clock: clock, policies: policies},
+		config:       config,
+		clock:        clock,
+		circuits:     map[string]*driverCircuit{},
+	}
+}
+
+func (h *driverHealth) Runner() ifrit.Runner {
+	return h
+}
+
+func (h *driverHealth) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	logger := h.logger.Session("driver-health")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	events, unsubscribe := h.registry.Subscribe()
+	defer unsubscribe()
+
+	close(ready)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var timer clock.Timer
+	var probeC <-chan time.Time
+	if h.config.ProbeInterval > 0 {
+		timer = h.clock.NewTimer(h.config.ProbeInterval)
+		defer timer.Stop()
+		probeC = timer.C()
+	}
+
+	probedCh := make(chan struct{}, 1)
+
+	for {
+		select {
+		case <-probeC:
+			go func() {
+				h.probe(logger, ctx)
+				probedCh <- struct{}{}
+			}()
+
+		case <-probedCh:
+			timer.Reset(h.config.ProbeInterval)
+
+		case event := <-events:
+			h.driverChanged(logger, event)
+
+		case signal := <-signals:
+			logger.Info("received-signal", lager.Data{"signal": signal.String()})
+			return nil
+		}
+	}
+}
+
+// driverChanged forgets the health of a driver that has gone, or that is now reached at a different endpoint and so
+// deserves a fresh start
+func (h *driverHealth) driverChanged(logger lager.Logger, event DriverEvent) {
+	var driverId string
+	switch event := event.(type) {
+	case DriverRemoved:
+		driverId = event.DriverId
+	case DriverEndpointChanged:
+		driverId = event.DriverId
+	default:
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	if _, ok := h.circuits[driverId]; ok {
+		logger.Info("forgetting-driver-health", lager.Data{"driverId": driverId})
+		delete(h.circuits, driverId)
+	}
+}
+
+func (h *driverHealth) probe(logger lager.Logger, ctx context.Context) {
+	logger = logger.Session("probe")
+	logger.Debug("start")
+	defer logger.Debug("end")
+
+	for driverId, driver := range h.registry.Drivers() {
+		if err := h.Allow(driverId); err != nil {
+			continue
+		}
+
+		_, err := h.driverCaller.activate(logger, ctx, driverId, driver)
+		switch {
+		case ctx.Err() != nil:
+			h.Abandoned(driverId)
+		case err != nil:
+			logger.Info("driver-probe-failed", lager.Data{"driverId": driverId, "error": err.Error()})
+			h.Failed(driverId)
+		default:
+			h.Succeeded(driverId)
+		}
+	}
+}
+
+func (h *driverHealth) Allow(driverId string) error {
+	if h.config.WindowSize <= 0 {
+		return nil
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	circuit, ok := h.circuits[driverId]
+	if !ok {
+		return nil
+	}
+
+	switch circuit.state {
+	case circuitOpen:
+		if h.clock.Since(circuit.openedAt) < h.config.OpenDuration {
+			return h.unhealthyError(driverId, circuit)
+		}
+		h.logger.Info("driver-circuit-half-open", lager.Data{"driverId": driverId})
+		circuit.state = circuitHalfOpen
+		circuit.trialInFlight = true
+	case circuitHalfOpen:
+		if circuit.trialInFlight {
+			return h.unhealthyError(driverId, circuit)
+		}
+		circuit.trialInFlight = true
+	}
+
+	return nil
+}
+
+func (h *driverHealth) Succeeded(driverId string) {
+	h.registry.Healthy(driverId, h.clock.Now())
+	h.record(driverId, false)
+}
+
+func (h *driverHealth) Failed(driverId string) {
+	h.record(driverId, true)
+}
+
+func (h *driverHealth) Abandoned(driverId string) {
+	h.Lock()
+	defer h.Unlock()
+
+	if circuit, ok := h.circuits[driverId]; ok && circuit.state == circuitHalfOpen {
+		circuit.trialInFlight = false
+	}
+}
+
+func (h *driverHealth) record(driverId string, failed bool) {
+	if h.config.WindowSize <= 0 {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	circuit, ok := h.circuits[driverId]
+	if !ok {
+		circuit = &driverCircuit{}
+		h.circuits[driverId] = circuit
+	}
+
+	switch circuit.state {
+	case circuitHalfOpen:
+		circuit.trialInFlight = false
+		if failed {
+			h.open(driverId, circuit)
+		} else {
+			h.logger.Info("driver-circuit-closed", lager.Data{"driverId": driverId})
+			circuit.state = circuitClosed
+			circuit.outcomes = nil
+		}
+
+	case circuitClosed:
+		circuit.outcomes = append(circuit.outcomes, failed)
+		if len(circuit.outcomes) > h.config.WindowSize {
+			circuit.outcomes = circuit.outcomes[len(circuit.outcomes)-h.config.WindowSize:]
+		}
+
+		failures := 0
+		for _, outcome := range circuit.outcomes {
+			if outcome {
+				failures++
+			}
+		}
+
+		calls := len(circuit.outcomes)
+		if calls >= h.config.MinimumCalls && float64(failures) >= h.config.FailureRatio*float64(calls) && failures > 0 {
+			circuit.failures, circuit.calls = failures, calls
+			h.open(driverId, circuit)
+		}
+	}
+}
+
+func (h *driverHealth) open(driverId string, circuit *driverCircuit) {
+	h.logger.Info("driver-circuit-open", lager.Data{"driverId": driverId, "failures": circuit.failures, "calls": circuit.calls})
+	circuit.state = circuitOpen
+	circuit.openedAt = h.clock.Now()
+	circuit.outcomes = nil
+}
+
+func (h *driverHealth) unhealthyError(driverId string, circuit *driverCircuit) error {
+	retryAfter := circuit.openedAt.Add(h.config.OpenDuration).Sub(h.clock.Now())
+	if retryAfter < 0 {
+		retryAfter = 0
+	}
+
+	return volman.DriverUnhealthyError{
+		DriverId:   driverId,
+		Failures:   circuit.failures,
+		Calls:      circuit.calls,
+		RetryAfter: retryAfter,
+	}
+}
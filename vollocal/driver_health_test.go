@@ -0,0 +1,225 @@
+package vollocal_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	mfakes "code.cloudfoundry.org/go-loggregator/loggregator_v2/fakes"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/voldriver"
+	"code.cloudfoundry.org/voldriver/voldriverfakes"
+	"code.cloudfoundry.org/volman"
+	"code.cloudfoundry.org/volman/vollocal"
+	"github.com/tedsuo/ifrit"
+	"github.com/tedsuo/ifrit/ginkgomon"
+)
+
+var _ = Describe("DriverHealth", func() {
+	var (
+		logger     *lagertest.TestLogger
+		fakeClock  *fakeclock.FakeClock
+		fakeDriver *voldriverfakes.FakeDriver
+		registry   vollocal.DriverRegistry
+		config     vollocal.HealthCheckConfig
+		health     vollocal.DriverHealth
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("driver-health")
+		fakeClock = fakeclock.NewFakeClock(time.Unix(123, 456))
+		fakeDriver = new(voldriverfakes.FakeDriver)
+		registry = vollocal.NewDriverRegistryWith(map[string]voldriver.Driver{"fakedriver": fakeDriver})
+
+		config = vollocal.HealthCheckConfig{
+			WindowSize:   4,
+			MinimumCalls: 2,
+			FailureRatio: 0.5,
+			OpenDuration: 30 * time.Second,
+		}
+	})
+
+	JustBeforeEach(func() {
+		health = vollocal.NewDriverHealth(logger, registry, vollocal.DriverPolicies{}, config, fakeClock)
+	})
+
+	It("moves the driver's last healthy time forward when a call succeeds, but not when one fails", func() {
+		registry.SetInfos(map[string]vollocal.DriverInfo{"fakedriver": {DiscoveredAt: fakeClock.Now(), LastHealthyAt: fakeClock.Now()}})
+
+		fakeClock.Increment(time.Minute)
+		health.Succeeded("fakedriver")
+		Expect(registry.Infos()["fakedriver"].LastHealthyAt).To(Equal(fakeClock.Now()))
+
+		healthyAt := fakeClock.Now()
+		fakeClock.Increment(time.Minute)
+		health.Failed("fakedriver")
+		Expect(registry.Infos()["fakedriver"].LastHealthyAt).To(Equal(healthyAt))
+	})
+
+	It("allows calls to drivers it knows nothing about", func() {
+		Expect(health.Allow("fakedriver")).To(Succeed())
+	})
+
+	It("keeps the circuit closed until the minimum number of calls is reached", func() {
+		health.Failed("fakedriver")
+		Expect(health.Allow("fakedriver")).To(Succeed())
+	})
+
+	It("keeps the circuit closed while failures stay below the ratio", func() {
+		health.Succeeded("fakedriver")
+		health.Succeeded("fakedriver")
+		health.Succeeded("fakedriver")
+		health.Failed("fakedriver")
+		Expect(health.Allow("fakedriver")).To(Succeed())
+	})
+
+	Context("when too many calls fail", func() {
+		JustBeforeEach(func() {
+			health.Succeeded("fakedriver")
+			health.Failed("fakedriver")
+		})
+
+		It("opens the circuit", func() {
+			err := health.Allow("fakedriver")
+			Expect(err).To(Equal(volman.DriverUnhealthyError{DriverId: "fakedriver", Failures: 1, Calls: 2, RetryAfter: 30 * time.Second}))
+		})
+
+		Context("once the open duration has passed", func() {
+			JustBeforeEach(func() {
+				fakeClock.Increment(30 * time.Second)
+			})
+
+			It("lets a single trial call through", func() {
+				Expect(health.Allow("fakedriver")).To(Succeed())
+				Expect(health.Allow("fakedriver")).To(BeAssignableToTypeOf(volman.DriverUnhealthyError{}))
+			})
+
+			It("closes the circuit when the trial succeeds", func() {
+				Expect(health.Allow("fakedriver")).To(Succeed())
+				health.Succeeded("fakedriver")
+
+				Expect(health.Allow("fakedriver")).To(Succeed())
+				Expect(health.Allow("fakedriver")).To(Succeed())
+			})
+
+			It("opens the circuit again when the trial fails", func() {
+				Expect(health.Allow("fakedriver")).To(Succeed())
+				health.Failed("fakedriver")
+
+				Expect(health.Allow("fakedriver")).To(BeAssignableToTypeOf(volman.DriverUnhealthyError{}))
+			})
+
+			It("lets another trial through when the trial is abandoned", func() {
+				Expect(health.Allow("fakedriver")).To(Succeed())
+				health.Abandoned("fakedriver")
+
+				Expect(health.Allow("fakedriver")).To(Succeed())
+			})
+		})
+	})
+
+	Context("when circuit breaking is disabled", func() {
+		BeforeEach(func() {
+			config.WindowSize = 0
+		})
+
+		It("always allows calls", func() {
+			health.Failed("fakedriver")
+			health.Failed("fakedriver")
+			Expect(health.Allow("fakedriver")).To(Succeed())
+		})
+	})
+
+	Context("when probing", func() {
+		var process ifrit.Process
+
+		BeforeEach(func() {
+			config.ProbeInterval = 10 * time.Second
+			fakeDriver.ActivateReturns(voldriver.ActivateResponse{Err: "connection refused"})
+		})
+
+		JustBeforeEach(func() {
+			process = ginkgomon.Invoke(health.Runner())
+		})
+
+		AfterEach(func() {
+			ginkgomon.Kill(process)
+		})
+
+		It("opens the circuit of a driver that fails its probes", func() {
+			fakeClock.WaitForWatcherAndIncrement(10 * time.Second)
+			Eventually(fakeDriver.ActivateCallCount).Should(Equal(1))
+
+			fakeClock.WaitForWatcherAndIncrement(10 * time.Second)
+			Eventually(fakeDriver.ActivateCallCount).Should(Equal(2))
+
+			Eventually(func() error { return health.Allow("fakedriver") }).Should(BeAssignableToTypeOf(volman.DriverUnhealthyError{}))
+		})
+
+		It("forgets the health of a driver whose endpoint changes", func() {
+			health.Failed("fakedriver")
+			health.Failed("fakedriver")
+			Expect(health.Allow("fakedriver")).To(BeAssignableToTypeOf(volman.DriverUnhealthyError{}))
+
+			registry.Set(map[string]voldriver.Driver{"fakedriver": new(voldriverfakes.FakeDriver)})
+
+			Eventually(func() error { return health.Allow("fakedriver") }).Should(Succeed())
+		})
+	})
+
+	Describe("when used by the local client", func() {
+		var client volman.Manager
+
+		JustBeforeEach(func() {
+			client = vollocal.NewLocalClientWithOptions(logger, registry, vollocal.LocalClientOptions{Health: health, MetronClient: new(mfakes.FakeClient), Clock: fakeClock})
+		})
+
+		It("fails fast without calling a driver whose circuit is open", func() {
+			health.Failed("fakedriver")
+			health.Failed("fakedriver")
+
+			err := client.Unmount(logger, context.Background(), "fakedriver", "fake-volume", "some-container")
+			Expect(err).To(BeAssignableToTypeOf(volman.DriverUnhealthyError{}))
+			Expect(fakeDriver.UnmountCallCount()).To(Equal(0))
+		})
+
+		It("reports the outcome of its calls", func() {
+			fakeDriver.UnmountReturns(voldriver.ErrorResponse{Err: "dial unix /var/vcap/data/fakedriver.sock: connect: connection refused"})
+
+			client.Unmount(logger, context.Background(), "fakedriver", "fake-volume", "some-container")
+			client.Unmount(logger, context.Background(), "fakedriver", "fake-volume", "some-container")
+
+			Expect(health.Allow("fakedriver")).To(BeAssignableToTypeOf(volman.DriverUnhealthyError{}))
+		})
+
+		It("moves the driver's last healthy time forward after a successful call", func() {
+			discoveredAt := fakeClock.Now()
+			registry.SetInfos(map[string]vollocal.DriverInfo{"fakedriver": {DiscoveredAt: discoveredAt, LastHealthyAt: discoveredAt}})
+
+			fakeClock.Increment(time.Minute)
+			fakeDriver.MountReturns(voldriver.MountResponse{Mountpoint: "/var/vcap/data/mounts/fake-volume"})
+			_, err := client.Mount(logger, context.Background(), "fakedriver", "fake-volume", "some-container", map[string]interface{}{})
+			Expect(err).NotTo(HaveOccurred())
+
+			drivers, err := client.ListDrivers(logger, context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(drivers.Drivers[0].DiscoveredAt).To(Equal(discoveredAt))
+			Expect(drivers.Drivers[0].LastHealthyAt).To(Equal(fakeClock.Now()))
+		})
+
+		It("keeps the circuit closed when the driver keeps replying with errors about the requests", func() {
+			fakeDriver.CreateReturns(voldriver.ErrorResponse{Err: "invalid config: missing source"})
+
+			for i := 0; i < 6; i++ {
+				_, err := client.Mount(logger, context.Background(), "fakedriver", "fake-volume", "some-container", map[string]interface{}{})
+				Expect(err).To(BeAssignableToTypeOf(volman.DriverError{}))
+			}
+
+			Expect(health.Allow("fakedriver")).To(Succeed())
+			Expect(fakeDriver.CreateCallCount()).To(Equal(6))
+		})
+	})
+})
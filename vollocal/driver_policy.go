@@ -0,0 +1,119 @@
+package vollocal
+
+import (
+	"math/rand"
+	"time"
+)
+
+// DriverPolicy bounds how long volman waits on each kind of driver call and how it retries calls that fail.  A zero
+// timeout means volman waits for as long as the caller's context allows.  Capabilities calls, made during discovery
+// along with Activate, share the activate timeout, Path and Get lookups share the list timeout, and Remove shares the
+// unmount timeout.
+type DriverPolicy struct {
+	ActivateTimeout time.Duration
+	CreateTimeout   time.Duration
+	MountTimeout    time.Duration
+	UnmountTimeout  time.Duration
+	ListTimeout     time.Duration
+
+	// Retries is how many more times a call that timed out or could not reach the driver is attempted.  Calls the
+	// driver replied to with an error are not retried, and creates and mounts are only retried when volman could not
+	// connect to the driver at all.
+	Retries int
+
+	// RetryBackoff is the wait before the first retry; it doubles with each further retry up to MaxRetryBackoff
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+
+	// RetryJitter is the fraction, between 0 and 1, of each backoff that is taken off at random so that callers
+	// retrying together spread out
+	RetryJitter float64
+}
+
+// DriverPolicyOverride overrides parts of the default policy for one driver.  Fields left nil fall back to the
+// default, so an override can set a field to zero, for instance to turn off retries for a driver.
+type DriverPolicyOverride struct {
+	ActivateTimeout *time.Duration
+	CreateTimeout   *time.Duration
+	MountTimeout    *time.Duration
+	UnmountTimeout  *time.Duration
+	ListTimeout     *time.Duration
+
+	Retries         *int
+	RetryBackoff    *time.Duration
+	MaxRetryBackoff *time.Duration
+	RetryJitter     *float64
+}
+
+// DriverPolicies holds the default policy and any overrides for individual drivers
+type DriverPolicies struct {
+	Default DriverPolicy
+	Drivers map[string]DriverPolicyOverride
+}
+
+func (p DriverPolicies) For(driverId string) DriverPolicy {
+	policy := p.Default
+
+	override, ok := p.Drivers[driverId]
+	if !ok {
+		return policy
+	}
+
+	overrideDuration(&policy.ActivateTimeout, override.ActivateTimeout)
+	overrideDuration(&policy.CreateTimeout, override.CreateTimeout)
+	overrideDuration(&policy.MountTimeout, override.MountTimeout)
+	overrideDuration(&policy.UnmountTimeout, override.UnmountTimeout)
+	overrideDuration(&policy.ListTimeout, override.ListTimeout)
+	if override.Retries != nil {
+		policy.Retries = *override.Retries
+	}
+	overrideDuration(&policy.RetryBackoff, override.RetryBackoff)
+	overrideDuration(&policy.MaxRetryBackoff, override.MaxRetryBackoff)
+	if override.RetryJitter != nil {
+		policy.RetryJitter = *override.RetryJitter
+	}
+
+	return policy
+}
+
+func overrideDuration(field *time.Duration, override *time.Duration) {
+	if override != nil {
+		*field = *override
+	}
+}
+
+func (p DriverPolicy) timeout(operation string) time.Duration {
+	switch operation {
+	case "activate", "capabilities":
+		return p.ActivateTimeout
+	case "create":
+		return p.CreateTimeout
+	case "mount":
+		return p.MountTimeout
+	case "unmount", "remove":
+		return p.UnmountTimeout
+	case "list", "path", "get":
+		return p.ListTimeout
+	default:
+		return 0
+	}
+}
+
+// backoff returns the wait before the given retry, counting from zero
+func (p DriverPolicy) backoff(retry int) time.Duration {
+	backoff := p.RetryBackoff
+	for i := 0; i < retry; i++ {
+		if p.MaxRetryBackoff > 0 && backoff >= p.MaxRetryBackoff {
+			break
+		}
+		backoff *= 2
+	}
+	if p.MaxRetryBackoff > 0 && backoff > p.MaxRetryBackoff {
+		backoff = p.MaxRetryBackoff
+	}
+
+	if p.RetryJitter > 0 {
+		backoff -= time.Duration(rand.Float64() * p.RetryJitter * float64(backoff))
+	}
+	return backoff
+}
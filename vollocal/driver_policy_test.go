@@ -0,0 +1,57 @@
+package vollocal_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"code.cloudfoundry.org/volman/vollocal"
+)
+
+var _ = Describe("DriverPolicies", func() {
+	var policies vollocal.DriverPolicies
+
+	BeforeEach(func() {
+		policies = vollocal.DriverPolicies{
+			Default: vollocal.DriverPolicy{
+				MountTimeout:   time.Minute,
+				UnmountTimeout: time.Minute,
+				Retries:        2,
+				RetryBackoff:   time.Second,
+			},
+			Drivers: map[string]vollocal.DriverPolicyOverride{
+				"slowdriver":    {MountTimeout: durationPtr(5 * time.Minute), Retries: intPtr(5)},
+				"noretrydriver": {Retries: intPtr(0), RetryBackoff: durationPtr(0)},
+			},
+		}
+	})
+
+	It("returns the default policy for drivers without an override", func() {
+		Expect(policies.For("fakedriver")).To(Equal(policies.Default))
+	})
+
+	It("overrides only the fields set for the driver", func() {
+		Expect(policies.For("slowdriver")).To(Equal(vollocal.DriverPolicy{
+			MountTimeout:   5 * time.Minute,
+			UnmountTimeout: time.Minute,
+			Retries:        5,
+			RetryBackoff:   time.Second,
+		}))
+	})
+
+	It("lets an override set a field to zero", func() {
+		Expect(policies.For("noretrydriver")).To(Equal(vollocal.DriverPolicy{
+			MountTimeout:   time.Minute,
+			UnmountTimeout: time.Minute,
+		}))
+	})
+})
+
+func durationPtr(d time.Duration) *time.Duration {
+	return &d
+}
+
+func intPtr(n int) *int {
+	return &n
+}
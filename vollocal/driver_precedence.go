@@ -0,0 +1,78 @@
+package vollocal
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/volman"
+)
+
+const volmanDriverNameCollisions = "VolmanDriverNameCollisions"
+
+// Policies for choosing between spec files, found across or within DriverPaths, that name the same driver
+const (
+	// PrecedenceFirstWins tries the driver's spec files in DriverPaths order and, within a path, sock before spec
+	// before json, activating the driver from the first that responds
+	PrecedenceFirstWins = "first-wins"
+
+	// PrecedenceErrorOnConflict refuses to activate a driver named by more than one spec file
+	PrecedenceErrorOnConflict = "error-on-conflict"
+
+	// PrecedencePreferFormat tries spec files of the preferred format first, in first-wins order, and then the rest
+	PrecedencePreferFormat = "prefer-format"
+)
+
+// DriverPrecedence decides which spec file a driver is activated from when several name it.  An empty or unknown
+// policy is treated as first-wins.
+type DriverPrecedence struct {
+	Policy string
+
+	// Format is the spec format, sock, spec or json, preferred by the prefer-format policy
+	Format string
+}
+
+// order returns the candidates in the order they should be tried, or an error if the policy refuses to choose
+// between them
+func (p DriverPrecedence) order(specName string, candidates []driverCandidate) ([]driverCandidate, error) {
+	if len(candidates) < 2 {
+		return candidates, nil
+	}
+
+	switch p.Policy {
+	case PrecedenceErrorOnConflict:
+		return nil, fmt.Errorf("driver '%s' is named by %d spec files: %s", specName, len(candidates), strings.Join(candidateFiles(candidates), ", "))
+
+	case PrecedencePreferFormat:
+		ordered := append([]driverCandidate{}, candidates...)
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return ordered[i].format() == p.Format && ordered[j].format() != p.Format
+		})
+		return ordered, nil
+
+	default:
+		return candidates, nil
+	}
+}
+
+func (c driverCandidate) path() string {
+	return filepath.Join(c.driverPath, c.specFile)
+}
+
+func (c driverCandidate) format() string {
+	return strings.TrimPrefix(filepath.Ext(c.specFile), ".")
+}
+
+func candidateFiles(candidates []driverCandidate) []string {
+	files := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		files = append(files, candidate.path())
+	}
+	return files
+}
+
+// newDriverCollision describes the spec files that named the same driver, in the order they were tried
+func newDriverCollision(specName string, candidates []driverCandidate) volman.DriverCollision {
+	return volman.DriverCollision{Name: specName, SpecFiles: candidateFiles(candidates)}
+}
@@ -2,8 +2,10 @@ package vollocal
 
 import (
 	"sync"
+	"time"
 
 	"code.cloudfoundry.org/voldriver"
+	"code.cloudfoundry.org/volman"
 )
 
 type DriverRegistry interface {
@@ -11,22 +13,78 @@ type DriverRegistry interface {
 	Drivers() map[string]voldriver.Driver
 	Set(drivers map[string]voldriver.Driver)
 	Keys() []string
+
+	// Subscribe returns the events for every change Set makes to the drivers from now on, in order, and a function
+	// that ends the subscription and closes the channel
+	Subscribe() (<-chan DriverEvent, func())
+
+	// BeforeChange registers a function that Set calls with each change it is about to make, before the change is
+	// seen through Driver or Drivers, and returns a function that unregisters it.  Set waits for the function, which
+	// must not call back into the registry.
+	BeforeChange(hook func(DriverEvent)) func()
+
+	// Infos returns what discovery learned about each driver
+	Infos() map[string]DriverInfo
+	SetInfos(infos map[string]DriverInfo)
+
+	// Healthy moves a driver's LastHealthyAt forward to the time it was last seen to respond
+	Healthy(driverId string, at time.Time)
+
+	// Collisions returns the drivers discovery found named by more than one spec file
+	Collisions() []volman.DriverCollision
+	SetCollisions(collisions []volman.DriverCollision)
+
+	// Replace sets the drivers, their infos and the collisions in one change, so that no reader sees the drivers of
+	// one discovery with the infos of another.  Hooks and subscribers see the change to the drivers as they do for Set.
+	Replace(drivers map[string]voldriver.Driver, infos map[string]DriverInfo, collisions []volman.DriverCollision)
+
+	// Snapshot returns the drivers, their infos and the collisions as they were at one moment
+	Snapshot() (map[string]voldriver.Driver, map[string]DriverInfo, []volman.DriverCollision)
+}
+
+// Drivers report their scope through Capabilities.  Volumes of a local scope driver belong to this cell alone, while
+// those of a global scope driver are shared with other cells and so are never purged by volman.  Nor are the volumes of
+// a driver whose scope is not known.
+const (
+	DriverScopeLocal  = "local"
+	DriverScopeGlobal = "global"
+)
+
+// DriverInfo is what discovery learned about a driver
+type DriverInfo struct {
+	DriverSpec
+	Implements []string
+
+	// Scope is empty when the driver's capabilities could not be fetched
+	Scope         string
+	DiscoveredAt  time.Time
+	LastHealthyAt time.Time
 }
 
 type driverRegistry struct {
 	sync.RWMutex
 	registryEntries map[string]voldriver.Driver
+	infos           map[string]DriverInfo
+	collisions      []volman.DriverCollision
+	subscriptions   map[*driverSubscription]struct{}
+	hooks           map[*func(DriverEvent)]struct{}
 }
 
 func NewDriverRegistry() DriverRegistry {
 	return &driverRegistry{
 		registryEntries: map[string]voldriver.Driver{},
+		infos:           map[string]DriverInfo{},
+		subscriptions:   map[*driverSubscription]struct{}{},
+		hooks:           map[*func(DriverEvent)]struct{}{},
 	}
 }
 
 func NewDriverRegistryWith(initialMap map[string]voldriver.Driver) DriverRegistry {
 	return &driverRegistry{
 		registryEntries: initialMap,
+		infos:           map[string]DriverInfo{},
+		subscriptions:   map[*driverSubscription]struct{}{},
+		hooks:           map[*func(DriverEvent)]struct{}{},
 	}
 }
 
@@ -52,7 +110,69 @@ func (d *driverRegistry) Set(drivers map[string]voldriver.Driver) {
 	d.Lock()
 	defer d.Unlock()
 
+	d.setDrivers(drivers)
+}
+
+func (d *driverRegistry) Replace(drivers map[string]voldriver.Driver, infos map[string]DriverInfo, collisions []volman.DriverCollision) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.setDrivers(drivers)
+	d.infos = infos
+	d.collisions = collisions
+}
+
+func (d *driverRegistry) Snapshot() (map[string]voldriver.Driver, map[string]DriverInfo, []volman.DriverCollision) {
+	d.RLock()
+	defer d.RUnlock()
+
+	return d.registryEntries, d.infos, d.collisions
+}
+
+// setDrivers tells the hooks of the changes, swaps in the drivers and then tells the subscribers.  It must be called
+// with the lock held.
+func (d *driverRegistry) setDrivers(drivers map[string]voldriver.Driver) {
+	events := diffDrivers(d.registryEntries, drivers)
+	for hook := range d.hooks {
+		for _, event := range events {
+			(*hook)(event)
+		}
+	}
 	d.registryEntries = drivers
+
+	for subscription := range d.subscriptions {
+		subscription.publish(events)
+	}
+}
+
+func (d *driverRegistry) Subscribe() (<-chan DriverEvent, func()) {
+	d.Lock()
+	defer d.Unlock()
+
+	subscription := newDriverSubscription()
+	d.subscriptions[subscription] = struct{}{}
+
+	return subscription.events, func() {
+		d.Lock()
+		delete(d.subscriptions, subscription)
+		d.Unlock()
+
+		subscription.close()
+	}
+}
+
+func (d *driverRegistry) BeforeChange(hook func(DriverEvent)) func() {
+	d.Lock()
+	defer d.Unlock()
+
+	d.hooks[&hook] = struct{}{}
+
+	return func() {
+		d.Lock()
+		defer d.Unlock()
+
+		delete(d.hooks, &hook)
+	}
 }
 
 func (d *driverRegistry) Keys() []string {
@@ -67,6 +187,53 @@ func (d *driverRegistry) Keys() []string {
 	return keys
 }
 
+func (d *driverRegistry) Infos() map[string]DriverInfo {
+	d.RLock()
+	defer d.RUnlock()
+
+	return d.infos
+}
+
+func (d *driverRegistry) SetInfos(infos map[string]DriverInfo) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.infos = infos
+}
+
+func (d *driverRegistry) Healthy(driverId string, at time.Time) {
+	d.Lock()
+	defer d.Unlock()
+
+	info, ok := d.infos[driverId]
+	if !ok || !at.After(info.LastHealthyAt) {
+		return
+	}
+	info.LastHealthyAt = at
+
+	// callers of Infos and Snapshot may still be reading the current map, so a copy is changed instead
+	infos := make(map[string]DriverInfo, len(d.infos))
+	for id, existing := range d.infos {
+		infos[id] = existing
+	}
+	infos[driverId] = info
+	d.infos = infos
+}
+
+func (d *driverRegistry) Collisions() []volman.DriverCollision {
+	d.RLock()
+	defer d.RUnlock()
+
+	return d.collisions
+}
+
+func (d *driverRegistry) SetCollisions(collisions []volman.DriverCollision) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.collisions = collisions
+}
+
 func (d *driverRegistry) containsDriver(id string) bool {
 	_, ok := d.registryEntries[id]
 	return ok
@@ -8,6 +8,7 @@ import (
 
 	"code.cloudfoundry.org/voldriver"
 	"code.cloudfoundry.org/voldriver/voldriverfakes"
+	"code.cloudfoundry.org/volman"
 )
 
 var _ = Describe("DriverRegistry", func() {
@@ -78,6 +79,125 @@ var _ = Describe("DriverRegistry", func() {
 		})
 	})
 
+	Describe("#Subscribe", func() {
+		var (
+			events      <-chan DriverEvent
+			unsubscribe func()
+			oneDriver   voldriver.Driver
+		)
+
+		BeforeEach(func() {
+			oneDriver, _ = manyRegistry.Driver("one")
+			events, unsubscribe = manyRegistry.Subscribe()
+		})
+
+		AfterEach(func() {
+			unsubscribe()
+		})
+
+		It("sends an event for each driver added, removed or replaced, in driver order", func() {
+			twoDriver, _ := manyRegistry.Driver("two")
+			newTwoDriver := new(voldriverfakes.FakeDriver)
+			threeDriver := new(voldriverfakes.FakeDriver)
+
+			manyRegistry.Set(map[string]voldriver.Driver{
+				"three": threeDriver,
+				"two":   newTwoDriver,
+			})
+
+			Eventually(events).Should(Receive(Equal(DriverRemoved{DriverId: "one", Driver: oneDriver})))
+			Eventually(events).Should(Receive(Equal(DriverAdded{DriverId: "three", Driver: threeDriver})))
+			Eventually(events).Should(Receive(Equal(DriverEndpointChanged{DriverId: "two", Old: twoDriver, New: newTwoDriver})))
+		})
+
+		It("sends nothing for drivers that are unchanged", func() {
+			manyRegistry.Set(manyRegistry.Drivers())
+			Consistently(events).ShouldNot(Receive())
+		})
+
+		It("does not hold up Set while the subscriber is not reading", func() {
+			for i := 0; i < 100; i++ {
+				manyRegistry.Set(map[string]voldriver.Driver{})
+				manyRegistry.Set(map[string]voldriver.Driver{"one": oneDriver})
+			}
+
+			Eventually(events).Should(Receive(Equal(DriverRemoved{DriverId: "one", Driver: oneDriver})))
+		})
+
+		It("closes the channel once unsubscribed", func() {
+			unsubscribe()
+			Eventually(events).Should(BeClosed())
+
+			manyRegistry.Set(map[string]voldriver.Driver{})
+		})
+	})
+
+	Describe("#BeforeChange", func() {
+		It("calls the hook with each change before Set returns", func() {
+			oneDriver, _ := oneRegistry.Driver("one")
+			newOneDriver := new(voldriverfakes.FakeDriver)
+
+			var changes []DriverEvent
+			unregister := oneRegistry.BeforeChange(func(event DriverEvent) {
+				changes = append(changes, event)
+			})
+
+			oneRegistry.Set(map[string]voldriver.Driver{"one": newOneDriver})
+			Expect(changes).To(Equal([]DriverEvent{DriverEndpointChanged{DriverId: "one", Old: oneDriver, New: newOneDriver}}))
+
+			unregister()
+			oneRegistry.Set(map[string]voldriver.Driver{})
+			Expect(changes).To(HaveLen(1))
+		})
+	})
+
+	Describe("#Replace", func() {
+		It("replaces the drivers, their infos and the collisions", func() {
+			oneDriver, _ := oneRegistry.Driver("one")
+			twoDriver := new(voldriverfakes.FakeDriver)
+
+			events, unsubscribe := oneRegistry.Subscribe()
+			defer unsubscribe()
+
+			collisions := []volman.DriverCollision{{Name: "two", SpecFiles: []string{"/a/two.spec", "/b/two.spec"}, Chosen: "/a/two.spec"}}
+			oneRegistry.Replace(map[string]voldriver.Driver{"two": twoDriver}, map[string]DriverInfo{"two": {Scope: DriverScopeLocal}}, collisions)
+
+			drivers, infos, replacedCollisions := oneRegistry.Snapshot()
+			Expect(drivers).To(Equal(map[string]voldriver.Driver{"two": twoDriver}))
+			Expect(infos).To(Equal(map[string]DriverInfo{"two": {Scope: DriverScopeLocal}}))
+			Expect(replacedCollisions).To(Equal(collisions))
+
+			Eventually(events).Should(Receive(Equal(DriverRemoved{DriverId: "one", Driver: oneDriver})))
+			Eventually(events).Should(Receive(Equal(DriverAdded{DriverId: "two", Driver: twoDriver})))
+		})
+
+		It("is never seen half done", func() {
+			oneDriver, _ := oneRegistry.Driver("one")
+
+			replaced := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				defer close(replaced)
+
+				for i := 0; i < 100; i++ {
+					emptyRegistry.Replace(map[string]voldriver.Driver{"one": oneDriver}, map[string]DriverInfo{"one": {Scope: DriverScopeLocal}}, nil)
+					emptyRegistry.Replace(map[string]voldriver.Driver{}, map[string]DriverInfo{}, nil)
+				}
+			}()
+
+			for {
+				drivers, infos, _ := emptyRegistry.Snapshot()
+				Expect(infos).To(HaveLen(len(drivers)))
+
+				select {
+				case <-replaced:
+					return
+				default:
+				}
+			}
+		})
+	})
+
 	Describe("#Keys", func() {
 		It("should return return {'one'} for oneRegistry keys", func() {
 			keys := emptyRegistry.Keys()
@@ -14,49 +14,106 @@ import (
 	"context"
 
 	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/go-loggregator/loggregator_v2"
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/voldriver"
-	"code.cloudfoundry.org/voldriver/driverhttp"
+	"code.cloudfoundry.org/volman"
+	"github.com/fsnotify/fsnotify"
 	"github.com/tedsuo/ifrit"
 )
 
+var driverSpecPattern = regexp.MustCompile("([^/]*/)?([^/]*)\\.(sock|spec|json)$")
+
+type driverCandidate struct {
+	driverPath string
+	specFile   string
+}
+
 type DriverSyncer interface {
 	Runner() ifrit.Runner
-	Discover(logger lager.Logger) (map[string]voldriver.Driver, error)
+	Discover(logger lager.Logger, ctx context.Context) (map[string]voldriver.Driver, error)
+
+	// Sync has the running syncer discover drivers now rather than at its next scan, returning once the drivers it
+	// discovered are registered
+	Sync(logger lager.Logger, ctx context.Context) error
 }
 
 type driverSyncer struct {
 	sync.RWMutex
 	logger        lager.Logger
 	driverFactory DriverFactory
+	driverCaller  driverCaller
 	scanInterval  time.Duration
+	watchDebounce time.Duration
 	clock         clock.Clock
 
+	// activationWorkers bounds how many drivers are activated at once during discovery
+	activationWorkers int
+
+	precedence   DriverPrecedence
+	metronClient loggregator_v2.Client
+
 	driverRegistry DriverRegistry
 	driverPaths    []string
+
+	// syncRequests carries Sync's requests to the running syncer, each with where to send the discovery's outcome
+	syncRequests chan chan error
 }
 
 func NewDriverSyncer(logger lager.Logger, driverRegistry DriverRegistry, driverPaths []string, scanInterval time.Duration, clock clock.Clock) *driverSyncer {
-	return &driverSyncer{
-		logger:        logger,
-		driverFactory: NewDriverFactory(),
-		scanInterval:  scanInterval,
-		clock:         clock,
-
-		driverRegistry: driverRegistry,
-		driverPaths:    driverPaths,
-	}
+	return NewDriverSyncerWithDriverFactory(logger, driverRegistry, driverPaths, scanInterval, clock, NewDriverFactory())
 }
 
 func NewDriverSyncerWithDriverFactory(logger lager.Logger, driverRegistry DriverRegistry, driverPaths []string, scanInterval time.Duration, clock clock.Clock, factory DriverFactory) *driverSyncer {
+	return NewDriverSyncerWithOptions(logger, driverRegistry, driverPaths, scanInterval, clock, DriverSyncerOptions{Factory: factory})
+}
+
+// DriverSyncerOptions tune how a driver syncer discovers drivers.  Any left unset fall back to a default: the real
+// driver factory, the default policies, polling only, one activation at a time, the first spec file winning, and
+// no metrics.
+type DriverSyncerOptions struct {
+	Factory DriverFactory
+
+	// Policies time out and retry driver activation
+	Policies DriverPolicies
+
+	// WatchDebounce, when set, has the syncer also watch the driver paths, rediscovering drivers once spec files have
+	// stopped being added, renamed or removed for this long.  Polling carries on as a fallback.
+	WatchDebounce time.Duration
+
+	// ActivationWorkers is how many drivers are activated at once during discovery, each under the activate timeout
+	// of its policy.  Which spec wins for a driver is the same as when drivers are activated one at a time.
+	ActivationWorkers int
+
+	// Precedence chooses between the spec files naming the same driver.  Every such collision is recorded in the
+	// registry and, when there is a metron client, how many there are is reported after each discovery.
+	Precedence   DriverPrecedence
+	MetronClient loggregator_v2.Client
+}
+
+func NewDriverSyncerWithOptions(logger lager.Logger, driverRegistry DriverRegistry, driverPaths []string, scanInterval time.Duration, clock clock.Clock, options DriverSyncerOptions) *driverSyncer {
+	if options.Factory == nil {
+		options.Factory = NewDriverFactory()
+	}
+	if options.ActivationWorkers < 1 {
+		options.ActivationWorkers = 1
+	}
+
 	return &driverSyncer{
-		logger:        logger,
-		driverFactory: factory,
-		scanInterval:  scanInterval,
-		clock:         clock,
+		logger:            logger,
+		driverFactory:     options.Factory,
+		driverCaller:      driverCaller{clock: clock, policies: options.Policies},
+		scanInterval:      scanInterval,
+		watchDebounce:     options.WatchDebounce,
+		clock:             clock,
+		activationWorkers: options.ActivationWorkers,
+		precedence:        options.Precedence,
+		metronClient:      options.MetronClient,
 
 		driverRegistry: driverRegistry,
 		driverPaths:    driverPaths,
+
+		syncRequests: make(chan chan error),
 	}
 }
 
@@ -72,32 +129,119 @@ func (r *driverSyncer) Run(signals <-chan os.Signal, ready chan<- struct{}) erro
 	timer := r.clock.NewTimer(r.scanInterval)
 	defer timer.Stop()
 
-	drivers, err := r.Discover(logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	drivers, infos, collisions, err := r.discover(logger, ctx)
 	if err != nil {
 		return err
 	}
-	r.setDrivers(drivers)
+	r.setDrivers(logger, drivers, infos, collisions)
+
+	var watchEvents <-chan fsnotify.Event
+	var watchErrors <-chan error
+	if r.watchDebounce > 0 {
+		watcher, err := r.watchDriverPaths(logger)
+		if err != nil {
+			logger.Error("failed-watching-driver-paths-falling-back-to-polling", err)
+		} else {
+			defer watcher.Close()
+			watchEvents, watchErrors = watcher.Events, watcher.Errors
+		}
+	}
 
 	close(ready)
 
-	newDriverCh := make(chan map[string]voldriver.Driver, 1)
+	type discovery struct {
+		drivers    map[string]voldriver.Driver
+		infos      map[string]DriverInfo
+		collisions []volman.DriverCollision
+		err        error
+	}
+	newDriverCh := make(chan discovery, 1)
+
+	discovering, rediscover := false, false
+
+	// syncs waiting on the discovery under way, and on the one after it
+	var syncing, syncingNext []chan error
+	startDiscovery := func() {
+		if discovering {
+			rediscover = true
+			return
+		}
+		discovering = true
+
+		go func() {
+			drivers, infos, collisions, err := r.discover(logger, ctx)
+			if err != nil {
+				logger.Error("volman-driver-discovery-failed", err)
+				newDriverCh <- discovery{err: err}
+			} else {
+				newDriverCh <- discovery{drivers: drivers, infos: infos, collisions: collisions}
+			}
+		}()
+	}
+
+	var debounce clock.Timer
+	var debounceC <-chan time.Time
+	defer func() {
+		if debounce != nil {
+			debounce.Stop()
+		}
+	}()
 
 	for {
 		select {
 		case <-timer.C():
-			go func() {
-				drivers, err := r.Discover(logger)
-				if err != nil {
-					logger.Error("volman-driver-discovery-failed", err)
-					newDriverCh <- nil
-				} else {
-					newDriverCh <- drivers
-				}
-			}()
+			startDiscovery()
+
+		case event := <-watchEvents:
+			if !isDriverSpecChange(event) {
+				continue
+			}
+			logger.Debug("driver-spec-changed", lager.Data{"name": event.Name, "op": event.Op.String()})
+
+			if debounce == nil {
+				debounce = r.clock.NewTimer(r.watchDebounce)
+			} else {
+				debounce.Reset(r.watchDebounce)
+			}
+			debounceC = debounce.C()
+
+		case <-debounceC:
+			debounceC = nil
+			startDiscovery()
 
-		case drivers := <-newDriverCh:
-			r.setDrivers(drivers)
-			timer.Reset(r.scanInterval)
+		case err := <-watchErrors:
+			logger.Error("driver-path-watch-error", err)
+
+		case done := <-r.syncRequests:
+			logger.Info("sync-requested")
+			if discovering {
+				syncingNext = append(syncingNext, done)
+			} else {
+				syncing = append(syncing, done)
+			}
+			startDiscovery()
+
+		case discovered := <-newDriverCh:
+			// a failed discovery found nothing, which is no reason to remove the drivers already registered
+			if discovered.err == nil {
+				r.setDrivers(logger, discovered.drivers, discovered.infos, discovered.collisions)
+			}
+			discovering = false
+
+			for _, done := range syncing {
+				done <- discovered.err
+			}
+			syncing, syncingNext = syncingNext, nil
+
+			if rediscover {
+				rediscover = false
+				startDiscovery()
+			} else {
+				timer.Reset(r.scanInterval)
+			}
 
 		case signal := <-signals:
 			logger.Info("received-signal", lager.Data{"signal": signal.String()})
@@ -106,42 +250,176 @@ func (r *driverSyncer) Run(signals <-chan os.Signal, ready chan<- struct{}) erro
 	}
 }
 
-func (r *driverSyncer) setDrivers(drivers map[string]voldriver.Driver) {
-	r.driverRegistry.Set(drivers)
+func (r *driverSyncer) watchDriverPaths(logger lager.Logger) (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, driverPath := range r.driverPaths {
+		if err := watcher.Add(driverPath); err != nil {
+			// the path is still polled, so a path that does not exist yet is not fatal
+			logger.Error("failed-watching-driver-path", err, lager.Data{"driverPath": driverPath})
+		}
+	}
+
+	return watcher, nil
+}
+
+func isDriverSpecChange(event fsnotify.Event) bool {
+	if event.Op&(fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 {
+		return false
+	}
+
+	switch filepath.Ext(event.Name) {
+	case ".sock", ".spec", ".json":
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *driverSyncer) setDrivers(logger lager.Logger, drivers map[string]voldriver.Driver, infos map[string]DriverInfo, collisions []volman.DriverCollision) {
+	r.driverRegistry.Replace(drivers, infos, collisions)
+
+	if r.metronClient != nil {
+		if err := r.metronClient.SendMetric(volmanDriverNameCollisions, int64(len(collisions))); err != nil {
+			logger.Error("failed-to-send-driver-name-collisions-metric", err)
+		}
+	}
+}
+
+func (r *driverSyncer) Discover(logger lager.Logger, ctx context.Context) (map[string]voldriver.Driver, error) {
+	drivers, _, _, err := r.discover(logger, ctx)
+	return drivers, err
 }
 
-func (r *driverSyncer) Discover(logger lager.Logger) (map[string]voldriver.Driver, error) {
+func (r *driverSyncer) Sync(logger lager.Logger, ctx context.Context) error {
+	logger = logger.Session("sync")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	done := make(chan error, 1)
+	select {
+	case r.syncRequests <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *driverSyncer) discover(logger lager.Logger, ctx context.Context) (map[string]voldriver.Driver, map[string]DriverInfo, []volman.DriverCollision, error) {
 	logger = logger.Session("discover")
 	logger.Debug("start")
 	logger.Info("discovering-drivers", lager.Data{"driver-paths": r.driverPaths})
 	defer logger.Debug("end")
 
+	var existing map[string]voldriver.Driver
+	var existingInfos map[string]DriverInfo
+	if r.driverRegistry != nil {
+		existing = r.driverRegistry.Drivers()
+		existingInfos = r.driverRegistry.Infos()
+	}
+
+	candidates, specNames, err := findCandidates(logger, r.driverPaths)
+	if err != nil {
+		return map[string]voldriver.Driver{}, nil, nil, err
+	}
+
+	collisions := orderCandidates(logger, r.precedence, specNames, candidates)
+
+	type activated struct {
+		driver    voldriver.Driver
+		info      DriverInfo
+		candidate driverCandidate
+	}
+	results := make([]*activated, len(specNames))
+
+	workers := make(chan struct{}, r.activationWorkers)
+	wg := sync.WaitGroup{}
+	for i, specName := range specNames {
+		workers <- struct{}{}
+		wg.Add(1)
+		go func(i int, specName string) {
+			defer wg.Done()
+			defer func() { <-workers }()
+
+			driver, info, candidate, ok := r.activateDriver(logger, ctx, specName, candidates[specName], existing, existingInfos)
+			if ok {
+				results[i] = &activated{driver, info, candidate}
+			}
+		}(i, specName)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return map[string]voldriver.Driver{}, nil, nil, volman.TimeoutError{Operation: "discover drivers", Cause: err.Error()}
+	}
+
 	endpoints := make(map[string]voldriver.Driver)
-	for _, driverPath := range r.driverPaths {
+	infos := make(map[string]DriverInfo)
+	driverCollisions := []volman.DriverCollision{}
+	for i, specName := range specNames {
+		if results[i] != nil {
+			endpoints[specName] = results[i].driver
+			infos[specName] = results[i].info
+		}
+
+		if collisions[i] != nil {
+			if results[i] != nil {
+				collisions[i].Chosen = results[i].candidate.path()
+			}
+			driverCollisions = append(driverCollisions, *collisions[i])
+		}
+	}
+	return endpoints, infos, driverCollisions, nil
+}
+
+// findCandidates lists the spec files on the driver paths by the driver they name.  Each driver's candidate specs are
+// kept in DriverPaths order and, within a path, sock before spec before json, ready for the precedence policy to
+// order.  The drivers are listed in the order their first spec file was found.
+func findCandidates(logger lager.Logger, driverPaths []string) (map[string][]driverCandidate, []string, error) {
+	candidates := map[string][]driverCandidate{}
+	var specNames []string
+	for _, driverPath := range driverPaths {
 		//precedence order: sock -> spec -> json
 		spec_types := [3]string{"sock", "spec", "json"}
 		for _, spec_type := range spec_types {
-			matchingDriverSpecs, err := r.getMatchingDriverSpecs(logger, driverPath, spec_type)
+			matchingDriverSpecs, err := getMatchingDriverSpecs(logger, driverPath, spec_type)
 
 			if err != nil {
 				// untestable on linux, does glob work differently on windows???
-				return map[string]voldriver.Driver{}, fmt.Errorf("Volman configured with an invalid driver path '%s', error occured list files (%s)", driverPath, err.Error())
+				return nil, nil, fmt.Errorf("Volman configured with an invalid driver path '%s', error occured list files (%s)", driverPath, err.Error())
 			}
 			if len(matchingDriverSpecs) > 0 {
 				logger.Debug("driver-specs", lager.Data{"drivers": matchingDriverSpecs})
-				var existing map[string]voldriver.Driver
-				if r.driverRegistry != nil {
-					existing = r.driverRegistry.Drivers()
+			}
+
+			for _, spec := range matchingDriverSpecs {
+				segs2 := driverSpecPattern.FindAllStringSubmatch(spec, 1)
+				if len(segs2) <= 0 {
+					continue
 				}
+				specName := segs2[0][2]
+				specFile := segs2[0][2] + "." + segs2[0][3]
 
-				endpoints = r.insertIfAliveAndNotFound(logger, endpoints, driverPath, matchingDriverSpecs, existing)
+				if _, ok := candidates[specName]; !ok {
+					specNames = append(specNames, specName)
+				}
+				candidates[specName] = append(candidates[specName], driverCandidate{driverPath: driverPath, specFile: specFile})
 			}
 		}
 	}
-	return endpoints, nil
+	return candidates, specNames, nil
 }
 
-func (r *driverSyncer) getMatchingDriverSpecs(logger lager.Logger, path string, pattern string) ([]string, error) {
+func getMatchingDriverSpecs(logger lager.Logger, path string, pattern string) ([]string, error) {
 	logger.Debug("binaries", lager.Data{"path": path, "pattern": pattern})
 	matchingDriverSpecs, err := filepath.Glob(path + "/*." + pattern)
 	if err != nil { // untestable on linux, does glob work differently on windows???
@@ -151,48 +429,109 @@ func (r *driverSyncer) getMatchingDriverSpecs(logger lager.Logger, path string,
 
 }
 
-func (r *driverSyncer) insertIfAliveAndNotFound(logger lager.Logger, endpoints map[string]voldriver.Driver, driverPath string, specs []string, existing map[string]voldriver.Driver) map[string]voldriver.Driver {
-	logger = logger.Session("insert-if-not-found")
+// activateDriver tries the driver's candidate specs in order, returning the first that activates as a volume driver
+func (r *driverSyncer) activateDriver(logger lager.Logger, ctx context.Context, specName string, candidates []driverCandidate, existing map[string]voldriver.Driver, existingInfos map[string]DriverInfo) (voldriver.Driver, DriverInfo, driverCandidate, bool) {
+	logger = logger.Session("activate-driver", lager.Data{"specname": specName})
 	logger.Debug("start")
 	defer logger.Debug("end")
 
-	for _, spec := range specs {
-		re := regexp.MustCompile("([^/]*/)?([^/]*)\\.(sock|spec|json)$")
+	for _, candidate := range candidates {
+		spec, err := r.driverFactory.Spec(logger, specName, candidate.driverPath, candidate.specFile)
+		if err != nil {
+			logger.Error("error-reading-driver-spec", err, lager.Data{"specFile": candidate.path()})
+			continue
+		}
 
-		segs2 := re.FindAllStringSubmatch(spec, 1)
-		if len(segs2) <= 0 {
+		driver, implements, err := activateCandidate(logger, ctx, r.driverFactory, r.driverCaller, specName, spec, existing)
+		if err != nil {
 			continue
 		}
-		specName := segs2[0][2]
-		specFile := segs2[0][2] + "." + segs2[0][3]
-		logger.Debug("insert-unique-spec", lager.Data{"specname": specName})
-		_, ok := endpoints[specName]
-		if ok == false {
-			driver, err := r.driverFactory.Driver(logger, specName, driverPath, specFile, existing)
-			if err != nil {
-				logger.Error("error-creating-driver", err)
-				continue
-			}
 
-			env := driverhttp.NewHttpDriverEnv(logger, context.TODO())
+		return driver, r.driverInfo(logger, ctx, specName, spec, driver, implements, existingInfos), candidate, true
+	}
 
-			resp := driver.Activate(env)
-			if resp.Err != "" {
-				logger.Info("skipping-non-responsive-driver", lager.Data{"specname": specName})
-			} else {
-				driverImplementsErr := fmt.Errorf("driver-implements: %#v", resp.Implements)
-				if len(resp.Implements) == 0 {
-					logger.Error("driver-incorrect", driverImplementsErr)
-					continue
-				}
+	return nil, DriverInfo{}, driverCandidate{}, false
+}
 
-				if !driverImplements("VolumeDriver", resp.Implements) {
-					logger.Error("driver-incorrect", driverImplementsErr)
-					continue
-				}
-				endpoints[specName] = driver
-			}
+// orderCandidates has the precedence policy order, or refuse, the spec files of drivers named by more than one,
+// leaving a refused driver with no candidates.  It returns each driver's collision, in the order of the drivers, with
+// nil for drivers named by a single spec file.
+func orderCandidates(logger lager.Logger, precedence DriverPrecedence, specNames []string, candidates map[string][]driverCandidate) []*volman.DriverCollision {
+	collisions := make([]*volman.DriverCollision, len(specNames))
+	for i, specName := range specNames {
+		if len(candidates[specName]) < 2 {
+			continue
+		}
+
+		collision := newDriverCollision(specName, candidates[specName])
+		ordered, err := precedence.order(specName, candidates[specName])
+		if err != nil {
+			logger.Error("driver-name-collision", err, lager.Data{"specname": specName, "specFiles": collision.SpecFiles})
+			collision.Error = err.Error()
+		} else {
+			collision.SpecFiles = candidateFiles(ordered)
+			logger.Info("driver-name-collision", lager.Data{"specname": specName, "specFiles": collision.SpecFiles, "policy": precedence.Policy})
+		}
+		candidates[specName] = ordered
+		collisions[i] = &collision
+	}
+	return collisions
+}
+
+// activateCandidate has the factory build the driver from the spec read from one of its spec files and activates it
+// with the caller, failing unless it activates as a volume driver
+func activateCandidate(logger lager.Logger, ctx context.Context, factory DriverFactory, caller driverCaller, specName string, spec DriverSpec, existing map[string]voldriver.Driver) (voldriver.Driver, []string, error) {
+	driver, err := factory.DriverFromSpec(logger, specName, spec, existing)
+	if err != nil {
+		logger.Error("error-creating-driver", err)
+		return nil, nil, err
+	}
+
+	resp, err := caller.activate(logger, ctx, specName, driver)
+	if _, ok := err.(volman.TimeoutError); ok {
+		logger.Error("driver-activation-timed-out", err, lager.Data{"specname": specName})
+		return nil, nil, err
+	}
+
+	if err != nil {
+		logger.Info("skipping-non-responsive-driver", lager.Data{"specname": specName})
+		return nil, nil, err
+	}
+
+	if !driverImplements("VolumeDriver", resp.Implements) {
+		err := fmt.Errorf("driver-implements: %#v", resp.Implements)
+		logger.Error("driver-incorrect", err)
+		return nil, nil, err
+	}
+
+	return driver, resp.Implements, nil
+}
+
+// driverInfo describes a driver that has just activated, keeping the time it was first discovered if the registry
+// already knows it at the same address
+func (r *driverSyncer) driverInfo(logger lager.Logger, ctx context.Context, driverId string, spec DriverSpec, driver voldriver.Driver, implements []string, existingInfos map[string]DriverInfo) DriverInfo {
+	now := r.clock.Now()
+	info := DriverInfo{
+		DriverSpec:    spec,
+		Implements:    implements,
+		DiscoveredAt:  now,
+		LastHealthyAt: now,
+	}
+
+	capabilities, err := r.driverCaller.capabilities(logger, ctx, driverId, driver)
+	if err != nil {
+		logger.Error("failed-fetching-driver-capabilities", err, lager.Data{"driverId": driverId})
+	} else {
+		// drivers that do not say otherwise are local scope
+		info.Scope = capabilities.Capabilities.Scope
+		if info.Scope == "" {
+			info.Scope = DriverScopeLocal
 		}
 	}
-	return endpoints
+
+	if existing, ok := existingInfos[driverId]; ok && existing.Address == info.Address {
+		info.DiscoveredAt = existing.DiscoveredAt
+	}
+
+	return info
 }
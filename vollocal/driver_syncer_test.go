@@ -1,17 +1,24 @@
 package vollocal_test
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
 	"code.cloudfoundry.org/clock/fakeclock"
+	mfakes "code.cloudfoundry.org/go-loggregator/loggregator_v2/fakes"
+	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/lager/lagertest"
 
 	"code.cloudfoundry.org/clock"
 	"code.cloudfoundry.org/voldriver"
 	"code.cloudfoundry.org/voldriver/voldriverfakes"
+	"code.cloudfoundry.org/volman"
 	"code.cloudfoundry.org/volman/vollocal"
 	"code.cloudfoundry.org/volman/volmanfakes"
 	"github.com/tedsuo/ifrit"
@@ -52,7 +59,7 @@ var _ = Describe("Driver Syncer", func() {
 			Implements: []string{"VolumeDriver"},
 		})
 
-		fakeDriverFactory.DriverReturns(fakeDriver, nil)
+		fakeDriverFactory.DriverFromSpecReturns(fakeDriver, nil)
 
 		driverName = "some-driver-name"
 	})
@@ -73,7 +80,7 @@ var _ = Describe("Driver Syncer", func() {
 			It("should have no drivers in registry map", func() {
 				drivers := registry.Drivers()
 				Expect(len(drivers)).To(Equal(0))
-				Expect(fakeDriverFactory.DriverCallCount()).To(Equal(0))
+				Expect(fakeDriverFactory.DriverFromSpecCallCount()).To(Equal(0))
 			})
 		})
 
@@ -96,7 +103,7 @@ var _ = Describe("Driver Syncer", func() {
 					Implements: []string{"VolumeDriver"},
 				})
 
-				fakeDriverFactory.DriverReturns(fakeDriver, nil)
+				fakeDriverFactory.DriverFromSpecReturns(fakeDriver, nil)
 
 				process = ginkgomon.Invoke(syncer.Runner())
 			})
@@ -108,10 +115,19 @@ var _ = Describe("Driver Syncer", func() {
 			It("should have fake driver in registry map", func() {
 				drivers := registry.Drivers()
 				Expect(len(drivers)).To(Equal(1))
-				Expect(fakeDriverFactory.DriverCallCount()).To(Equal(1))
+				Expect(fakeDriverFactory.DriverFromSpecCallCount()).To(Equal(1))
 				Expect(fakeDriver.ActivateCallCount()).To(Equal(1))
 			})
 
+			It("should read the driver's spec once", func() {
+				Expect(fakeDriverFactory.SpecCallCount()).To(Equal(1))
+			})
+
+			It("should cache the driver's scope, defaulting to local", func() {
+				Expect(fakeDriver.CapabilitiesCallCount()).To(Equal(1))
+				Expect(registry.Infos()[driverName].Scope).To(Equal(vollocal.DriverScopeLocal))
+			})
+
 			Context("when drivers are added", func() {
 				BeforeEach(func() {
 					err := voldriver.WriteDriverSpec(logger, defaultPluginsDirectory, "anotherfakedriver", "spec", []byte("http://0.0.0.0:8080"))
@@ -121,10 +137,49 @@ var _ = Describe("Driver Syncer", func() {
 				It("should find them!", func() {
 					fakeClock.Increment(scanInterval * 2)
 					Eventually(registry.Drivers).Should(HaveLen(2))
-					Expect(fakeDriverFactory.DriverCallCount()).To(Equal(3))
+					Expect(fakeDriverFactory.DriverFromSpecCallCount()).To(Equal(3))
 					Expect(fakeDriver.ActivateCallCount()).To(Equal(3))
 				})
+
+				It("should find them when synced, without waiting for the scan interval", func() {
+					Expect(syncer.Sync(logger, context.Background())).To(Succeed())
+					Expect(registry.Drivers()).To(HaveLen(2))
+				})
+			})
+			Context("when watching the driver paths", func() {
+				var debounce time.Duration
+
+				BeforeEach(func() {
+					ginkgomon.Kill(process)
+
+					debounce = time.Second
+					syncer = vollocal.NewDriverSyncerWithOptions(logger, registry, []string{defaultPluginsDirectory}, scanInterval, fakeClock, vollocal.DriverSyncerOptions{Factory: fakeDriverFactory, WatchDebounce: debounce})
+					process = ginkgomon.Invoke(syncer.Runner())
+				})
+
+				It("should find added drivers once the changes settle, without waiting for the scan interval", func() {
+					err := voldriver.WriteDriverSpec(logger, defaultPluginsDirectory, "anotherfakedriver", "spec", []byte("http://0.0.0.0:8080"))
+					Expect(err).NotTo(HaveOccurred())
+
+					// the scan timer and the debounce timer
+					fakeClock.WaitForNWatchersAndIncrement(debounce, 2)
+					Eventually(registry.Drivers).Should(HaveLen(2))
+				})
+
+				It("should forget removed drivers", func() {
+					Expect(os.Remove(filepath.Join(defaultPluginsDirectory, driverName+".spec"))).To(Succeed())
+
+					fakeClock.WaitForNWatchersAndIncrement(debounce, 2)
+					Eventually(registry.Drivers).Should(HaveLen(0))
+				})
+
+				It("should ignore files that are not driver specs", func() {
+					Expect(ioutil.WriteFile(filepath.Join(defaultPluginsDirectory, "README"), []byte("hello"), 0644)).To(Succeed())
+
+					Consistently(fakeClock.WatcherCount).Should(Equal(1))
+				})
 			})
+
 			Context("when drivers are not responding", func() {
 				BeforeEach(func() {
 					fakeDriver.ActivateReturns(voldriver.ActivateResponse{
@@ -144,7 +199,7 @@ var _ = Describe("Driver Syncer", func() {
 	Describe("#Discover", func() {
 		Context("when given driverspath with no drivers", func() {
 			It("no drivers are found", func() {
-				drivers, err := syncer.Discover(logger)
+				drivers, err := syncer.Discover(logger, context.Background())
 				Expect(err).ToNot(HaveOccurred())
 				Expect(len(drivers)).To(Equal(0))
 			})
@@ -158,17 +213,17 @@ var _ = Describe("Driver Syncer", func() {
 
 			It("should not find drivers that are unresponsive", func() {
 				fakeDriver.ActivateReturns(voldriver.ActivateResponse{Err: "Error"})
-				drivers, err := syncer.Discover(logger)
+				drivers, err := syncer.Discover(logger, context.Background())
 				Expect(err).ToNot(HaveOccurred())
 				Expect(len(drivers)).To(Equal(0))
-				Expect(fakeDriverFactory.DriverCallCount()).To(Equal(1))
+				Expect(fakeDriverFactory.DriverFromSpecCallCount()).To(Equal(1))
 			})
 
 			It("should find drivers", func() {
-				drivers, err := syncer.Discover(logger)
+				drivers, err := syncer.Discover(logger, context.Background())
 				Expect(err).ToNot(HaveOccurred())
 				Expect(len(drivers)).To(Equal(1))
-				Expect(fakeDriverFactory.DriverCallCount()).To(Equal(1))
+				Expect(fakeDriverFactory.DriverFromSpecCallCount()).To(Equal(1))
 			})
 		})
 
@@ -182,15 +237,62 @@ var _ = Describe("Driver Syncer", func() {
 				})
 
 				It("should preferentially select spec over json specification", func() {
-					drivers, err := syncer.Discover(logger)
+					drivers, err := syncer.Discover(logger, context.Background())
 					Expect(err).ToNot(HaveOccurred())
 					Expect(len(drivers)).To(Equal(1))
-					_, _, _, specFileName, _ := fakeDriverFactory.DriverArgsForCall(0)
+					_, _, _, specFileName := fakeDriverFactory.SpecArgsForCall(0)
 					Expect(specFileName).To(Equal(driverName + ".spec"))
 				})
 			})
 		})
 
+		Context("with several activation workers", func() {
+			var (
+				slowDriver *voldriverfakes.FakeDriver
+				release    chan struct{}
+			)
+
+			BeforeEach(func() {
+				syncer = vollocal.NewDriverSyncerWithOptions(logger, registry, []string{defaultPluginsDirectory}, scanInterval, fakeClock, vollocal.DriverSyncerOptions{Factory: fakeDriverFactory, ActivationWorkers: 2})
+
+				err := voldriver.WriteDriverSpec(logger, defaultPluginsDirectory, "a-slow-driver", "spec", []byte("http://0.0.0.0:8080"))
+				Expect(err).NotTo(HaveOccurred())
+				err = voldriver.WriteDriverSpec(logger, defaultPluginsDirectory, "b-fast-driver", "spec", []byte("http://0.0.0.0:9090"))
+				Expect(err).NotTo(HaveOccurred())
+
+				release = make(chan struct{})
+				slowDriver = new(voldriverfakes.FakeDriver)
+				slowDriver.ActivateStub = func(env voldriver.Env) voldriver.ActivateResponse {
+					<-release
+					return voldriver.ActivateResponse{Implements: []string{"VolumeDriver"}}
+				}
+
+				fakeDriverFactory.DriverFromSpecStub = func(logger lager.Logger, driverId string, spec vollocal.DriverSpec, existing map[string]voldriver.Driver) (voldriver.Driver, error) {
+					if driverId == "a-slow-driver" {
+						return slowDriver, nil
+					}
+					return fakeDriver, nil
+				}
+			})
+
+			It("should activate the other drivers while one is slow to respond", func() {
+				discovered := make(chan map[string]voldriver.Driver, 1)
+				go func() {
+					drivers, _ := syncer.Discover(logger, context.Background())
+					discovered <- drivers
+				}()
+
+				Eventually(slowDriver.ActivateCallCount).Should(Equal(1))
+				Eventually(fakeDriver.ActivateCallCount).Should(Equal(1))
+				Consistently(discovered).ShouldNot(Receive())
+
+				close(release)
+				var drivers map[string]voldriver.Driver
+				Eventually(discovered).Should(Receive(&drivers))
+				Expect(drivers).To(HaveLen(2))
+			})
+		})
+
 		Context("when given a compound driverspath", func() {
 			BeforeEach(func() {
 				syncer = vollocal.NewDriverSyncerWithDriverFactory(logger, registry, []string{defaultPluginsDirectory, secondPluginsDirectory}, scanInterval, fakeClock, fakeDriverFactory)
@@ -203,10 +305,10 @@ var _ = Describe("Driver Syncer", func() {
 				})
 
 				It("should find drivers", func() {
-					drivers, err := syncer.Discover(logger)
+					drivers, err := syncer.Discover(logger, context.Background())
 					Expect(err).ToNot(HaveOccurred())
 					Expect(len(drivers)).To(Equal(1))
-					Expect(fakeDriverFactory.DriverCallCount()).To(Equal(1))
+					Expect(fakeDriverFactory.DriverFromSpecCallCount()).To(Equal(1))
 				})
 
 			})
@@ -220,7 +322,7 @@ var _ = Describe("Driver Syncer", func() {
 				})
 
 				It("should find both drivers", func() {
-					drivers, err := syncer.Discover(logger)
+					drivers, err := syncer.Discover(logger, context.Background())
 					Expect(err).ToNot(HaveOccurred())
 					Expect(len(drivers)).To(Equal(2))
 				})
@@ -235,11 +337,69 @@ var _ = Describe("Driver Syncer", func() {
 				})
 
 				It("should preferentially select the driver in the first directory", func() {
-					_, err := syncer.Discover(logger)
+					_, err := syncer.Discover(logger, context.Background())
 					Expect(err).ToNot(HaveOccurred())
-					_, _, _, specFileName, _ := fakeDriverFactory.DriverArgsForCall(0)
+					_, _, _, specFileName := fakeDriverFactory.SpecArgsForCall(0)
 					Expect(specFileName).To(Equal(driverName + ".json"))
 				})
+
+				Context("when syncing", func() {
+					var fakeMetronClient *mfakes.FakeClient
+
+					BeforeEach(func() {
+						fakeMetronClient = new(mfakes.FakeClient)
+						syncer = vollocal.NewDriverSyncerWithOptions(logger, registry, []string{defaultPluginsDirectory, secondPluginsDirectory}, scanInterval, fakeClock, vollocal.DriverSyncerOptions{Factory: fakeDriverFactory, MetronClient: fakeMetronClient})
+					})
+
+					JustBeforeEach(func() {
+						process = ginkgomon.Invoke(syncer.Runner())
+					})
+
+					AfterEach(func() {
+						ginkgomon.Kill(process)
+					})
+
+					It("should record the collision and report it", func() {
+						Expect(registry.Collisions()).To(Equal([]volman.DriverCollision{{
+							Name:      driverName,
+							SpecFiles: []string{filepath.Join(defaultPluginsDirectory, driverName+".json"), filepath.Join(secondPluginsDirectory, driverName+".spec")},
+							Chosen:    filepath.Join(defaultPluginsDirectory, driverName+".json"),
+						}}))
+
+						Expect(fakeMetronClient.SendMetricCallCount()).To(Equal(1))
+						name, value := fakeMetronClient.SendMetricArgsForCall(0)
+						Expect(name).To(Equal("VolmanDriverNameCollisions"))
+						Expect(value).To(Equal(int64(1)))
+					})
+				})
+
+				Context("when preferring a format", func() {
+					BeforeEach(func() {
+						syncer = vollocal.NewDriverSyncerWithOptions(logger, registry, []string{defaultPluginsDirectory, secondPluginsDirectory}, scanInterval, fakeClock, vollocal.DriverSyncerOptions{Factory: fakeDriverFactory, Precedence: vollocal.DriverPrecedence{Policy: vollocal.PrecedencePreferFormat, Format: "spec"}})
+					})
+
+					It("should select the driver in that format wherever it is", func() {
+						drivers, err := syncer.Discover(logger, context.Background())
+						Expect(err).ToNot(HaveOccurred())
+						Expect(drivers).To(HaveLen(1))
+						_, _, driverPath, specFileName := fakeDriverFactory.SpecArgsForCall(0)
+						Expect(driverPath).To(Equal(secondPluginsDirectory))
+						Expect(specFileName).To(Equal(driverName + ".spec"))
+					})
+				})
+
+				Context("when conflicts are errors", func() {
+					BeforeEach(func() {
+						syncer = vollocal.NewDriverSyncerWithOptions(logger, registry, []string{defaultPluginsDirectory, secondPluginsDirectory}, scanInterval, fakeClock, vollocal.DriverSyncerOptions{Factory: fakeDriverFactory, Precedence: vollocal.DriverPrecedence{Policy: vollocal.PrecedenceErrorOnConflict}})
+					})
+
+					It("should not activate the driver at all", func() {
+						drivers, err := syncer.Discover(logger, context.Background())
+						Expect(err).ToNot(HaveOccurred())
+						Expect(drivers).To(BeEmpty())
+						Expect(fakeDriverFactory.DriverFromSpecCallCount()).To(Equal(0))
+					})
+				})
 			})
 		})
 
@@ -257,8 +417,8 @@ var _ = Describe("Driver Syncer", func() {
 				driverSyncer = vollocal.NewDriverSyncerWithDriverFactory(logger, nil, []string{defaultPluginsDirectory}, time.Second*60, clock.NewClock(), driverFactory)
 			})
 
-			TestCanonicalization := func(context, actual, it, expected string) {
-				Context(context, func() {
+			TestCanonicalization := func(description, actual, it, expected string) {
+				Context(description, func() {
 					BeforeEach(func() {
 						err := voldriver.WriteDriverSpec(logger, defaultPluginsDirectory, driverName, "spec", []byte(actual))
 						Expect(err).NotTo(HaveOccurred())
@@ -274,7 +434,7 @@ var _ = Describe("Driver Syncer", func() {
 					})
 
 					It(it, func() {
-						drivers, err := driverSyncer.Discover(logger)
+						drivers, err := driverSyncer.Discover(logger, context.Background())
 						Expect(err).ToNot(HaveOccurred())
 						Expect(len(drivers)).To(Equal(1))
 						Expect(fakeRemoteClientFactory.NewRemoteClientCallCount()).To(Equal(1))
@@ -297,7 +457,7 @@ var _ = Describe("Driver Syncer", func() {
 				})
 
 				It("doesn't make a driver", func() {
-					_, err := driverSyncer.Discover(logger)
+					_, err := driverSyncer.Discover(logger, context.Background())
 					Expect(err).NotTo(HaveOccurred())
 					Expect(fakeRemoteClientFactory.NewRemoteClientCallCount()).To(Equal(0))
 				})
@@ -315,7 +475,7 @@ var _ = Describe("Driver Syncer", func() {
 					Implements: []string{"something-else"},
 				})
 
-				drivers, err := syncer.Discover(logger)
+				drivers, err := syncer.Discover(logger, context.Background())
 				Expect(err).ToNot(HaveOccurred())
 				Expect(len(drivers)).To(Equal(0))
 			})
@@ -325,7 +485,7 @@ var _ = Describe("Driver Syncer", func() {
 					Err: "some-error",
 				})
 
-				drivers, err := syncer.Discover(logger)
+				drivers, err := syncer.Discover(logger, context.Background())
 				Expect(err).ToNot(HaveOccurred())
 				Expect(len(drivers)).To(Equal(0))
 			})
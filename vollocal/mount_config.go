@@ -0,0 +1,31 @@
+package vollocal
+
+import (
+	"sort"
+)
+
+// configConflict compares a requested config with the one the recorded volume was created with.  It returns the keys
+// that differ or were left out, in order, or nil when the configs match.  Records loaded from the mount table carry no key
+// hashes, so every requested key is reported as differing for them.
+func configConflict(record MountRecord, config map[string]interface{}) []string {
+	if record.ConfigHash == "" || record.ConfigHash == hashConfig(config) {
+		return nil
+	}
+
+	requestedHashes := hashConfigKeys(config)
+
+	var differing []string
+	for key, hash := range requestedHashes {
+		if record.ConfigKeyHashes[key] != hash {
+			differing = append(differing, key)
+		}
+	}
+	for key := range record.ConfigKeyHashes {
+		if _, ok := requestedHashes[key]; !ok {
+			differing = append(differing, key)
+		}
+	}
+
+	sort.Strings(differing)
+	return differing
+}
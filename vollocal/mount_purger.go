@@ -1,31 +1,137 @@
 package vollocal
 
 import (
-	"errors"
-	"os"
-
 	"context"
+	"os"
+	"sync"
+	"time"
 
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/go-loggregator/loggregator_v2"
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/voldriver"
-	"code.cloudfoundry.org/voldriver/driverhttp"
+	"code.cloudfoundry.org/volman"
 	"github.com/tedsuo/ifrit"
 )
 
+const (
+	volmanPurgedVolumesCounter = "VolmanPurgedVolumes"
+	volmanPurgeFailuresCounter = "VolmanPurgeFailures"
+)
+
+// PurgeConfig tunes how volman unmounts the volumes drivers have mounted that it has no record of
+type PurgeConfig struct {
+	// Workers is how many drivers are purged at once
+	Workers int
+
+	// Interval, when set, purges again periodically while volman runs so that orphaned mounts are cleaned up without
+	// waiting for a restart.  Only the purge at startup rolls back the mounts and unmounts a previous volman left in
+	// flight.
+	Interval time.Duration
+}
+
 type MountPurger interface {
 	Runner() ifrit.Runner
-	PurgeMounts(logger lager.Logger) error
+	PurgeMounts(logger lager.Logger, ctx context.Context) (PurgeReport, error)
+
+	// Purge unmounts the volumes drivers list that volman has no record of, as the periodic purge does.  A dry run
+	// only reports the volumes it would unmount.
+	Purge(logger lager.Logger, ctx context.Context, dryRun bool) (PurgeReport, error)
+}
+
+// PurgeReport describes, by driver, what a purge found and did
+type PurgeReport struct {
+	Drivers map[string]volman.DriverPurgeReport
+}
+
+func (r PurgeReport) unmounted() int {
+	unmounted := 0
+	for _, driver := range r.Drivers {
+		unmounted += len(driver.Unmounted)
+	}
+	return unmounted
+}
+
+func (r PurgeReport) failed() int {
+	failed := 0
+	for _, driver := range r.Drivers {
+		failed += len(driver.Failed)
+	}
+	return failed
+}
+
+func unmountFailed(report *volman.DriverPurgeReport, volumeId string, err error) {
+	if report.Failed == nil {
+		report.Failed = map[string]string{}
+	}
+	report.Failed[volumeId] = err.Error()
 }
 
 type mountPurger struct {
-	logger   lager.Logger
-	registry DriverRegistry
+	logger        lager.Logger
+	registry      DriverRegistry
+	mountRegistry MountRegistry
+	driverCaller  driverCaller
+	unmountQueue  UnmountQueue
+	locks         *VolumeLocks
+	config        PurgeConfig
+	metronClient  loggregator_v2.Client
+	clock         clock.Clock
 }
 
 func NewMountPurger(logger lager.Logger, registry DriverRegistry) MountPurger {
+	return NewMountPurgerWithOptions(logger, registry, MountPurgerOptions{})
+}
+
+// MountPurgerOptions are the collaborators and tuning of a mount purger beyond its driver registry.  Any left unset
+// fall back to a default: an in-memory mount registry, the default policies, no unmount queue, volume locks of its
+// own, one driver purged at a time only at startup, no metrics and the real clock.
+type MountPurgerOptions struct {
+	// MountRegistry holds the mounts the purger leaves alone, finishing or rolling back any that a previous volman
+	// left in flight
+	MountRegistry MountRegistry
+
+	// Policies time out and retry the purger's driver calls
+	Policies DriverPolicies
+
+	// UnmountQueue retries in the background the unmounts drivers fail
+	UnmountQueue UnmountQueue
+
+	// VolumeLocks keep periodic purges from racing the client's mounts of the volumes they find
+	VolumeLocks *VolumeLocks
+
+	Purge PurgeConfig
+
+	// MetronClient counts the volumes purged and failed to purge
+	MetronClient loggregator_v2.Client
+
+	Clock clock.Clock
+}
+
+func NewMountPurgerWithOptions(logger lager.Logger, registry DriverRegistry, options MountPurgerOptions) MountPurger {
+	if options.MountRegistry == nil {
+		options.MountRegistry = NewMountRegistry()
+	}
+	if options.Purge.Workers < 1 {
+		options.Purge.Workers = 1
+	}
+	if options.Clock == nil {
+		options.Clock = clock.NewClock()
+	}
+	if options.VolumeLocks == nil {
+		options.VolumeLocks = NewVolumeLocks()
+	}
+
 	return &mountPurger{
-		logger,
-		registry,
+		logger:        logger,
+		registry:      registry,
+		mountRegistry: options.MountRegistry,
+		driverCaller:  driverCaller{clock: options.Clock, policies: options.Policies},
+		unmountQueue:  options.UnmountQueue,
+		locks:         options.VolumeLocks,
+		config:        options.Purge,
+		metronClient:  options.MetronClient,
+		clock:         options.Clock,
 	}
 }
 
@@ -34,34 +140,293 @@ func (p *mountPurger) Runner() ifrit.Runner {
 }
 
 func (p *mountPurger) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	purgedCh := make(chan struct{}, 1)
+	go func() {
+		// failures are in the purge's report; they must not keep volman from starting
+		p.PurgeMounts(p.logger, ctx)
+		purgedCh <- struct{}{}
+	}()
 
-	if err := p.PurgeMounts(p.logger); err != nil {
-		return err
+	select {
+	case <-purgedCh:
+	case <-signals:
+		return nil
 	}
 
 	close(ready)
-	<-signals
-	return nil
+
+	if p.config.Interval <= 0 {
+		<-signals
+		return nil
+	}
+
+	logger := p.logger.Session("periodic-purge")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	timer := p.clock.NewTimer(p.config.Interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C():
+			go func() {
+				p.purge(logger, ctx, false, false)
+				purgedCh <- struct{}{}
+			}()
+
+		case <-purgedCh:
+			timer.Reset(p.config.Interval)
+
+		case signal := <-signals:
+			logger.Info("received-signal", lager.Data{"signal": signal.String()})
+			return nil
+		}
+	}
+}
+
+// PurgeMounts unmounts the volumes drivers list that volman has no record of, after rolling back the mounts and
+// unmounts a previous volman left in flight.  Driver calls that fail or time out are recorded in the report against
+// their driver and volume and the purge moves on; it only fails when ctx is done before it finishes, returning what it
+// managed to do with the error.
+func (p *mountPurger) PurgeMounts(logger lager.Logger, ctx context.Context) (PurgeReport, error) {
+	return p.purge(logger, ctx, true, false), ctx.Err()
 }
 
-func (p *mountPurger) PurgeMounts(logger lager.Logger) error {
-	logger = logger.Session("purge-mounts")
+func (p *mountPurger) Purge(logger lager.Logger, ctx context.Context, dryRun bool) (PurgeReport, error) {
+	return p.purge(logger, ctx, false, dryRun), ctx.Err()
+}
+
+func (p *mountPurger) purge(logger lager.Logger, ctx context.Context, replay bool, dryRun bool) PurgeReport {
+	logger = logger.Session("purge-mounts", lager.Data{"dryRun": dryRun})
 	logger.Info("start")
 	defer logger.Info("end")
 
 	drivers := p.registry.Drivers()
+	infos := p.registry.Infos()
+
+	reports := map[string]*volman.DriverPurgeReport{}
+	for driverId := range drivers {
+		reports[driverId] = &volman.DriverPurgeReport{Listed: []string{}, Unmounted: []string{}}
+	}
+
+	accounted := map[mountKey]bool{}
+	if replay {
+		accounted = p.replayMountRegistry(logger, ctx, drivers, reports)
+	}
+
+	workers := make(chan struct{}, p.config.Workers)
+	wg := sync.WaitGroup{}
+	for driverId, driver := range drivers {
+		switch scope := infos[driverId].Scope; scope {
+		case DriverScopeLocal:
+		case DriverScopeGlobal:
+			// the volumes of global scope drivers are shared with other cells, so those volman has no record of may
+			// well be in use elsewhere
+			logger.Info("skipping-purge-of-global-scope-driver", lager.Data{"driverId": driverId})
+			reports[driverId].Skipped = true
+			continue
+		default:
+			// a driver whose capabilities could not be fetched, or that reports a scope volman does not know, may be
+			// sharing its volumes just the same
+			logger.Info("skipping-purge-of-driver-of-unknown-scope", lager.Data{"driverId": driverId, "scope": scope})
+			reports[driverId].Skipped = true
+			continue
+		}
+
+		workers <- struct{}{}
+		wg.Add(1)
+		go func(driverId string, driver voldriver.Driver, report *volman.DriverPurgeReport) {
+			defer wg.Done()
+			defer func() { <-workers }()
+
+			p.purgeDriver(logger, ctx, driverId, driver, accounted, dryRun, report)
+		}(driverId, driver, reports[driverId])
+	}
+	wg.Wait()
 
-	for _, driver := range drivers {
-		env := driverhttp.NewHttpDriverEnv(logger, context.TODO())
-		listResponse := driver.List(env)
-		for _, mount := range listResponse.Volumes {
-			env = driverhttp.NewHttpDriverEnv(logger, context.TODO())
-			errorResponse := driver.Unmount(env, voldriver.UnmountRequest{Name: mount.Name})
-			if errorResponse.Err != "" {
-				logger.Error("failed-purging-volume-mount", errors.New(errorResponse.Err))
+	return p.report(logger, reports, dryRun)
+}
+
+// purgeDriver unmounts the volumes the driver lists that volman has no record of.  Each volume is locked and its
+// record checked again before it is unmounted, so that a mount made since the records were read is left alone.
+func (p *mountPurger) purgeDriver(logger lager.Logger, ctx context.Context, driverId string, driver voldriver.Driver, accounted map[mountKey]bool, dryRun bool, report *volman.DriverPurgeReport) {
+	listResponse, err := p.driverCaller.list(logger, ctx, driverId, driver)
+	if err != nil {
+		logger.Error("failed-listing-volumes", err, lager.Data{"driverId": driverId})
+		report.ListError = err.Error()
+		return
+	}
+
+	for _, mount := range listResponse.Volumes {
+		report.Listed = append(report.Listed, mount.Name)
+		p.purgeVolume(logger, ctx, driverId, driver, mount.Name, accounted, dryRun, report)
+	}
+}
+
+func (p *mountPurger) purgeVolume(logger lager.Logger, ctx context.Context, driverId string, driver voldriver.Driver, volumeId string, accounted map[mountKey]bool, dryRun bool, report *volman.DriverPurgeReport) {
+	data := lager.Data{"driverId": driverId, "volumeId": volumeId}
+	if accounted[mountKey{driverId, volumeId}] {
+		logger.Info("keeping-recorded-volume-mount", data)
+		return
+	}
+
+	if dryRun {
+		if _, recorded := p.mountRegistry.Record(driverId, volumeId); !recorded {
+			logger.Info("would-purge-volume-mount", data)
+			report.Unmounted = append(report.Unmounted, volumeId)
+		}
+		return
+	}
+
+	unlock, err := p.locks.lock(ctx, driverId, volumeId)
+	if err != nil {
+		err = volman.TimeoutError{DriverId: driverId, Operation: "unmount", Cause: "waiting for another operation on volume '" + volumeId + "': " + err.Error()}
+		logger.Error("failed-locking-volume", err, data)
+		unmountFailed(report, volumeId, err)
+		return
+	}
+	defer unlock()
+
+	if _, recorded := p.mountRegistry.Record(driverId, volumeId); recorded {
+		logger.Info("keeping-recorded-volume-mount", data)
+		return
+	}
+
+	err = p.driverCaller.unmount(logger, ctx, driverId, driver, voldriver.UnmountRequest{Name: volumeId})
+	if err != nil {
+		logger.Error("failed-purging-volume-mount", err, data)
+		unmountFailed(report, volumeId, err)
+		p.queueUnmount(logger, driverId, volumeId, err)
+		return
+	}
+
+	report.Unmounted = append(report.Unmounted, volumeId)
+	p.dequeueUnmount(logger, driverId, volumeId)
+}
+
+// report gathers the drivers' reports, logs them and counts the volumes purged and the failures
+func (p *mountPurger) report(logger lager.Logger, reports map[string]*volman.DriverPurgeReport, dryRun bool) PurgeReport {
+	report := PurgeReport{Drivers: map[string]volman.DriverPurgeReport{}}
+	for driverId, driverReport := range reports {
+		report.Drivers[driverId] = *driverReport
+	}
+
+	logger.Info("purge-report", lager.Data{"report": report})
+
+	if p.metronClient != nil && !dryRun {
+		for i := 0; i < report.unmounted(); i++ {
+			p.metronClient.IncrementCounter(volmanPurgedVolumesCounter)
+		}
+		for i := 0; i < report.failed(); i++ {
+			p.metronClient.IncrementCounter(volmanPurgeFailuresCounter)
+		}
+	}
+
+	return report
+}
+
+// replayMountRegistry rolls back mounts and finishes unmounts that were in flight when volman last stopped, and drops
+// records of mounts the driver no longer knows about.  It returns the mounts it has accounted for, which the purge
+// must then leave alone.  Failed and timed out driver calls are recorded in the drivers' reports and leave the mounts
+// they were about accounted for.
+func (p *mountPurger) replayMountRegistry(logger lager.Logger, ctx context.Context, drivers map[string]voldriver.Driver, reports map[string]*volman.DriverPurgeReport) map[mountKey]bool {
+	logger = logger.Session("replay-mount-registry")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	accounted := map[mountKey]bool{}
+	listed := map[string]map[string]bool{}
+	listErrs := map[string]error{}
+
+	for _, record := range p.mountRegistry.Mounts() {
+		key := mountKey{record.DriverId, record.VolumeId}
+		data := lager.Data{"driverId": record.DriverId, "volumeId": record.VolumeId, "state": record.State}
+
+		driver, ok := drivers[record.DriverId]
+		if !ok {
+			// the driver may just be slow to come back; keep the record so its mounts are not lost
+			logger.Info("driver-not-found-for-recorded-mount", data)
+			continue
+		}
+
+		switch record.State {
+		case MountStateMounted:
+			volumes, ok := listed[record.DriverId]
+			if !ok {
+				var err error
+				volumes, err = p.listVolumes(logger, ctx, record.DriverId, driver)
+				listed[record.DriverId], listErrs[record.DriverId] = volumes, err
+				if err != nil {
+					reports[record.DriverId].ListError = err.Error()
+				}
+			}
+
+			if listErrs[record.DriverId] != nil {
+				logger.Error("failed-listing-volumes-keeping-record", listErrs[record.DriverId], data)
+				accounted[key] = true
+				continue
 			}
+
+			if !volumes[record.VolumeId] {
+				logger.Info("dropping-record-of-volume-no-longer-mounted", data)
+				p.removeRecord(logger, record)
+				continue
+			}
+
+			accounted[key] = true
+
+		case MountStateMounting, MountStateUnmounting:
+			logger.Info("unmounting-volume-left-in-flight", data)
+			accounted[key] = true
+
+			report := reports[record.DriverId]
+			err := p.driverCaller.unmount(logger, ctx, record.DriverId, driver, voldriver.UnmountRequest{Name: record.VolumeId})
+			if err != nil {
+				logger.Error("failed-unmounting-volume-left-in-flight", err, data)
+				unmountFailed(report, record.VolumeId, err)
+				p.queueUnmount(logger, record.DriverId, record.VolumeId, err)
+				continue
+			}
+
+			report.Unmounted = append(report.Unmounted, record.VolumeId)
+			p.removeRecord(logger, record)
 		}
 	}
 
-	return nil
+	return accounted
+}
+
+func (p *mountPurger) queueUnmount(logger lager.Logger, driverId string, volumeId string, cause error) {
+	if p.unmountQueue != nil {
+		p.unmountQueue.Add(logger, driverId, volumeId, cause)
+	}
+}
+
+func (p *mountPurger) dequeueUnmount(logger lager.Logger, driverId string, volumeId string) {
+	if p.unmountQueue != nil {
+		p.unmountQueue.Remove(logger, driverId, volumeId)
+	}
+}
+
+func (p *mountPurger) removeRecord(logger lager.Logger, record MountRecord) {
+	if err := p.mountRegistry.Remove(record.DriverId, record.VolumeId); err != nil {
+		logger.Error("failed-removing-mount-record", err, lager.Data{"driverId": record.DriverId, "volumeId": record.VolumeId})
+	}
+}
+
+func (p *mountPurger) listVolumes(logger lager.Logger, ctx context.Context, driverId string, driver voldriver.Driver) (map[string]bool, error) {
+	listResponse, err := p.driverCaller.list(logger, ctx, driverId, driver)
+	if err != nil {
+		return nil, err
+	}
+
+	volumes := map[string]bool{}
+	for _, volume := range listResponse.Volumes {
+		volumes[volume.Name] = true
+	}
+	return volumes, nil
 }
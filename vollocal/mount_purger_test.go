@@ -3,13 +3,16 @@ package vollocal_test
 import (
 	"code.cloudfoundry.org/volman/vollocal"
 
+	"context"
 	"time"
 
 	"code.cloudfoundry.org/clock"
 	"code.cloudfoundry.org/clock/fakeclock"
+	mfakes "code.cloudfoundry.org/go-loggregator/loggregator_v2/fakes"
 	"code.cloudfoundry.org/lager/lagertest"
 	"code.cloudfoundry.org/voldriver"
 	"code.cloudfoundry.org/voldriver/voldriverfakes"
+	"code.cloudfoundry.org/volman"
 	"code.cloudfoundry.org/volman/volmanfakes"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -47,7 +50,7 @@ var _ = Describe("MountPurger", func() {
 	})
 
 	It("should succeed when there are no drivers", func() {
-		err := purger.PurgeMounts(logger)
+		_, err := purger.PurgeMounts(logger, context.Background())
 		Expect(err).NotTo(HaveOccurred())
 	})
 
@@ -69,7 +72,7 @@ var _ = Describe("MountPurger", func() {
 			client = vollocal.NewLocalClient(logger, driverRegistry, nil, fakeClock)
 
 			fakeDriver = new(voldriverfakes.FakeDriver)
-			fakeDriverFactory.DriverReturns(fakeDriver, nil)
+			fakeDriverFactory.DriverFromSpecReturns(fakeDriver, nil)
 
 			fakeDriver.ActivateReturns(voldriver.ActivateResponse{Implements: []string{"VolumeDriver"}})
 
@@ -81,7 +84,7 @@ var _ = Describe("MountPurger", func() {
 		})
 
 		It("should succeed when there are no mounts", func() {
-			err := purger.PurgeMounts(logger)
+			_, err := purger.PurgeMounts(logger, context.Background())
 			Expect(err).NotTo(HaveOccurred())
 		})
 
@@ -96,24 +99,424 @@ var _ = Describe("MountPurger", func() {
 			})
 
 			It("should unmount the volume", func() {
-				err := purger.PurgeMounts(logger)
+				_, err := purger.PurgeMounts(logger, context.Background())
 				Expect(err).NotTo(HaveOccurred())
 
 				Expect(fakeDriver.UnmountCallCount()).To(Equal(1))
 			})
 
+			It("should report the volume as listed and unmounted", func() {
+				report, err := purger.PurgeMounts(logger, context.Background())
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(report.Drivers).To(Equal(map[string]volman.DriverPurgeReport{
+					"fakedriver": {Listed: []string{"a-volume"}, Unmounted: []string{"a-volume"}},
+				}))
+			})
+
+			It("should only report the volume it would unmount on a dry run", func() {
+				report, err := purger.Purge(logger, context.Background(), true)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeDriver.UnmountCallCount()).To(Equal(0))
+				Expect(report.Drivers).To(Equal(map[string]volman.DriverPurgeReport{
+					"fakedriver": {Listed: []string{"a-volume"}, Unmounted: []string{"a-volume"}},
+				}))
+			})
+
+			Context("when given a metron client", func() {
+				var fakeMetronClient *mfakes.FakeClient
+
+				BeforeEach(func() {
+					fakeMetronClient = new(mfakes.FakeClient)
+					purger = vollocal.NewMountPurgerWithOptions(logger, driverRegistry, vollocal.MountPurgerOptions{MetronClient: fakeMetronClient, Clock: fakeClock})
+				})
+
+				It("should count the purged volumes", func() {
+					_, err := purger.PurgeMounts(logger, context.Background())
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(fakeMetronClient.IncrementCounterCallCount()).To(Equal(1))
+					Expect(fakeMetronClient.IncrementCounterArgsForCall(0)).To(Equal("VolmanPurgedVolumes"))
+				})
+			})
+
+			Context("when the driver is global scope", func() {
+				BeforeEach(func() {
+					driverRegistry.SetInfos(map[string]vollocal.DriverInfo{"fakedriver": {Scope: vollocal.DriverScopeGlobal}})
+				})
+
+				It("should leave the volume mounted", func() {
+					_, err := purger.PurgeMounts(logger, context.Background())
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(fakeDriver.ListCallCount()).To(Equal(0))
+					Expect(fakeDriver.UnmountCallCount()).To(Equal(0))
+				})
+
+				It("should report the driver as skipped", func() {
+					report, err := purger.PurgeMounts(logger, context.Background())
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(report.Drivers["fakedriver"].Skipped).To(BeTrue())
+				})
+			})
+
 			Context("when the unmount fails", func() {
 				BeforeEach(func() {
 					fakeDriver.UnmountReturns(voldriver.ErrorResponse{Err: "badness"})
 				})
 
 				It("should log but not fail", func() {
-					err := purger.PurgeMounts(logger)
+					_, err := purger.PurgeMounts(logger, context.Background())
 					Expect(err).NotTo(HaveOccurred())
 
 					Expect(logger.TestSink.LogMessages()).To(ContainElement("mount-purger.purge-mounts.failed-purging-volume-mount"))
 				})
+
+				It("should report the failure", func() {
+					report, err := purger.PurgeMounts(logger, context.Background())
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(report.Drivers["fakedriver"].Unmounted).To(BeEmpty())
+					Expect(report.Drivers["fakedriver"].Failed).To(HaveKeyWithValue("a-volume", ContainSubstring("badness")))
+				})
+
+				Context("when there is an unmount queue", func() {
+					var unmountQueue vollocal.UnmountQueue
+
+					BeforeEach(func() {
+						var err error
+						unmountQueue, err = vollocal.NewUnmountQueue(logger, driverRegistry, vollocal.UnmountQueueOptions{Clock: fakeClock})
+						Expect(err).NotTo(HaveOccurred())
+
+						purger = vollocal.NewMountPurgerWithOptions(logger, driverRegistry, vollocal.MountPurgerOptions{UnmountQueue: unmountQueue, Clock: fakeClock})
+					})
+
+					It("should queue the unmount to be retried", func() {
+						_, err := purger.PurgeMounts(logger, context.Background())
+						Expect(err).NotTo(HaveOccurred())
+
+						Expect(unmountQueue.Pending()).To(HaveLen(1))
+						Expect(unmountQueue.Pending()[0].VolumeId).To(Equal("a-volume"))
+					})
+				})
 			})
 		})
+
+		Context("when there is a mount registry", func() {
+			var mountRegistry vollocal.MountRegistry
+
+			BeforeEach(func() {
+				mountRegistry = vollocal.NewMountRegistry()
+				purger = vollocal.NewMountPurgerWithOptions(logger, driverRegistry, vollocal.MountPurgerOptions{MountRegistry: mountRegistry})
+
+				fakeDriver.ListReturns(voldriver.ListResponse{Volumes: []voldriver.VolumeInfo{
+					{Name: "recorded-volume", Mountpoint: "foo"},
+					{Name: "unknown-volume", Mountpoint: "bar"},
+				}})
+
+				Expect(mountRegistry.BeginMount("fakedriver", "recorded-volume", "some-container", "hash", nil)).To(Succeed())
+				Expect(mountRegistry.CompleteMount("fakedriver", "recorded-volume", "foo", fakeClock.Now())).To(Succeed())
+			})
+
+			It("should only unmount the volumes it has no record of", func() {
+				_, err := purger.PurgeMounts(logger, context.Background())
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeDriver.UnmountCallCount()).To(Equal(1))
+				_, unmountRequest := fakeDriver.UnmountArgsForCall(0)
+				Expect(unmountRequest.Name).To(Equal("unknown-volume"))
+				Expect(mountRegistry.Mounts()).To(HaveLen(1))
+			})
+
+			Context("when a recorded volume is no longer listed by the driver", func() {
+				BeforeEach(func() {
+					fakeDriver.ListReturns(voldriver.ListResponse{})
+				})
+
+				It("should drop the record", func() {
+					_, err := purger.PurgeMounts(logger, context.Background())
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(mountRegistry.Mounts()).To(BeEmpty())
+				})
+			})
+
+			Context("when the driver fails to list its volumes", func() {
+				BeforeEach(func() {
+					fakeDriver.ListReturns(voldriver.ListResponse{Err: "badness"})
+				})
+
+				It("should keep the record", func() {
+					_, err := purger.PurgeMounts(logger, context.Background())
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(mountRegistry.Mounts()).To(HaveLen(1))
+				})
+			})
+
+			Context("when a mount was left in flight", func() {
+				BeforeEach(func() {
+					Expect(mountRegistry.BeginMount("fakedriver", "unknown-volume", "some-container", "hash", nil)).To(Succeed())
+				})
+
+				It("should roll it back", func() {
+					_, err := purger.PurgeMounts(logger, context.Background())
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(fakeDriver.UnmountCallCount()).To(Equal(1))
+					_, unmountRequest := fakeDriver.UnmountArgsForCall(0)
+					Expect(unmountRequest.Name).To(Equal("unknown-volume"))
+					Expect(mountRegistry.Mounts()).To(HaveLen(1))
+					Expect(mountRegistry.Mounts()[0].VolumeId).To(Equal("recorded-volume"))
+				})
+			})
+
+			Context("when an unmount was left in flight", func() {
+				BeforeEach(func() {
+					_, _, err := mountRegistry.Release("fakedriver", "recorded-volume", "some-container")
+					Expect(err).NotTo(HaveOccurred())
+				})
+
+				It("should finish it", func() {
+					_, err := purger.PurgeMounts(logger, context.Background())
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(fakeDriver.UnmountCallCount()).To(Equal(2))
+					Expect(mountRegistry.Mounts()).To(BeEmpty())
+				})
+			})
+		})
+	})
+
+	Context("when purging several drivers", func() {
+		var (
+			fakeDriver      *voldriverfakes.FakeDriver
+			otherFakeDriver *voldriverfakes.FakeDriver
+			listing         chan struct{}
+			release         chan struct{}
+		)
+
+		BeforeEach(func() {
+			listing = make(chan struct{}, 2)
+			release = make(chan struct{})
+			listStub := func(voldriver.Env) voldriver.ListResponse {
+				listing <- struct{}{}
+				<-release
+				return voldriver.ListResponse{}
+			}
+
+			fakeDriver = new(voldriverfakes.FakeDriver)
+			fakeDriver.ListStub = listStub
+			otherFakeDriver = new(voldriverfakes.FakeDriver)
+			otherFakeDriver.ListStub = listStub
+
+			driverRegistry = vollocal.NewDriverRegistryWith(map[string]voldriver.Driver{"fakedriver": fakeDriver, "otherdriver": otherFakeDriver})
+			driverRegistry.SetInfos(map[string]vollocal.DriverInfo{"fakedriver": {Scope: vollocal.DriverScopeLocal}, "otherdriver": {Scope: vollocal.DriverScopeLocal}})
+			purger = vollocal.NewMountPurgerWithOptions(logger, driverRegistry, vollocal.MountPurgerOptions{Purge: vollocal.PurgeConfig{Workers: 2}, Clock: fakeclock.NewFakeClock(time.Unix(123, 456))})
+		})
+
+		It("should purge them in parallel", func() {
+			purged := make(chan error, 1)
+			go func() {
+				_, err := purger.PurgeMounts(logger, context.Background())
+				purged <- err
+			}()
+
+			Eventually(listing).Should(Receive())
+			Eventually(listing).Should(Receive())
+			close(release)
+
+			Eventually(purged).Should(Receive(BeNil()))
+		})
+	})
+
+	Context("when a driver's capabilities can not be fetched", func() {
+		BeforeEach(func() {
+			err := voldriver.WriteDriverSpec(logger, defaultPluginsDirectory, "fakedriver", "spec", []byte("http://0.0.0.0:8080"))
+			Expect(err).NotTo(HaveOccurred())
+
+			fakeDriver = new(voldriverfakes.FakeDriver)
+			fakeDriver.ActivateReturns(voldriver.ActivateResponse{Implements: []string{"VolumeDriver"}})
+			fakeDriver.CapabilitiesStub = func(env voldriver.Env) voldriver.CapabilitiesResponse {
+				<-env.Context().Done()
+				return voldriver.CapabilitiesResponse{}
+			}
+			fakeDriver.ListReturns(voldriver.ListResponse{Volumes: []voldriver.VolumeInfo{{Name: "a-volume", Mountpoint: "foo"}}})
+
+			fakeDriverFactory = new(volmanfakes.FakeDriverFactory)
+			fakeDriverFactory.DriverFromSpecReturns(fakeDriver, nil)
+
+			policies := vollocal.DriverPolicies{Default: vollocal.DriverPolicy{ActivateTimeout: 10 * time.Millisecond}}
+			driverSyncer = vollocal.NewDriverSyncerWithOptions(logger, driverRegistry, []string{defaultPluginsDirectory}, time.Minute, clock.NewClock(), vollocal.DriverSyncerOptions{Factory: fakeDriverFactory, Policies: policies})
+			process = ginkgomon.Invoke(driverSyncer.Runner())
+		})
+
+		AfterEach(func() {
+			ginkgomon.Kill(process)
+		})
+
+		It("should leave the driver's volumes mounted and report it as skipped", func() {
+			Expect(driverRegistry.Infos()["fakedriver"].Scope).To(BeEmpty())
+
+			report, err := purger.PurgeMounts(logger, context.Background())
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeDriver.ListCallCount()).To(Equal(0))
+			Expect(fakeDriver.UnmountCallCount()).To(Equal(0))
+			Expect(report.Drivers["fakedriver"].Skipped).To(BeTrue())
+		})
+	})
+
+	Context("when a driver call times out", func() {
+		var (
+			fakeDriver    *voldriverfakes.FakeDriver
+			mountRegistry vollocal.MountRegistry
+		)
+
+		BeforeEach(func() {
+			fakeDriver = new(voldriverfakes.FakeDriver)
+			fakeDriver.ListReturns(voldriver.ListResponse{Volumes: []voldriver.VolumeInfo{
+				{Name: "hung-volume", Mountpoint: "foo"},
+				{Name: "other-volume", Mountpoint: "bar"},
+			}})
+			fakeDriver.UnmountStub = func(env voldriver.Env, request voldriver.UnmountRequest) voldriver.ErrorResponse {
+				if request.Name == "hung-volume" {
+					<-env.Context().Done()
+				}
+				return voldriver.ErrorResponse{}
+			}
+
+			driverRegistry = vollocal.NewDriverRegistryWith(map[string]voldriver.Driver{"fakedriver": fakeDriver})
+			driverRegistry.SetInfos(map[string]vollocal.DriverInfo{"fakedriver": {Scope: vollocal.DriverScopeLocal}})
+			mountRegistry = vollocal.NewMountRegistry()
+
+			policies := vollocal.DriverPolicies{Default: vollocal.DriverPolicy{UnmountTimeout: 10 * time.Millisecond}}
+			purger = vollocal.NewMountPurgerWithOptions(logger, driverRegistry, vollocal.MountPurgerOptions{MountRegistry: mountRegistry, Policies: policies, Clock: clock.NewClock()})
+		})
+
+		It("should report the timeout and go on to the other volumes", func() {
+			report, err := purger.PurgeMounts(logger, context.Background())
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(report.Drivers["fakedriver"].Failed).To(HaveKeyWithValue("hung-volume", ContainSubstring("timed out")))
+			Expect(report.Drivers["fakedriver"].Unmounted).To(Equal([]string{"other-volume"}))
+		})
+
+		Context("when mounts were left in flight", func() {
+			BeforeEach(func() {
+				Expect(mountRegistry.BeginMount("fakedriver", "hung-volume", "some-container", "hash", nil)).To(Succeed())
+				Expect(mountRegistry.BeginMount("fakedriver", "other-volume", "some-container", "hash", nil)).To(Succeed())
+			})
+
+			It("should roll back the others", func() {
+				report, err := purger.PurgeMounts(logger, context.Background())
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(report.Drivers["fakedriver"].Failed).To(HaveKey("hung-volume"))
+				Expect(report.Drivers["fakedriver"].Unmounted).To(Equal([]string{"other-volume"}))
+				Expect(mountRegistry.Mounts()).To(HaveLen(1))
+				Expect(mountRegistry.Mounts()[0].VolumeId).To(Equal("hung-volume"))
+			})
+		})
+
+		It("should still become ready", func() {
+			process = ginkgomon.Invoke(purger.Runner())
+			defer ginkgomon.Kill(process)
+
+			Expect(fakeDriver.UnmountCallCount()).To(Equal(2))
+		})
+	})
+
+	Context("when sharing volume locks with the client", func() {
+		var (
+			fakeDriver    *voldriverfakes.FakeDriver
+			mountRegistry vollocal.MountRegistry
+			client        volman.Manager
+			created       chan struct{}
+		)
+
+		BeforeEach(func() {
+			fakeDriver = new(voldriverfakes.FakeDriver)
+			driverRegistry = vollocal.NewDriverRegistryWith(map[string]voldriver.Driver{"fakedriver": fakeDriver})
+			driverRegistry.SetInfos(map[string]vollocal.DriverInfo{"fakedriver": {Scope: vollocal.DriverScopeLocal}})
+			mountRegistry = vollocal.NewMountRegistry()
+			locks := vollocal.NewVolumeLocks()
+
+			created = make(chan struct{})
+			fakeDriver.CreateStub = func(env voldriver.Env, createRequest voldriver.CreateRequest) voldriver.ErrorResponse {
+				<-created
+				return voldriver.ErrorResponse{}
+			}
+			fakeDriver.MountReturns(voldriver.MountResponse{Mountpoint: "/var/vcap/data/mounts/busy-volume"})
+			fakeDriver.ListReturns(voldriver.ListResponse{Volumes: []voldriver.VolumeInfo{{Name: "busy-volume", Mountpoint: "/var/vcap/data/mounts/busy-volume"}}})
+
+			client = vollocal.NewLocalClientWithOptions(logger, driverRegistry, vollocal.LocalClientOptions{MountRegistry: mountRegistry, VolumeLocks: locks})
+			purger = vollocal.NewMountPurgerWithOptions(logger, driverRegistry, vollocal.MountPurgerOptions{MountRegistry: mountRegistry, VolumeLocks: locks})
+		})
+
+		It("waits for a mount in flight and then leaves the volume alone", func() {
+			mounted := make(chan error, 1)
+			go func() {
+				_, err := client.Mount(logger, context.Background(), "fakedriver", "busy-volume", "some-container", map[string]interface{}{})
+				mounted <- err
+			}()
+			Eventually(fakeDriver.CreateCallCount).Should(Equal(1))
+
+			purged := make(chan vollocal.PurgeReport, 1)
+			go func() {
+				report, _ := purger.Purge(logger, context.Background(), false)
+				purged <- report
+			}()
+			Eventually(fakeDriver.ListCallCount).Should(Equal(1))
+			Consistently(purged).ShouldNot(Receive())
+
+			close(created)
+			Eventually(mounted).Should(Receive(BeNil()))
+			Eventually(purged).Should(Receive())
+			Expect(fakeDriver.UnmountCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("when purging periodically", func() {
+		var (
+			fakeDriver    *voldriverfakes.FakeDriver
+			fakeClock     *fakeclock.FakeClock
+			mountRegistry vollocal.MountRegistry
+		)
+
+		BeforeEach(func() {
+			fakeDriver = new(voldriverfakes.FakeDriver)
+			fakeClock = fakeclock.NewFakeClock(time.Unix(123, 456))
+			driverRegistry = vollocal.NewDriverRegistryWith(map[string]voldriver.Driver{"fakedriver": fakeDriver})
+			driverRegistry.SetInfos(map[string]vollocal.DriverInfo{"fakedriver": {Scope: vollocal.DriverScopeLocal}})
+			mountRegistry = vollocal.NewMountRegistry()
+
+			purger = vollocal.NewMountPurgerWithOptions(logger, driverRegistry, vollocal.MountPurgerOptions{MountRegistry: mountRegistry, Purge: vollocal.PurgeConfig{Interval: time.Minute}, Clock: fakeClock})
+		})
+
+		JustBeforeEach(func() {
+			process = ginkgomon.Invoke(purger.Runner())
+		})
+
+		AfterEach(func() {
+			ginkgomon.Kill(process)
+		})
+
+		It("should unmount orphaned volumes but leave in flight mounts alone", func() {
+			Expect(mountRegistry.BeginMount("fakedriver", "mounting-volume", "some-container", "hash", nil)).To(Succeed())
+			fakeDriver.ListReturns(voldriver.ListResponse{Volumes: []voldriver.VolumeInfo{
+				{Name: "orphaned-volume", Mountpoint: "foo"},
+				{Name: "mounting-volume", Mountpoint: "bar"},
+			}})
+
+			fakeClock.WaitForWatcherAndIncrement(time.Minute)
+
+			Eventually(fakeDriver.UnmountCallCount).Should(Equal(1))
+			_, unmountRequest := fakeDriver.UnmountArgsForCall(0)
+			Expect(unmountRequest.Name).To(Equal("orphaned-volume"))
+			Expect(mountRegistry.Mounts()).To(HaveLen(1))
+		})
 	})
 })
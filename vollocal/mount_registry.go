@@ -0,0 +1,378 @@
+package vollocal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+)
+
+const mountTableFileName = "mount-table.json"
+
+const (
+	MountStateMounting   = "mounting"
+	MountStateMounted    = "mounted"
+	MountStateUnmounting = "unmounting"
+)
+
+// MountRecord is volman's record of a volume it has asked a driver to mount, along with the containers using it.  The
+// config the volume was created with is only kept as hashes, so that secrets in it never reach the mount table.
+type MountRecord struct {
+	DriverId   string `json:"driverId"`
+	VolumeId   string `json:"volumeId"`
+	ConfigHash string `json:"configHash"`
+
+	// ConfigKeyHashes hashes each config value on its own.  A single value is easily guessed from its hash, so they
+	// are only kept in memory and never saved to the mount table.  Records loaded from the table have none.
+	ConfigKeyHashes map[string]string `json:"-"`
+
+	Path      string    `json:"path"`
+	Owners    []string  `json:"owners"`
+	State     string    `json:"state"`
+	MountedAt time.Time `json:"mountedAt"`
+}
+
+// MountRegistries reference count the volumes volman has mounted so that a volume shared by several containers is
+// only unmounted from the driver once the last of them lets go of it.  Mounts and unmounts are recorded as in flight
+// before the driver is called so that a restarted volman can tell half done operations apart from completed ones.
+type MountRegistry interface {
+	// Acquire records the owner against a completed mount and returns its path, or returns false if the volume is not
+	// currently mounted
+	Acquire(driverId, volumeId, owner string) (string, bool, error)
+	// BeginMount records that the owner is about to mount the volume, created with the config hashed by hashConfig and
+	// hashConfigKeys
+	BeginMount(driverId, volumeId, owner, configHash string, configKeyHashes map[string]string) error
+	// CompleteMount records that the driver has mounted the volume at the given path and time
+	CompleteMount(driverId, volumeId, path string, mountedAt time.Time) error
+	// Release drops the owner's reference and returns how many owners remain.  found is false when volman has no record
+	// of the volume.  When no owners remain the volume is recorded as being unmounted.
+	Release(driverId, volumeId, owner string) (remaining int, found bool, err error)
+	// AbortUnmount records that the driver failed to unmount the volume, which therefore remains mounted
+	AbortUnmount(driverId, volumeId string) error
+	// AbandonMount drops the reference of an owner whose mount failed, and forgets about the volume once no owners
+	// remain.  The owners of other mounts of the volume keep theirs.
+	AbandonMount(driverId, volumeId, owner string) error
+	// Remove forgets about a volume once it has been unmounted
+	Remove(driverId, volumeId string) error
+	// Record returns a copy of the record of the volume, or false if volman has none
+	Record(driverId, volumeId string) (MountRecord, bool)
+	// Mounts returns a copy of every record in the registry
+	Mounts() []MountRecord
+}
+
+type mountKey struct {
+	driverId string
+	volumeId string
+}
+
+type mountEntry struct {
+	configHash      string
+	configKeyHashes map[string]string
+	path            string
+	owners          map[string]struct{}
+	state           string
+	mountedAt       time.Time
+}
+
+type mountRegistry struct {
+	sync.Mutex
+	mounts map[mountKey]*mountEntry
+
+	// tablePath is empty for registries that are only kept in memory
+	tablePath string
+}
+
+type mountTable struct {
+	Mounts []MountRecord `json:"mounts"`
+}
+
+// NewMountRegistry returns a registry that is only kept in memory and so is lost when volman restarts
+func NewMountRegistry() MountRegistry {
+	return &mountRegistry{
+		mounts: map[mountKey]*mountEntry{},
+	}
+}
+
+// NewPersistentMountRegistry returns a registry that is saved to a table under the given directory on every change,
+// loading whatever table a previous volman left there
+func NewPersistentMountRegistry(logger lager.Logger, dir string) (MountRegistry, error) {
+	logger = logger.Session("new-persistent-mount-registry", lager.Data{"dir": dir})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		logger.Error("failed-creating-mount-table-dir", err)
+		return nil, err
+	}
+
+	registry := &mountRegistry{
+		mounts:    map[mountKey]*mountEntry{},
+		tablePath: filepath.Join(dir, mountTableFileName),
+	}
+
+	contents, err := ioutil.ReadFile(registry.tablePath)
+	if os.IsNotExist(err) {
+		return registry, nil
+	}
+	if err != nil {
+		logger.Error("failed-reading-mount-table", err)
+		return nil, err
+	}
+
+	var table mountTable
+	if err := json.Unmarshal(contents, &table); err != nil {
+		logger.Error("failed-parsing-mount-table", err)
+		return nil, err
+	}
+
+	for _, record := range table.Mounts {
+		entry := &mountEntry{
+			configHash: record.ConfigHash,
+			path:       record.Path,
+			owners:     map[string]struct{}{},
+			state:      record.State,
+			mountedAt:  record.MountedAt,
+		}
+		for _, owner := range record.Owners {
+			entry.owners[owner] = struct{}{}
+		}
+		registry.mounts[mountKey{record.DriverId, record.VolumeId}] = entry
+	}
+	logger.Info("loaded-mount-table", lager.Data{"mounts": len(registry.mounts)})
+
+	return registry, nil
+}
+
+func (m *mountRegistry) Acquire(driverId, volumeId, owner string) (string, bool, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	entry, ok := m.mounts[mountKey{driverId, volumeId}]
+	if !ok || entry.state != MountStateMounted {
+		return "", false, nil
+	}
+
+	entry.owners[owner] = struct{}{}
+	return entry.path, true, m.save()
+}
+
+func (m *mountRegistry) BeginMount(driverId, volumeId, owner, configHash string, configKeyHashes map[string]string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	key := mountKey{driverId, volumeId}
+	entry, ok := m.mounts[key]
+	if !ok {
+		entry = &mountEntry{owners: map[string]struct{}{}}
+		m.mounts[key] = entry
+	}
+
+	entry.configHash = configHash
+	entry.configKeyHashes = configKeyHashes
+	entry.owners[owner] = struct{}{}
+	entry.state = MountStateMounting
+	return m.save()
+}
+
+func (m *mountRegistry) CompleteMount(driverId, volumeId, path string, mountedAt time.Time) error {
+	m.Lock()
+	defer m.Unlock()
+
+	entry, ok := m.mounts[mountKey{driverId, volumeId}]
+	if !ok {
+		return nil
+	}
+
+	entry.path = path
+	entry.state = MountStateMounted
+	entry.mountedAt = mountedAt
+	return m.save()
+}
+
+func (m *mountRegistry) Release(driverId, volumeId, owner string) (int, bool, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	entry, ok := m.mounts[mountKey{driverId, volumeId}]
+	if !ok {
+		return 0, false, nil
+	}
+
+	delete(entry.owners, owner)
+	if len(entry.owners) == 0 {
+		entry.state = MountStateUnmounting
+	}
+	return len(entry.owners), true, m.save()
+}
+
+func (m *mountRegistry) AbortUnmount(driverId, volumeId string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	entry, ok := m.mounts[mountKey{driverId, volumeId}]
+	if !ok {
+		return nil
+	}
+
+	entry.state = MountStateMounted
+	return m.save()
+}
+
+func (m *mountRegistry) AbandonMount(driverId, volumeId, owner string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	key := mountKey{driverId, volumeId}
+	entry, ok := m.mounts[key]
+	if !ok {
+		return nil
+	}
+
+	delete(entry.owners, owner)
+	if len(entry.owners) == 0 {
+		delete(m.mounts, key)
+	}
+	return m.save()
+}
+
+func (m *mountRegistry) Remove(driverId, volumeId string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	delete(m.mounts, mountKey{driverId, volumeId})
+	return m.save()
+}
+
+func (m *mountRegistry) Record(driverId, volumeId string) (MountRecord, bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	entry, ok := m.mounts[mountKey{driverId, volumeId}]
+	if !ok {
+		return MountRecord{}, false
+	}
+	return entry.record(mountKey{driverId, volumeId}), true
+}
+
+func (m *mountRegistry) Mounts() []MountRecord {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.records()
+}
+
+func (m *mountRegistry) records() []MountRecord {
+	records := []MountRecord{}
+	for key, entry := range m.mounts {
+		records = append(records, entry.record(key))
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].DriverId != records[j].DriverId {
+			return records[i].DriverId < records[j].DriverId
+		}
+		return records[i].VolumeId < records[j].VolumeId
+	})
+
+	return records
+}
+
+func (entry *mountEntry) record(key mountKey) MountRecord {
+	owners := []string{}
+	for owner := range entry.owners {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+
+	var configKeyHashes map[string]string
+	if entry.configKeyHashes != nil {
+		configKeyHashes = map[string]string{}
+		for configKey, hash := range entry.configKeyHashes {
+			configKeyHashes[configKey] = hash
+		}
+	}
+
+	return MountRecord{
+		DriverId:        key.driverId,
+		VolumeId:        key.volumeId,
+		ConfigHash:      entry.configHash,
+		ConfigKeyHashes: configKeyHashes,
+		Path:            entry.path,
+		Owners:          owners,
+		State:           entry.state,
+		MountedAt:       entry.mountedAt,
+	}
+}
+
+// save writes the whole table atomically.  It must be called with the lock held.
+func (m *mountRegistry) save() error {
+	if m.tablePath == "" {
+		return nil
+	}
+
+	contents, err := json.Marshal(mountTable{Mounts: m.records()})
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomically(m.tablePath, contents)
+}
+
+// writeFileAtomically writes the contents to a temporary file beside the path and renames it into place, so that a
+// crash part way through never leaves a truncated file behind
+func writeFileAtomically(path string, contents []byte) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(contents); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), path)
+}
+
+// hashConfig returns a stable hash of the options a volume was created with.  encoding/json writes map keys in sorted
+// order so equal configs always hash the same.
+func hashConfig(config map[string]interface{}) string {
+	contents, err := json.Marshal(config)
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:])
+}
+
+// hashConfigKeys hashes each option a volume was created with on its own, so that a later config can be compared key by
+// key without the values being kept.  A single value is easily guessed from its hash, so these must never be saved.
+func hashConfigKeys(config map[string]interface{}) map[string]string {
+	hashes := map[string]string{}
+	for key, value := range config {
+		contents, err := json.Marshal(value)
+		if err != nil {
+			continue
+		}
+
+		sum := sha256.Sum256(contents)
+		hashes[key] = hex.EncodeToString(sum[:])
+	}
+	return hashes
+}
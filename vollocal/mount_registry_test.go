@@ -0,0 +1,174 @@
+package vollocal_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/volman/vollocal"
+	"github.com/tedsuo/ifrit"
+)
+
+var _ = Describe("MountRegistry", func() {
+	var (
+		logger   *lagertest.TestLogger
+		registry vollocal.MountRegistry
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("mount-registry-test")
+		registry = vollocal.NewMountRegistry()
+	})
+
+	Describe("#Acquire", func() {
+		It("returns false for a volume that is not mounted", func() {
+			_, ok, err := registry.Acquire("fakedriver", "fake-volume", "some-container")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ok).To(BeFalse())
+		})
+
+		It("returns false for a volume that is still being mounted", func() {
+			Expect(registry.BeginMount("fakedriver", "fake-volume", "some-container", "hash", nil)).To(Succeed())
+
+			_, ok, err := registry.Acquire("fakedriver", "fake-volume", "other-container")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ok).To(BeFalse())
+		})
+
+		It("returns the path of a mounted volume and records the new owner", func() {
+			Expect(registry.BeginMount("fakedriver", "fake-volume", "some-container", "hash", nil)).To(Succeed())
+			Expect(registry.CompleteMount("fakedriver", "fake-volume", "/var/vcap/data/mounts/fake-volume", time.Unix(123, 456))).To(Succeed())
+
+			path, ok, err := registry.Acquire("fakedriver", "fake-volume", "other-container")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(path).To(Equal("/var/vcap/data/mounts/fake-volume"))
+			Expect(registry.Mounts()[0].Owners).To(Equal([]string{"other-container", "some-container"}))
+		})
+	})
+
+	Describe("#Release", func() {
+		BeforeEach(func() {
+			Expect(registry.BeginMount("fakedriver", "fake-volume", "some-container", "hash", nil)).To(Succeed())
+			Expect(registry.CompleteMount("fakedriver", "fake-volume", "/var/vcap/data/mounts/fake-volume", time.Unix(123, 456))).To(Succeed())
+		})
+
+		It("reports unknown volumes as not found", func() {
+			_, found, err := registry.Release("fakedriver", "other-volume", "some-container")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeFalse())
+		})
+
+		It("marks the volume as unmounting when the last owner releases it", func() {
+			remaining, found, err := registry.Release("fakedriver", "fake-volume", "some-container")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeTrue())
+			Expect(remaining).To(Equal(0))
+			Expect(registry.Mounts()[0].State).To(Equal(vollocal.MountStateUnmounting))
+		})
+
+		It("restores the mounted state when the unmount is aborted", func() {
+			_, _, err := registry.Release("fakedriver", "fake-volume", "some-container")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(registry.AbortUnmount("fakedriver", "fake-volume")).To(Succeed())
+			Expect(registry.Mounts()[0].State).To(Equal(vollocal.MountStateMounted))
+		})
+	})
+
+	Describe("#AbandonMount", func() {
+		BeforeEach(func() {
+			Expect(registry.BeginMount("fakedriver", "fake-volume", "some-container", "hash", nil)).To(Succeed())
+			Expect(registry.BeginMount("fakedriver", "fake-volume", "other-container", "hash", nil)).To(Succeed())
+		})
+
+		It("keeps the volume for the owners that remain", func() {
+			Expect(registry.AbandonMount("fakedriver", "fake-volume", "other-container")).To(Succeed())
+			Expect(registry.Mounts()).To(HaveLen(1))
+			Expect(registry.Mounts()[0].Owners).To(Equal([]string{"some-container"}))
+		})
+
+		It("forgets the volume once no owners remain", func() {
+			Expect(registry.AbandonMount("fakedriver", "fake-volume", "other-container")).To(Succeed())
+			Expect(registry.AbandonMount("fakedriver", "fake-volume", "some-container")).To(Succeed())
+			Expect(registry.Mounts()).To(BeEmpty())
+		})
+	})
+
+	Context("when persistent", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "mount-table")
+			Expect(err).NotTo(HaveOccurred())
+
+			registry, err = vollocal.NewPersistentMountRegistry(logger, dir)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("reloads the mounts recorded by a previous registry", func() {
+			Expect(registry.BeginMount("fakedriver", "fake-volume", "some-container", "hash", nil)).To(Succeed())
+			Expect(registry.CompleteMount("fakedriver", "fake-volume", "/var/vcap/data/mounts/fake-volume", time.Unix(123, 456).UTC())).To(Succeed())
+			Expect(registry.BeginMount("fakedriver", "other-volume", "some-container", "other-hash", nil)).To(Succeed())
+
+			reloaded, err := vollocal.NewPersistentMountRegistry(logger, dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reloaded.Mounts()).To(Equal([]vollocal.MountRecord{
+				{DriverId: "fakedriver", VolumeId: "fake-volume", ConfigHash: "hash", Path: "/var/vcap/data/mounts/fake-volume", Owners: []string{"some-container"}, State: vollocal.MountStateMounted, MountedAt: time.Unix(123, 456).UTC()},
+				{DriverId: "fakedriver", VolumeId: "other-volume", ConfigHash: "other-hash", Path: "", Owners: []string{"some-container"}, State: vollocal.MountStateMounting},
+			}))
+		})
+
+		It("forgets removed mounts", func() {
+			Expect(registry.BeginMount("fakedriver", "fake-volume", "some-container", "hash", nil)).To(Succeed())
+			Expect(registry.Remove("fakedriver", "fake-volume")).To(Succeed())
+
+			reloaded, err := vollocal.NewPersistentMountRegistry(logger, dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reloaded.Mounts()).To(BeEmpty())
+		})
+
+		It("fails to load a corrupt table", func() {
+			Expect(ioutil.WriteFile(filepath.Join(dir, "mount-table.json"), []byte("garbage"), 0600)).To(Succeed())
+
+			_, err := vollocal.NewPersistentMountRegistry(logger, dir)
+			Expect(err).To(HaveOccurred())
+		})
+
+		Context("when volman starts with a table it can not read", func() {
+			var config vollocal.DriverConfig
+
+			BeforeEach(func() {
+				config = vollocal.NewDriverConfig()
+				config.DriverPaths = []string{defaultPluginsDirectory}
+				config.MountTableDir = dir
+			})
+
+			It("fails to start rather than purging the mounts it recorded", func() {
+				Expect(ioutil.WriteFile(filepath.Join(dir, "mount-table.json"), []byte("garbage"), 0600)).To(Succeed())
+
+				_, runner := vollocal.NewServer(logger, nil, config)
+				process := ifrit.Invoke(runner)
+				Eventually(process.Wait()).Should(Receive(HaveOccurred()))
+			})
+
+			It("fails to start rather than forgetting the unmounts it was retrying", func() {
+				Expect(ioutil.WriteFile(filepath.Join(dir, "unmount-queue.json"), []byte("garbage"), 0600)).To(Succeed())
+
+				_, runner := vollocal.NewServer(logger, nil, config)
+				process := ifrit.Invoke(runner)
+				Eventually(process.Wait()).Should(Receive(HaveOccurred()))
+			})
+		})
+	})
+})
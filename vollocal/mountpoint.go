@@ -0,0 +1,80 @@
+package vollocal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultAllowedMountRoots are the directories drivers may mount volumes under when none are configured
+var DefaultAllowedMountRoots = []string{"/var/vcap/data"}
+
+// checkMountpoint returns why the mountpoint is unsafe to hand to a container, or "" when it is safe.  The mountpoint
+// must be absolute, must not climb out through "..", and once symlinks are resolved must lie strictly under one of the
+// allowed roots.
+func checkMountpoint(mountpoint string, allowedRoots []string) string {
+	if !filepath.IsAbs(mountpoint) {
+		return "mountpoint is not an absolute path"
+	}
+
+	for _, element := range strings.Split(filepath.ToSlash(mountpoint), "/") {
+		if element == ".." {
+			return "mountpoint contains '..'"
+		}
+	}
+
+	resolved, err := resolvePath(mountpoint)
+	if err != nil {
+		return "failed to resolve mountpoint: " + err.Error()
+	}
+
+	if resolved == string(filepath.Separator) {
+		return "mountpoint resolves to the root directory"
+	}
+
+	for _, root := range allowedRoots {
+		resolvedRoot, err := resolvePath(root)
+		if err != nil {
+			continue
+		}
+		if strictlyUnder(resolved, resolvedRoot) {
+			return ""
+		}
+	}
+
+	return "mountpoint resolves to " + resolved + ", outside of the allowed mount roots " + strings.Join(allowedRoots, ", ")
+}
+
+// strictlyUnder reports whether the path lies below the root, which may itself be "/" or end in a separator
+func strictlyUnder(path string, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// resolvePath cleans the path and resolves any symlinks in it.  Trailing elements that do not exist yet are kept as
+// they are, since they can not be links.
+func resolvePath(path string) (string, error) {
+	path = filepath.Clean(path)
+
+	resolved, err := filepath.EvalSymlinks(path)
+	if err == nil {
+		return resolved, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	parent := filepath.Dir(path)
+	if parent == path {
+		return path, nil
+	}
+
+	resolvedParent, err := resolvePath(parent)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(resolvedParent, filepath.Base(path)), nil
+}
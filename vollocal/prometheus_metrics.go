@@ -0,0 +1,281 @@
+package vollocal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/volman"
+	"github.com/tedsuo/ifrit"
+	"github.com/tedsuo/ifrit/http_server"
+)
+
+const (
+	MetricsLoggregator = "loggregator"
+	MetricsPrometheus  = "prometheus"
+)
+
+const (
+	prometheusMountDuration     = "volman_mount_duration_seconds"
+	prometheusUnmountDuration   = "volman_unmount_duration_seconds"
+	prometheusMountErrors       = "volman_mount_errors_total"
+	prometheusUnmountErrors     = "volman_unmount_errors_total"
+	prometheusRegisteredDrivers = "volman_registered_drivers"
+	prometheusActiveMounts      = "volman_active_mounts"
+
+	prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// The classes mount and unmount errors are counted under
+const (
+	errorClassDriverNotFound   = "driver_not_found"
+	errorClassVolumeNotFound   = "volume_not_found"
+	errorClassTimeout          = "timeout"
+	errorClassDriverUnhealthy  = "driver_unhealthy"
+	errorClassDriver           = "driver"
+	errorClassConfigConflict   = "config_conflict"
+	errorClassInvalidRequest   = "invalid_request"
+	errorClassUnsafeMountpoint = "unsafe_mountpoint"
+	errorClassInternal         = "internal"
+)
+
+// DefaultDurationBuckets are the upper bounds, in seconds, of the mount and unmount duration histograms.  They reach
+// past the default mount and unmount timeouts.
+var DefaultDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// VolumeMetrics records mounts and unmounts by driver, for metrics systems that label their metrics rather than name
+// a metric per driver
+type VolumeMetrics interface {
+	MountDuration(driverId string, duration time.Duration)
+	UnmountDuration(driverId string, duration time.Duration)
+	MountError(driverId string, err error)
+	UnmountError(driverId string, err error)
+}
+
+// PrometheusMetrics keeps the volume metrics and serves them, along with gauges of the registered drivers and active
+// mounts, in the Prometheus text format
+type PrometheusMetrics interface {
+	VolumeMetrics
+	http.Handler
+}
+
+type histogram struct {
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+type errorKey struct {
+	driverId string
+	class    string
+}
+
+type prometheusMetrics struct {
+	driverRegistry DriverRegistry
+	mountRegistry  MountRegistry
+	buckets        []float64
+
+	lock             sync.Mutex
+	mountDurations   map[string]*histogram
+	unmountDurations map[string]*histogram
+	mountErrors      map[errorKey]uint64
+	unmountErrors    map[errorKey]uint64
+}
+
+func NewPrometheusMetrics(driverRegistry DriverRegistry, mountRegistry MountRegistry) PrometheusMetrics {
+	return NewPrometheusMetricsWithBuckets(driverRegistry, mountRegistry, DefaultDurationBuckets)
+}
+
+// NewPrometheusMetricsWithBuckets returns metrics whose duration histograms have the given upper bounds, in seconds
+func NewPrometheusMetricsWithBuckets(driverRegistry DriverRegistry, mountRegistry MountRegistry, buckets []float64) PrometheusMetrics {
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+
+	return &prometheusMetrics{
+		driverRegistry:   driverRegistry,
+		mountRegistry:    mountRegistry,
+		buckets:          sorted,
+		mountDurations:   map[string]*histogram{},
+		unmountDurations: map[string]*histogram{},
+		mountErrors:      map[errorKey]uint64{},
+		unmountErrors:    map[errorKey]uint64{},
+	}
+}
+
+// NewPrometheusServer returns an ifrit runner serving the metrics on /metrics at the tcp host:port
+func NewPrometheusServer(listenAddress string, metrics PrometheusMetrics) ifrit.Runner {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	return http_server.New(listenAddress, mux)
+}
+
+func (m *prometheusMetrics) MountDuration(driverId string, duration time.Duration) {
+	m.observe(m.mountDurations, driverId, duration)
+}
+
+func (m *prometheusMetrics) UnmountDuration(driverId string, duration time.Duration) {
+	m.observe(m.unmountDurations, driverId, duration)
+}
+
+func (m *prometheusMetrics) MountError(driverId string, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.mountErrors[errorKey{driverId: driverId, class: errorClass(err)}]++
+}
+
+func (m *prometheusMetrics) UnmountError(driverId string, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.unmountErrors[errorKey{driverId: driverId, class: errorClass(err)}]++
+}
+
+func (m *prometheusMetrics) observe(histograms map[string]*histogram, driverId string, duration time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	h, ok := histograms[driverId]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(m.buckets))}
+		histograms[driverId] = h
+	}
+
+	seconds := duration.Seconds()
+	for i, bound := range m.buckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+func (m *prometheusMetrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", prometheusContentType)
+
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	m.writeGauges(out)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.writeHistograms(out, prometheusMountDuration, "How long volman took to mount volumes, by driver", m.mountDurations)
+	m.writeHistograms(out, prometheusUnmountDuration, "How long volman took to unmount volumes, by driver", m.unmountDurations)
+	writeErrorCounters(out, prometheusMountErrors, "Mounts that failed, by driver and class of error", m.mountErrors)
+	writeErrorCounters(out, prometheusUnmountErrors, "Unmounts that failed, by driver and class of error", m.unmountErrors)
+}
+
+// writeGauges reads the registries as they are at the time of the scrape
+func (m *prometheusMetrics) writeGauges(out *bufio.Writer) {
+	drivers := m.driverRegistry.Drivers()
+
+	activeMounts := map[string]int{}
+	for driverId := range drivers {
+		activeMounts[driverId] = 0
+	}
+	for _, record := range m.mountRegistry.Mounts() {
+		if record.State == MountStateMounted {
+			activeMounts[record.DriverId]++
+		}
+	}
+
+	writeHeader(out, prometheusRegisteredDrivers, "Drivers volman has activated", "gauge")
+	fmt.Fprintf(out, "%s %d\n", prometheusRegisteredDrivers, len(drivers))
+
+	driverIds := []string{}
+	for driverId := range activeMounts {
+		driverIds = append(driverIds, driverId)
+	}
+	sort.Strings(driverIds)
+
+	writeHeader(out, prometheusActiveMounts, "Volumes volman has mounted, by driver", "gauge")
+	for _, driverId := range driverIds {
+		fmt.Fprintf(out, "%s{driver=\"%s\"} %d\n", prometheusActiveMounts, escapeLabel(driverId), activeMounts[driverId])
+	}
+}
+
+func (m *prometheusMetrics) writeHistograms(out *bufio.Writer, name string, help string, histograms map[string]*histogram) {
+	writeHeader(out, name, help, "histogram")
+
+	driverIds := []string{}
+	for driverId := range histograms {
+		driverIds = append(driverIds, driverId)
+	}
+	sort.Strings(driverIds)
+
+	for _, driverId := range driverIds {
+		h := histograms[driverId]
+		driver := escapeLabel(driverId)
+		for i, bound := range m.buckets {
+			fmt.Fprintf(out, "%s_bucket{driver=\"%s\",le=\"%s\"} %d\n", name, driver, formatFloat(bound), h.buckets[i])
+		}
+		fmt.Fprintf(out, "%s_bucket{driver=\"%s\",le=\"+Inf\"} %d\n", name, driver, h.count)
+		fmt.Fprintf(out, "%s_sum{driver=\"%s\"} %s\n", name, driver, formatFloat(h.sum))
+		fmt.Fprintf(out, "%s_count{driver=\"%s\"} %d\n", name, driver, h.count)
+	}
+}
+
+func writeErrorCounters(out *bufio.Writer, name string, help string, counters map[errorKey]uint64) {
+	writeHeader(out, name, help, "counter")
+
+	keys := []errorKey{}
+	for key := range counters {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].driverId != keys[j].driverId {
+			return keys[i].driverId < keys[j].driverId
+		}
+		return keys[i].class < keys[j].class
+	})
+
+	for _, key := range keys {
+		fmt.Fprintf(out, "%s{driver=\"%s\",class=\"%s\"} %d\n", name, escapeLabel(key.driverId), key.class, counters[key])
+	}
+}
+
+func writeHeader(out *bufio.Writer, name string, help string, metricType string) {
+	fmt.Fprintf(out, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(out, "# TYPE %s %s\n", name, metricType)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+// errorClass sorts the errors mounts and unmounts fail with into the few classes they are counted under, so that a
+// driver's error messages don't each become a metric.  Wrapped errors are counted under the class of what they wrap.
+func errorClass(err error) string {
+	switch {
+	case errors.As(err, new(volman.DriverNotFoundError)):
+		return errorClassDriverNotFound
+	case errors.As(err, new(volman.VolumeNotFoundError)):
+		return errorClassVolumeNotFound
+	case errors.As(err, new(volman.TimeoutError)):
+		return errorClassTimeout
+	case errors.As(err, new(volman.DriverUnhealthyError)):
+		return errorClassDriverUnhealthy
+	case errors.As(err, new(volman.DriverError)):
+		return errorClassDriver
+	case errors.As(err, new(volman.ConfigConflictError)):
+		return errorClassConfigConflict
+	case errors.As(err, new(volman.MissingContainerIdError)):
+		return errorClassInvalidRequest
+	case errors.As(err, new(volman.UnsafeMountpointError)):
+		return errorClassUnsafeMountpoint
+	}
+	return errorClassInternal
+}
@@ -0,0 +1,230 @@
+package vollocal_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	mfakes "code.cloudfoundry.org/go-loggregator/loggregator_v2/fakes"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/voldriver"
+	"code.cloudfoundry.org/voldriver/voldriverfakes"
+	"code.cloudfoundry.org/volman"
+	"code.cloudfoundry.org/volman/vollocal"
+	"github.com/tedsuo/ifrit"
+	"github.com/tedsuo/ifrit/ginkgomon"
+)
+
+var _ = Describe("Prometheus Metrics", func() {
+	var (
+		driverRegistry vollocal.DriverRegistry
+		mountRegistry  vollocal.MountRegistry
+		metrics        vollocal.PrometheusMetrics
+
+		listenAddress string
+		process       ifrit.Process
+	)
+
+	BeforeEach(func() {
+		driverRegistry = vollocal.NewDriverRegistry()
+		mountRegistry = vollocal.NewMountRegistry()
+		metrics = vollocal.NewPrometheusMetricsWithBuckets(driverRegistry, mountRegistry, []float64{1, 0.1, 10})
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		listenAddress = listener.Addr().String()
+		Expect(listener.Close()).To(Succeed())
+
+		process = ginkgomon.Invoke(vollocal.NewPrometheusServer(listenAddress, metrics))
+	})
+
+	AfterEach(func() {
+		ginkgomon.Kill(process)
+	})
+
+	scrape := func() string {
+		response, err := http.Get(fmt.Sprintf("http://%s/metrics", listenAddress))
+		Expect(err).NotTo(HaveOccurred())
+		defer response.Body.Close()
+
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+		Expect(response.Header.Get("Content-Type")).To(HavePrefix("text/plain; version=0.0.4"))
+
+		body, err := ioutil.ReadAll(response.Body)
+		Expect(err).NotTo(HaveOccurred())
+		return string(body)
+	}
+
+	It("reports the registered drivers and their active mounts", func() {
+		driverRegistry.Set(map[string]voldriver.Driver{"fakedriver": new(voldriverfakes.FakeDriver), "otherdriver": new(voldriverfakes.FakeDriver)})
+		Expect(mountRegistry.BeginMount("fakedriver", "fake-volume", "some-container", "", nil)).To(Succeed())
+		Expect(mountRegistry.CompleteMount("fakedriver", "fake-volume", "/var/vcap/data/mounts/fake-volume", time.Now())).To(Succeed())
+		Expect(mountRegistry.BeginMount("fakedriver", "mounting-volume", "some-container", "", nil)).To(Succeed())
+
+		body := scrape()
+		Expect(body).To(ContainSubstring("# TYPE volman_registered_drivers gauge\nvolman_registered_drivers 2\n"))
+		Expect(body).To(ContainSubstring("# TYPE volman_active_mounts gauge\n"))
+		Expect(body).To(ContainSubstring(`volman_active_mounts{driver="fakedriver"} 1` + "\n"))
+		Expect(body).To(ContainSubstring(`volman_active_mounts{driver="otherdriver"} 0` + "\n"))
+	})
+
+	It("reports mount and unmount durations as histograms by driver", func() {
+		metrics.MountDuration("fakedriver", 500*time.Millisecond)
+		metrics.MountDuration("fakedriver", 5*time.Second)
+		metrics.UnmountDuration("otherdriver", 50*time.Millisecond)
+
+		body := scrape()
+		Expect(body).To(ContainSubstring("# TYPE volman_mount_duration_seconds histogram\n" +
+			`volman_mount_duration_seconds_bucket{driver="fakedriver",le="0.1"} 0` + "\n" +
+			`volman_mount_duration_seconds_bucket{driver="fakedriver",le="1"} 1` + "\n" +
+			`volman_mount_duration_seconds_bucket{driver="fakedriver",le="10"} 2` + "\n" +
+			`volman_mount_duration_seconds_bucket{driver="fakedriver",le="+Inf"} 2` + "\n" +
+			`volman_mount_duration_seconds_sum{driver="fakedriver"} 5.5` + "\n" +
+			`volman_mount_duration_seconds_count{driver="fakedriver"} 2` + "\n"))
+		Expect(body).To(ContainSubstring(`volman_unmount_duration_seconds_bucket{driver="otherdriver",le="0.1"} 1` + "\n"))
+		Expect(body).To(ContainSubstring(`volman_unmount_duration_seconds_count{driver="otherdriver"} 1` + "\n"))
+	})
+
+	It("counts errors by driver and class", func() {
+		metrics.MountError("fakedriver", volman.TimeoutError{DriverId: "fakedriver", Operation: "mount"})
+		metrics.MountError("fakedriver", volman.TimeoutError{DriverId: "fakedriver", Operation: "mount"})
+		metrics.MountError("fakedriver", volman.DriverError{DriverId: "fakedriver", Message: "badness"})
+		metrics.MountError("fakedriver", volman.NewError(volman.UnsafeMountpointError{DriverId: "fakedriver"}))
+		metrics.MountError("fakedriver", errors.New("disk full"))
+		metrics.UnmountError("otherdriver", volman.DriverNotFoundError{DriverId: "otherdriver"})
+		metrics.UnmountError("otherdriver", fmt.Errorf("unmounting: %w", volman.NewError(volman.DriverNotFoundError{DriverId: "otherdriver"})))
+
+		body := scrape()
+		Expect(body).To(ContainSubstring("# TYPE volman_mount_errors_total counter\n"))
+		Expect(body).To(ContainSubstring(`volman_mount_errors_total{driver="fakedriver",class="timeout"} 2` + "\n"))
+		Expect(body).To(ContainSubstring(`volman_mount_errors_total{driver="fakedriver",class="driver"} 1` + "\n"))
+		Expect(body).To(ContainSubstring(`volman_mount_errors_total{driver="fakedriver",class="unsafe_mountpoint"} 1` + "\n"))
+		Expect(body).To(ContainSubstring(`volman_mount_errors_total{driver="fakedriver",class="internal"} 1` + "\n"))
+		Expect(body).To(ContainSubstring(`volman_unmount_errors_total{driver="otherdriver",class="driver_not_found"} 2` + "\n"))
+	})
+
+	It("escapes driver names in labels", func() {
+		metrics.MountDuration(`odd"driver`, time.Second)
+
+		Expect(scrape()).To(ContainSubstring(`volman_mount_duration_seconds_count{driver="odd\"driver"} 1`))
+	})
+
+	Context("when chosen in the driver config", func() {
+		var (
+			fakeMetronClient *mfakes.FakeClient
+			serverProcess    ifrit.Process
+		)
+
+		BeforeEach(func() {
+			fakeMetronClient = new(mfakes.FakeClient)
+
+			// the server below listens there instead
+			ginkgomon.Kill(process)
+
+			config := vollocal.NewDriverConfig()
+			config.DriverPaths = []string{defaultPluginsDirectory}
+			config.Metrics = vollocal.MetricsPrometheus
+			config.MetricsListenAddress = listenAddress
+
+			_, runner := vollocal.NewServer(lagertest.NewTestLogger("prometheus-metrics"), fakeMetronClient, config)
+			serverProcess = ginkgomon.Invoke(runner)
+		})
+
+		AfterEach(func() {
+			ginkgomon.Kill(serverProcess)
+		})
+
+		It("serves /metrics", func() {
+			Expect(scrape()).To(ContainSubstring("volman_registered_drivers 0\n"))
+		})
+
+		It("still sends the metrics prometheus does not serve to metron", func() {
+			Eventually(fakeMetronClient.SendMetricCallCount).Should(BeNumerically(">", 0))
+			name, value := fakeMetronClient.SendMetricArgsForCall(0)
+			Expect(name).To(Equal("VolmanDriverNameCollisions"))
+			Expect(value).To(BeEquivalentTo(0))
+		})
+	})
+
+	Context("when chosen in the driver config without a listen address", func() {
+		It("fails to start rather than run without metrics", func() {
+			config := vollocal.NewDriverConfig()
+			config.DriverPaths = []string{defaultPluginsDirectory}
+			config.Metrics = vollocal.MetricsPrometheus
+
+			_, runner := vollocal.NewServer(lagertest.NewTestLogger("prometheus-metrics"), nil, config)
+			failed := ifrit.Invoke(runner)
+			Eventually(failed.Wait()).Should(Receive(HaveOccurred()))
+		})
+	})
+
+	Context("when recorded by the local client", func() {
+		var (
+			logger     *lagertest.TestLogger
+			fakeDriver *voldriverfakes.FakeDriver
+			client     volman.Manager
+		)
+
+		BeforeEach(func() {
+			logger = lagertest.NewTestLogger("prometheus-metrics")
+
+			fakeDriver = new(voldriverfakes.FakeDriver)
+			fakeDriver.MountReturns(voldriver.MountResponse{Mountpoint: "/var/vcap/data/mounts/fake-volume"})
+			driverRegistry.Set(map[string]voldriver.Driver{"fakedriver": fakeDriver})
+
+			// without a metron client, as when volman only serves its metrics to Prometheus
+			client = vollocal.NewLocalClientWithOptions(logger, driverRegistry, vollocal.LocalClientOptions{MountRegistry: mountRegistry, Metrics: metrics, Clock: fakeclock.NewFakeClock(time.Now())})
+		})
+
+		It("reports its mounts and unmounts", func() {
+			_, err := client.Mount(logger, context.Background(), "fakedriver", "fake-volume", "some-container", map[string]interface{}{})
+			Expect(err).NotTo(HaveOccurred())
+
+			body := scrape()
+			Expect(body).To(ContainSubstring(`volman_mount_duration_seconds_count{driver="fakedriver"} 1` + "\n"))
+			Expect(body).To(ContainSubstring(`volman_active_mounts{driver="fakedriver"} 1` + "\n"))
+
+			fakeDriver.UnmountReturns(voldriver.ErrorResponse{Err: "device busy"})
+			Expect(client.Unmount(logger, context.Background(), "fakedriver", "fake-volume", "some-container")).NotTo(Succeed())
+
+			body = scrape()
+			Expect(body).To(ContainSubstring(`volman_unmount_duration_seconds_count{driver="fakedriver"} 1` + "\n"))
+			Expect(body).To(ContainSubstring(`volman_unmount_errors_total{driver="fakedriver",class="driver"} 1` + "\n"))
+		})
+
+		Context("with a metron client too", func() {
+			var fakeMetronClient *mfakes.FakeClient
+
+			BeforeEach(func() {
+				fakeMetronClient = new(mfakes.FakeClient)
+				client = vollocal.NewLocalClientWithOptions(logger, driverRegistry, vollocal.LocalClientOptions{MountRegistry: mountRegistry, Metrics: metrics, MetronClient: fakeMetronClient, Clock: fakeclock.NewFakeClock(time.Now())})
+			})
+
+			It("reports its mounts only to prometheus and its other metrics to metron", func() {
+				fakeDriver.MountReturns(voldriver.MountResponse{Mountpoint: "/etc"})
+
+				_, err := client.Mount(logger, context.Background(), "fakedriver", "fake-volume", "some-container", map[string]interface{}{})
+				Expect(err).To(BeAssignableToTypeOf(volman.UnsafeMountpointError{}))
+
+				Expect(scrape()).To(ContainSubstring(`volman_mount_errors_total{driver="fakedriver",class="unsafe_mountpoint"} 1` + "\n"))
+				Expect(fakeMetronClient.IncrementCounterCallCount()).To(Equal(1))
+				Expect(fakeMetronClient.IncrementCounterArgsForCall(0)).To(Equal("VolmanUnsafeMountpoints"))
+			})
+		})
+
+		It("counts mounts with unknown drivers", func() {
+			_, err := client.Mount(logger, context.Background(), "unknowndriver", "fake-volume", "some-container", map[string]interface{}{})
+			Expect(err).To(HaveOccurred())
+
+			Expect(scrape()).To(ContainSubstring(`volman_mount_errors_total{driver="unknowndriver",class="driver_not_found"} 1` + "\n"))
+		})
+	})
+})
@@ -0,0 +1,144 @@
+package vollocal
+
+import (
+	"context"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/voldriver"
+	"code.cloudfoundry.org/volman"
+)
+
+// SpecCheckReport describes the driver spec files on the driver paths as discovery would read them
+type SpecCheckReport struct {
+	// Specs are ordered by driver, in the order drivers are found, and then in the order the driver's spec files
+	// would be tried
+	Specs []SpecCheck `json:"specs"`
+
+	// Collisions lists the drivers named by more than one spec file
+	Collisions []volman.DriverCollision `json:"collisions"`
+}
+
+// SpecCheck describes one driver spec file
+type SpecCheck struct {
+	Driver     string `json:"driver"`
+	SpecFile   string `json:"specFile"`
+	SpecFormat string `json:"specFormat"`
+
+	// SpecAddress is the address as the spec file gives it, and Address the canonical url volman reaches it on
+	SpecAddress string               `json:"specAddress,omitempty"`
+	Address     string               `json:"address,omitempty"`
+	TLSConfig   *voldriver.TLSConfig `json:"tlsConfig,omitempty"`
+
+	// Error is why the spec file could not be read
+	Error string `json:"error,omitempty"`
+
+	// Winner is true for the spec file the driver would be activated from.  Without activating, it is the first
+	// readable spec file the precedence policy would try.
+	Winner bool `json:"winner"`
+
+	// Activation is what the driver said when activated from the spec file, if it was
+	Activation *SpecActivation `json:"activation,omitempty"`
+}
+
+// SpecActivation describes a driver activated from a spec file
+type SpecActivation struct {
+	Implements []string `json:"implements,omitempty"`
+	Scope      string   `json:"scope,omitempty"`
+
+	// Error is why the driver did not activate as a volume driver
+	Error string `json:"error,omitempty"`
+}
+
+// CheckSpecs reads every spec file on the driver paths with the factory, as discovery does, and reports for each
+// driver which spec file would win under the precedence policy.  With activate, the driver is activated from each
+// readable spec file under the policies and asked for its capabilities, and the winner is the first to activate.
+// Nothing is registered either way, so it can be run without volman.
+func CheckSpecs(logger lager.Logger, ctx context.Context, factory DriverFactory, driverPaths []string, policies DriverPolicies, precedence DriverPrecedence, activate bool) (SpecCheckReport, error) {
+	logger = logger.Session("check-specs", lager.Data{"activate": activate})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	caller := driverCaller{clock: clock.NewClock(), policies: policies}
+
+	candidates, specNames, err := findCandidates(logger, driverPaths)
+	if err != nil {
+		return SpecCheckReport{}, err
+	}
+
+	found := map[string][]driverCandidate{}
+	for specName, specCandidates := range candidates {
+		found[specName] = specCandidates
+	}
+	collisions := orderCandidates(logger, precedence, specNames, candidates)
+
+	report := SpecCheckReport{Specs: []SpecCheck{}, Collisions: []volman.DriverCollision{}}
+	for i, specName := range specNames {
+		// a driver the precedence policy refuses is never activated, but its spec files are still worth reading
+		refused := len(candidates[specName]) == 0
+		specCandidates := candidates[specName]
+		if refused {
+			specCandidates = found[specName]
+		}
+
+		winner := ""
+		for _, candidate := range specCandidates {
+			check := checkSpec(logger, ctx, factory, caller, specName, candidate, activate)
+
+			readable := check.Error == ""
+			activated := check.Activation != nil && check.Activation.Error == ""
+			if !refused && winner == "" && readable && (!activate || activated) {
+				check.Winner = true
+				winner = check.SpecFile
+			}
+
+			report.Specs = append(report.Specs, check)
+		}
+
+		if collisions[i] != nil {
+			collisions[i].Chosen = winner
+			report.Collisions = append(report.Collisions, *collisions[i])
+		}
+	}
+
+	return report, nil
+}
+
+func checkSpec(logger lager.Logger, ctx context.Context, factory DriverFactory, caller driverCaller, specName string, candidate driverCandidate, activate bool) SpecCheck {
+	check := SpecCheck{
+		Driver:     specName,
+		SpecFile:   candidate.path(),
+		SpecFormat: candidate.format(),
+	}
+
+	spec, err := factory.Spec(logger, specName, candidate.driverPath, candidate.specFile)
+	check.SpecAddress, check.Address, check.TLSConfig = spec.SpecAddress, spec.Address, spec.TLSConfig
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+
+	if !activate {
+		return check
+	}
+
+	check.Activation = &SpecActivation{}
+	driver, implements, err := activateCandidate(logger, ctx, factory, caller, specName, spec, nil)
+	if err != nil {
+		check.Activation.Error = err.Error()
+		return check
+	}
+	check.Activation.Implements = implements
+
+	capabilities, err := caller.capabilities(logger, ctx, specName, driver)
+	if err != nil {
+		logger.Error("failed-fetching-driver-capabilities", err, lager.Data{"driverId": specName})
+	} else {
+		check.Activation.Scope = capabilities.Capabilities.Scope
+		if check.Activation.Scope == "" {
+			check.Activation.Scope = DriverScopeLocal
+		}
+	}
+
+	return check
+}
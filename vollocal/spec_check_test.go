@@ -0,0 +1,173 @@
+package vollocal_test
+
+import (
+	"context"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/voldriver"
+	"code.cloudfoundry.org/voldriver/voldriverfakes"
+	"code.cloudfoundry.org/volman/vollocal"
+)
+
+var _ = Describe("Spec Check", func() {
+	var (
+		logger *lagertest.TestLogger
+
+		fakeRemoteClientFactory *voldriverfakes.FakeRemoteClientFactory
+		fakeDriver              *voldriverfakes.FakeDriver
+		precedence              vollocal.DriverPrecedence
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("spec-check")
+
+		fakeDriver = new(voldriverfakes.FakeDriver)
+		fakeDriver.ActivateReturns(voldriver.ActivateResponse{Implements: []string{"VolumeDriver"}})
+		fakeDriver.CapabilitiesReturns(voldriver.CapabilitiesResponse{Capabilities: voldriver.CapabilityInfo{Scope: "global"}})
+
+		fakeRemoteClientFactory = new(voldriverfakes.FakeRemoteClientFactory)
+		fakeRemoteClientFactory.NewRemoteClientReturns(fakeDriver, nil)
+
+		precedence = vollocal.DriverPrecedence{}
+	})
+
+	checkSpecs := func(activate bool) (vollocal.SpecCheckReport, error) {
+		factory := vollocal.NewDriverFactoryWithRemoteClientFactory(fakeRemoteClientFactory)
+		return vollocal.CheckSpecs(logger, context.Background(), factory, []string{defaultPluginsDirectory, secondPluginsDirectory}, vollocal.DriverPolicies{}, precedence, activate)
+	}
+
+	specCheck := func(report vollocal.SpecCheckReport, specFile string) vollocal.SpecCheck {
+		for _, check := range report.Specs {
+			if check.SpecFile == specFile {
+				return check
+			}
+		}
+		Fail("no check of " + specFile)
+		return vollocal.SpecCheck{}
+	}
+
+	Context("with a spec file of each format", func() {
+		BeforeEach(func() {
+			Expect(voldriver.WriteDriverSpec(logger, defaultPluginsDirectory, "jsondriver", "json", []byte(`{"Addr":"tcp://127.0.0.1:8750","TLSConfig":{"CAFile":"/var/vcap/jobs/driver/ca.crt"}}`))).To(Succeed())
+			Expect(voldriver.WriteDriverSpec(logger, defaultPluginsDirectory, "specdriver", "spec", []byte("http://127.0.0.1:9750"))).To(Succeed())
+			Expect(voldriver.WriteDriverSpec(logger, defaultPluginsDirectory, "brokendriver", "json", []byte(`{"invalid"}`))).To(Succeed())
+		})
+
+		It("reports the address each gives and the url volman would reach the driver on", func() {
+			report, err := checkSpecs(false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Specs).To(HaveLen(3))
+
+			jsonCheck := specCheck(report, filepath.Join(defaultPluginsDirectory, "jsondriver.json"))
+			Expect(jsonCheck.Driver).To(Equal("jsondriver"))
+			Expect(jsonCheck.SpecFormat).To(Equal("json"))
+			Expect(jsonCheck.SpecAddress).To(Equal("tcp://127.0.0.1:8750"))
+			Expect(jsonCheck.Address).To(Equal("http://127.0.0.1:8750"))
+			Expect(jsonCheck.TLSConfig).To(Equal(&voldriver.TLSConfig{CAFile: "/var/vcap/jobs/driver/ca.crt"}))
+			Expect(jsonCheck.Winner).To(BeTrue())
+			Expect(jsonCheck.Activation).To(BeNil())
+
+			specFileCheck := specCheck(report, filepath.Join(defaultPluginsDirectory, "specdriver.spec"))
+			Expect(specFileCheck.SpecAddress).To(Equal("http://127.0.0.1:9750"))
+			Expect(specFileCheck.Address).To(Equal("http://127.0.0.1:9750"))
+			Expect(specFileCheck.Winner).To(BeTrue())
+		})
+
+		It("reports the spec files that can not be read", func() {
+			report, err := checkSpecs(false)
+			Expect(err).NotTo(HaveOccurred())
+
+			brokenCheck := specCheck(report, filepath.Join(defaultPluginsDirectory, "brokendriver.json"))
+			Expect(brokenCheck.Error).NotTo(BeEmpty())
+			Expect(brokenCheck.Winner).To(BeFalse())
+		})
+
+		It("does not activate the drivers", func() {
+			_, err := checkSpecs(false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeDriver.ActivateCallCount()).To(Equal(0))
+		})
+
+		Context("when activating", func() {
+			It("reports what each driver implements and its scope", func() {
+				report, err := checkSpecs(true)
+				Expect(err).NotTo(HaveOccurred())
+
+				jsonCheck := specCheck(report, filepath.Join(defaultPluginsDirectory, "jsondriver.json"))
+				Expect(jsonCheck.Activation).To(Equal(&vollocal.SpecActivation{Implements: []string{"VolumeDriver"}, Scope: "global"}))
+				Expect(jsonCheck.Winner).To(BeTrue())
+
+				Expect(specCheck(report, filepath.Join(defaultPluginsDirectory, "brokendriver.json")).Activation).To(BeNil())
+			})
+		})
+	})
+
+	Context("with the same driver in both driver paths", func() {
+		var firstSpec, secondSpec string
+
+		BeforeEach(func() {
+			Expect(voldriver.WriteDriverSpec(logger, defaultPluginsDirectory, "fakedriver", "spec", []byte("http://127.0.0.1:8750"))).To(Succeed())
+			Expect(voldriver.WriteDriverSpec(logger, secondPluginsDirectory, "fakedriver", "json", []byte(`{"Addr":"http://127.0.0.1:9750"}`))).To(Succeed())
+			firstSpec = filepath.Join(defaultPluginsDirectory, "fakedriver.spec")
+			secondSpec = filepath.Join(secondPluginsDirectory, "fakedriver.json")
+		})
+
+		It("reports the first as the winner", func() {
+			report, err := checkSpecs(false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(specCheck(report, firstSpec).Winner).To(BeTrue())
+			Expect(specCheck(report, secondSpec).Winner).To(BeFalse())
+			Expect(report.Collisions).To(HaveLen(1))
+			Expect(report.Collisions[0].Chosen).To(Equal(firstSpec))
+		})
+
+		Context("when the first does not activate", func() {
+			BeforeEach(func() {
+				unresponsiveDriver := new(voldriverfakes.FakeDriver)
+				unresponsiveDriver.ActivateReturns(voldriver.ActivateResponse{Err: "connection refused"})
+
+				fakeRemoteClientFactory.NewRemoteClientStub = func(address string, _ *voldriver.TLSConfig) (voldriver.Driver, error) {
+					if address == "http://127.0.0.1:8750" {
+						return unresponsiveDriver, nil
+					}
+					return fakeDriver, nil
+				}
+			})
+
+			It("reports the second as the winner", func() {
+				report, err := checkSpecs(true)
+				Expect(err).NotTo(HaveOccurred())
+
+				first := specCheck(report, firstSpec)
+				Expect(first.Winner).To(BeFalse())
+				Expect(first.Activation.Error).To(ContainSubstring("connection refused"))
+
+				Expect(specCheck(report, secondSpec).Winner).To(BeTrue())
+				Expect(report.Collisions[0].Chosen).To(Equal(secondSpec))
+			})
+		})
+
+		Context("when conflicts are errors", func() {
+			BeforeEach(func() {
+				precedence = vollocal.DriverPrecedence{Policy: vollocal.PrecedenceErrorOnConflict}
+			})
+
+			It("still reads both but reports no winner", func() {
+				report, err := checkSpecs(false)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(report.Specs).To(HaveLen(2))
+				Expect(specCheck(report, firstSpec).Winner).To(BeFalse())
+				Expect(specCheck(report, secondSpec).Winner).To(BeFalse())
+				Expect(report.Collisions[0].Error).To(ContainSubstring("named by 2 spec files"))
+				Expect(report.Collisions[0].Chosen).To(BeEmpty())
+			})
+		})
+	})
+})
@@ -0,0 +1,427 @@
+package vollocal
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/go-loggregator/loggregator_v2"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/voldriver"
+	"code.cloudfoundry.org/volman"
+	"github.com/tedsuo/ifrit"
+)
+
+const (
+	unmountQueueFileName = "unmount-queue.json"
+
+	volmanUnmountQueueDepth     = "VolmanUnmountQueueDepth"
+	volmanUnmountQueueOldestAge = "VolmanUnmountQueueOldestAge"
+)
+
+const (
+	// DefaultUnmountRetryInterval is how often the queue looks for due unmounts when RetryInterval is left unset
+	DefaultUnmountRetryInterval = 10 * time.Second
+
+	// DefaultUnmountMaxBackoff caps the wait between retries when MaxBackoff is left unset
+	DefaultUnmountMaxBackoff = time.Hour
+)
+
+// UnmountRetryConfig tunes how the unmount queue retries the unmounts drivers failed
+type UnmountRetryConfig struct {
+	// RetryInterval is how often the queue looks for unmounts that are due, and reports its depth and age.  It is
+	// DefaultUnmountRetryInterval when left unset.
+	RetryInterval time.Duration
+
+	// InitialBackoff is the wait before the first retry; it doubles with each further retry up to MaxBackoff, which is
+	// DefaultUnmountMaxBackoff when left unset
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// Jitter is the fraction, between 0 and 1, of each backoff that is taken off at random so that unmounts that failed
+	// together are not all retried together
+	Jitter float64
+}
+
+// UnmountQueue retries the unmounts drivers failed, in the background, until they succeed or the volume is mounted
+// again
+type UnmountQueue interface {
+	Runner() ifrit.Runner
+
+	// Add queues the volume to be unmounted again after the given failure
+	Add(logger lager.Logger, driverId string, volumeId string, cause error)
+
+	// Remove drops any unmount queued for the volume, once it has been unmounted or mounted again
+	Remove(logger lager.Logger, driverId string, volumeId string)
+
+	// Pending returns the queued unmounts, oldest first
+	Pending() []volman.PendingUnmount
+}
+
+type unmountQueue struct {
+	sync.Mutex
+	logger        lager.Logger
+	registry      DriverRegistry
+	mountRegistry MountRegistry
+	endpoints     DriverEndpointMonitor
+	driverCaller  driverCaller
+	locks         *VolumeLocks
+	config        UnmountRetryConfig
+	metronClient  loggregator_v2.Client
+	clock         clock.Clock
+
+	pending map[mountKey]*volman.PendingUnmount
+
+	// queuePath is empty for queues that are only kept in memory
+	queuePath string
+}
+
+type unmountQueueTable struct {
+	Pending []volman.PendingUnmount `json:"pending"`
+}
+
+// UnmountQueueOptions are the collaborators and tuning of an unmount queue beyond its driver registry.  Any left
+// unset fall back to a default: an in-memory mount registry, the default policies, no health tracking or endpoint
+// monitor, volume locks of its own, a queue kept only in memory, no metrics and the real clock.
+type UnmountQueueOptions struct {
+	// MountRegistry holds the mount records the queue drops once their volumes are unmounted
+	MountRegistry MountRegistry
+
+	// Policies time out and retry the queue's driver calls, and Health is told their outcome
+	Policies DriverPolicies
+	Health   DriverHealth
+
+	// Endpoints chooses the driver to retry an unmount with, as the client does
+	Endpoints DriverEndpointMonitor
+
+	// VolumeLocks keep the queue's retries from racing the client's own mounts and unmounts of the same volume
+	VolumeLocks *VolumeLocks
+
+	Retry UnmountRetryConfig
+
+	// TableDir, when set, is where the queue is saved on every change and reloaded from, so that failed unmounts
+	// survive a restart
+	TableDir string
+
+	MetronClient loggregator_v2.Client
+	Clock        clock.Clock
+}
+
+// NewUnmountQueue returns a queue that retries unmounts with the drivers in the registry, dropping the mount records of
+// volumes once they are unmounted
+func NewUnmountQueue(logger lager.Logger, registry DriverRegistry, options UnmountQueueOptions) (UnmountQueue, error) {
+	tableDir := options.TableDir
+	logger = logger.Session("new-unmount-queue", lager.Data{"dir": tableDir})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if options.MountRegistry == nil {
+		options.MountRegistry = NewMountRegistry()
+	}
+	if options.Clock == nil {
+		options.Clock = clock.NewClock()
+	}
+	if options.VolumeLocks == nil {
+		options.VolumeLocks = NewVolumeLocks()
+	}
+	if options.Retry.RetryInterval <= 0 {
+		options.Retry.RetryInterval = DefaultUnmountRetryInterval
+	}
+	if options.Retry.MaxBackoff <= 0 {
+		options.Retry.MaxBackoff = DefaultUnmountMaxBackoff
+	}
+
+	queue := &unmountQueue{
+		logger:        logger,
+		registry:      registry,
+		mountRegistry: options.MountRegistry,
+		endpoints:     options.Endpoints,
+		driverCaller:  driverCaller{clock: options.Clock, policies: options.Policies, health: options.Health},
+		locks:         options.VolumeLocks,
+		config:        options.Retry,
+		metronClient:  options.MetronClient,
+		clock:         options.Clock,
+		pending:       map[mountKey]*volman.PendingUnmount{},
+	}
+
+	if tableDir == "" {
+		return queue, nil
+	}
+
+	if err := os.MkdirAll(tableDir, 0700); err != nil {
+		logger.Error("failed-creating-unmount-queue-dir", err)
+		return nil, err
+	}
+	queue.queuePath = filepath.Join(tableDir, unmountQueueFileName)
+
+	contents, err := ioutil.ReadFile(queue.queuePath)
+	if os.IsNotExist(err) {
+		return queue, nil
+	}
+	if err != nil {
+		logger.Error("failed-reading-unmount-queue", err)
+		return nil, err
+	}
+
+	var table unmountQueueTable
+	if err := json.Unmarshal(contents, &table); err != nil {
+		logger.Error("failed-parsing-unmount-queue", err)
+		return nil, err
+	}
+
+	for i := range table.Pending {
+		pending := table.Pending[i]
+		queue.pending[mountKey{pending.DriverId, pending.VolumeId}] = &pending
+	}
+	logger.Info("loaded-unmount-queue", lager.Data{"pending": len(queue.pending)})
+
+	return queue, nil
+}
+
+func (q *unmountQueue) Runner() ifrit.Runner {
+	return q
+}
+
+func (q *unmountQueue) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	logger := q.logger.Session("unmount-queue")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	close(ready)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	timer := q.clock.NewTimer(q.config.RetryInterval)
+	defer timer.Stop()
+
+	retriedCh := make(chan struct{}, 1)
+
+	for {
+		select {
+		case <-timer.C():
+			go func() {
+				q.retryDue(logger, ctx)
+				q.sendMetrics(logger)
+				retriedCh <- struct{}{}
+			}()
+
+		case <-retriedCh:
+			timer.Reset(q.config.RetryInterval)
+
+		case signal := <-signals:
+			logger.Info("received-signal", lager.Data{"signal": signal.String()})
+			return nil
+		}
+	}
+}
+
+func (q *unmountQueue) Add(logger lager.Logger, driverId string, volumeId string, cause error) {
+	logger = logger.Session("queue-unmount", lager.Data{"driverId": driverId, "volumeId": volumeId})
+
+	q.Lock()
+	defer q.Unlock()
+
+	now := q.clock.Now()
+	key := mountKey{driverId, volumeId}
+	pending, ok := q.pending[key]
+	if !ok {
+		pending = &volman.PendingUnmount{DriverId: driverId, VolumeId: volumeId, FirstFailedAt: now}
+		q.pending[key] = pending
+	}
+	q.failed(pending, now, cause)
+	logger.Info("queued", lager.Data{"attempts": pending.Attempts, "nextAttemptAt": pending.NextAttemptAt})
+
+	if err := q.save(); err != nil {
+		logger.Error("failed-saving-unmount-queue", err)
+	}
+}
+
+func (q *unmountQueue) Remove(logger lager.Logger, driverId string, volumeId string) {
+	logger = logger.Session("dequeue-unmount", lager.Data{"driverId": driverId, "volumeId": volumeId})
+
+	q.Lock()
+	defer q.Unlock()
+
+	key := mountKey{driverId, volumeId}
+	if _, ok := q.pending[key]; !ok {
+		return
+	}
+	delete(q.pending, key)
+	logger.Info("dequeued")
+
+	if err := q.save(); err != nil {
+		logger.Error("failed-saving-unmount-queue", err)
+	}
+}
+
+func (q *unmountQueue) Pending() []volman.PendingUnmount {
+	q.Lock()
+	defer q.Unlock()
+
+	return q.list()
+}
+
+// retryDue retries each unmount whose backoff has passed.  The volume is locked so that the retry can not race a
+// mount, and an unmount is dropped rather than retried once the volume has been mounted again.
+func (q *unmountQueue) retryDue(logger lager.Logger, ctx context.Context) {
+	logger = logger.Session("retry-due")
+	logger.Debug("start")
+	defer logger.Debug("end")
+
+	now := q.clock.Now()
+	for _, pending := range q.Pending() {
+		if pending.NextAttemptAt.After(now) {
+			continue
+		}
+		q.retry(logger, ctx, pending.DriverId, pending.VolumeId)
+	}
+}
+
+func (q *unmountQueue) retry(logger lager.Logger, ctx context.Context, driverId string, volumeId string) {
+	logger = logger.Session("retry", lager.Data{"driverId": driverId, "volumeId": volumeId})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	unlock, err := q.locks.lock(ctx, driverId, volumeId)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	record, recorded := q.mountRegistry.Record(driverId, volumeId)
+	if recorded && record.State == MountStateMounted && len(record.Owners) > 0 {
+		logger.Info("dropping-unmount-of-volume-mounted-again")
+		q.Remove(logger, driverId, volumeId)
+		return
+	}
+
+	driver, found := q.unmountDriver(driverId, volumeId)
+	if !found {
+		// the driver may just be slow to come back
+		q.retryFailed(logger, driverId, volumeId, volman.DriverNotFoundError{DriverId: driverId})
+		return
+	}
+
+	if err := q.driverCaller.unmount(logger, ctx, driverId, driver, voldriver.UnmountRequest{Name: volumeId}); err != nil {
+		logger.Error("unmount-failed", err)
+		q.retryFailed(logger, driverId, volumeId, err)
+		return
+	}
+
+	if recorded {
+		if err := q.mountRegistry.Remove(driverId, volumeId); err != nil {
+			logger.Error("failed-removing-mount-record", err)
+		}
+	}
+	if q.endpoints != nil {
+		q.endpoints.Unmounted(driverId, volumeId)
+	}
+	q.Remove(logger, driverId, volumeId)
+}
+
+func (q *unmountQueue) unmountDriver(driverId string, volumeId string) (voldriver.Driver, bool) {
+	if q.endpoints != nil {
+		return q.endpoints.UnmountDriver(driverId, volumeId)
+	}
+	return q.registry.Driver(driverId)
+}
+
+func (q *unmountQueue) retryFailed(logger lager.Logger, driverId string, volumeId string, cause error) {
+	q.Lock()
+	defer q.Unlock()
+
+	pending, ok := q.pending[mountKey{driverId, volumeId}]
+	if !ok {
+		return
+	}
+	q.failed(pending, q.clock.Now(), cause)
+
+	if err := q.save(); err != nil {
+		logger.Error("failed-saving-unmount-queue", err)
+	}
+}
+
+// failed records a failed attempt and schedules the next one.  It must be called with the lock held.
+func (q *unmountQueue) failed(pending *volman.PendingUnmount, now time.Time, cause error) {
+	backoff := q.config.InitialBackoff
+	for i := 0; i < pending.Attempts && backoff < q.config.MaxBackoff; i++ {
+		if backoff > q.config.MaxBackoff/2 {
+			backoff = q.config.MaxBackoff
+			break
+		}
+		backoff *= 2
+	}
+	if backoff > q.config.MaxBackoff {
+		backoff = q.config.MaxBackoff
+	}
+
+	if q.config.Jitter > 0 {
+		backoff -= time.Duration(rand.Float64() * q.config.Jitter * float64(backoff))
+	}
+
+	pending.Attempts++
+	pending.LastAttemptAt = now
+	pending.NextAttemptAt = now.Add(backoff)
+	pending.LastError = cause.Error()
+}
+
+func (q *unmountQueue) sendMetrics(logger lager.Logger) {
+	if q.metronClient == nil {
+		return
+	}
+	pending := q.Pending()
+
+	var oldestAge time.Duration
+	if len(pending) > 0 {
+		oldestAge = q.clock.Since(pending[0].FirstFailedAt)
+	}
+
+	if err := q.metronClient.SendMetric(volmanUnmountQueueDepth, int64(len(pending))); err != nil {
+		logger.Error("failed-to-send-unmount-queue-depth-metric", err)
+	}
+	if err := q.metronClient.SendDuration(volmanUnmountQueueOldestAge, oldestAge); err != nil {
+		logger.Error("failed-to-send-unmount-queue-age-metric", err)
+	}
+}
+
+// list returns a copy of the queue, oldest first.  It must be called with the lock held.
+func (q *unmountQueue) list() []volman.PendingUnmount {
+	pending := []volman.PendingUnmount{}
+	for _, unmount := range q.pending {
+		pending = append(pending, *unmount)
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		if !pending[i].FirstFailedAt.Equal(pending[j].FirstFailedAt) {
+			return pending[i].FirstFailedAt.Before(pending[j].FirstFailedAt)
+		}
+		if pending[i].DriverId != pending[j].DriverId {
+			return pending[i].DriverId < pending[j].DriverId
+		}
+		return pending[i].VolumeId < pending[j].VolumeId
+	})
+
+	return pending
+}
+
+// save writes the whole queue atomically.  It must be called with the lock held.
+func (q *unmountQueue) save() error {
+	if q.queuePath == "" {
+		return nil
+	}
+
+	contents, err := json.Marshal(unmountQueueTable{Pending: q.list()})
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomically(q.queuePath, contents)
+}
@@ -0,0 +1,224 @@
+package vollocal_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	mfakes "code.cloudfoundry.org/go-loggregator/loggregator_v2/fakes"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/voldriver"
+	"code.cloudfoundry.org/voldriver/voldriverfakes"
+	"code.cloudfoundry.org/volman"
+	"code.cloudfoundry.org/volman/vollocal"
+	"github.com/tedsuo/ifrit"
+	"github.com/tedsuo/ifrit/ginkgomon"
+)
+
+var _ = Describe("UnmountQueue", func() {
+	var (
+		logger        *lagertest.TestLogger
+		fakeClock     *fakeclock.FakeClock
+		fakeDriver    *voldriverfakes.FakeDriver
+		fakeMetron    *mfakes.FakeClient
+		registry      vollocal.DriverRegistry
+		mountRegistry vollocal.MountRegistry
+		config        vollocal.UnmountRetryConfig
+		tableDir      string
+		queue         vollocal.UnmountQueue
+
+		metricsLock  sync.Mutex
+		queueDepths  []int64
+		unmountCause error
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("unmount-queue")
+		fakeClock = fakeclock.NewFakeClock(time.Unix(123, 456))
+		fakeDriver = new(voldriverfakes.FakeDriver)
+		registry = vollocal.NewDriverRegistryWith(map[string]voldriver.Driver{"fakedriver": fakeDriver})
+		mountRegistry = vollocal.NewMountRegistry()
+		tableDir = ""
+		unmountCause = errors.New("badness")
+
+		queueDepths = nil
+		fakeMetron = new(mfakes.FakeClient)
+		fakeMetron.SendMetricStub = func(name string, value int64) error {
+			metricsLock.Lock()
+			defer metricsLock.Unlock()
+			if name == "VolmanUnmountQueueDepth" {
+				queueDepths = append(queueDepths, value)
+			}
+			return nil
+		}
+
+		config = vollocal.UnmountRetryConfig{
+			RetryInterval:  10 * time.Second,
+			InitialBackoff: 10 * time.Second,
+			MaxBackoff:     30 * time.Second,
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		queue, err = vollocal.NewUnmountQueue(logger, registry, vollocal.UnmountQueueOptions{MountRegistry: mountRegistry, Retry: config, TableDir: tableDir, MetronClient: fakeMetron, Clock: fakeClock})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("lists queued unmounts with when they will next be tried", func() {
+		queue.Add(logger, "fakedriver", "fake-volume", unmountCause)
+
+		Expect(queue.Pending()).To(Equal([]volman.PendingUnmount{{
+			DriverId:      "fakedriver",
+			VolumeId:      "fake-volume",
+			Attempts:      1,
+			LastError:     "badness",
+			FirstFailedAt: time.Unix(123, 456),
+			LastAttemptAt: time.Unix(123, 456),
+			NextAttemptAt: time.Unix(133, 456),
+		}}))
+	})
+
+	Context("when no max backoff is configured", func() {
+		BeforeEach(func() {
+			config.MaxBackoff = 0
+		})
+
+		It("caps the backoff at the default however often the unmount fails", func() {
+			for i := 0; i < 100; i++ {
+				queue.Add(logger, "fakedriver", "fake-volume", unmountCause)
+			}
+
+			pending := queue.Pending()[0]
+			Expect(pending.Attempts).To(Equal(100))
+			Expect(pending.NextAttemptAt).To(Equal(pending.LastAttemptAt.Add(vollocal.DefaultUnmountMaxBackoff)))
+		})
+	})
+
+	Context("when jitter is configured", func() {
+		BeforeEach(func() {
+			config.Jitter = 0.5
+		})
+
+		It("takes up to that fraction off the backoff", func() {
+			queue.Add(logger, "fakedriver", "fake-volume", unmountCause)
+
+			backoff := queue.Pending()[0].NextAttemptAt.Sub(time.Unix(123, 456))
+			Expect(backoff).To(BeNumerically(">", 5*time.Second))
+			Expect(backoff).To(BeNumerically("<=", 10*time.Second))
+		})
+	})
+
+	It("forgets removed unmounts", func() {
+		queue.Add(logger, "fakedriver", "fake-volume", unmountCause)
+		queue.Remove(logger, "fakedriver", "fake-volume")
+
+		Expect(queue.Pending()).To(BeEmpty())
+	})
+
+	Context("when running", func() {
+		var process ifrit.Process
+
+		retry := func() {
+			fakeClock.WaitForWatcherAndIncrement(config.RetryInterval)
+		}
+
+		JustBeforeEach(func() {
+			Expect(mountRegistry.BeginMount("fakedriver", "fake-volume", "some-container", "hash", nil)).To(Succeed())
+			Expect(mountRegistry.CompleteMount("fakedriver", "fake-volume", "/var/vcap/data/mounts/fake-volume", time.Unix(123, 456))).To(Succeed())
+			_, _, err := mountRegistry.Release("fakedriver", "fake-volume", "some-container")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mountRegistry.AbortUnmount("fakedriver", "fake-volume")).To(Succeed())
+
+			queue.Add(logger, "fakedriver", "fake-volume", unmountCause)
+			process = ginkgomon.Invoke(queue.Runner())
+		})
+
+		AfterEach(func() {
+			ginkgomon.Kill(process)
+		})
+
+		It("retries the unmount once its backoff has passed and forgets the mount", func() {
+			retry()
+			Eventually(fakeDriver.UnmountCallCount).Should(Equal(1))
+			_, unmountRequest := fakeDriver.UnmountArgsForCall(0)
+			Expect(unmountRequest.Name).To(Equal("fake-volume"))
+
+			Eventually(queue.Pending).Should(BeEmpty())
+			Expect(mountRegistry.Mounts()).To(BeEmpty())
+		})
+
+		It("reports the depth of the queue", func() {
+			retry()
+			Eventually(func() []int64 {
+				metricsLock.Lock()
+				defer metricsLock.Unlock()
+				return append([]int64{}, queueDepths...)
+			}).Should(Equal([]int64{0}))
+		})
+
+		Context("when the retry fails", func() {
+			BeforeEach(func() {
+				fakeDriver.UnmountReturns(voldriver.ErrorResponse{Err: "still badness"})
+			})
+
+			It("backs off further before trying again", func() {
+				retry()
+				Eventually(fakeDriver.UnmountCallCount).Should(Equal(1))
+				Eventually(func() int {
+					return queue.Pending()[0].Attempts
+				}).Should(Equal(2))
+
+				pending := queue.Pending()[0]
+				Expect(pending.LastError).To(ContainSubstring("still badness"))
+				Expect(pending.FirstFailedAt).To(Equal(time.Unix(123, 456)))
+				Expect(pending.NextAttemptAt).To(Equal(pending.LastAttemptAt.Add(20 * time.Second)))
+
+				retry()
+				Consistently(fakeDriver.UnmountCallCount).Should(Equal(1))
+			})
+		})
+
+		Context("when the volume has been mounted again", func() {
+			JustBeforeEach(func() {
+				_, _, err := mountRegistry.Acquire("fakedriver", "fake-volume", "other-container")
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("drops the unmount without calling the driver", func() {
+				retry()
+				Eventually(queue.Pending).Should(BeEmpty())
+				Expect(fakeDriver.UnmountCallCount()).To(Equal(0))
+				Expect(mountRegistry.Mounts()).To(HaveLen(1))
+			})
+		})
+	})
+
+	Context("when persistent", func() {
+		BeforeEach(func() {
+			var err error
+			tableDir, err = ioutil.TempDir("", "unmount-queue")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(tableDir)
+		})
+
+		It("reloads the unmounts queued by a previous queue", func() {
+			queue.Add(logger, "fakedriver", "fake-volume", unmountCause)
+
+			reloaded, err := vollocal.NewUnmountQueue(logger, registry, vollocal.UnmountQueueOptions{MountRegistry: mountRegistry, Retry: config, TableDir: tableDir, MetronClient: fakeMetron, Clock: fakeClock})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reloaded.Pending()).To(HaveLen(1))
+			Expect(reloaded.Pending()[0].VolumeId).To(Equal("fake-volume"))
+			Expect(reloaded.Pending()[0].NextAttemptAt.Equal(queue.Pending()[0].NextAttemptAt)).To(BeTrue())
+		})
+	})
+})
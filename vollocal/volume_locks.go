@@ -0,0 +1,60 @@
+package vollocal
+
+import (
+	"context"
+	"sync"
+)
+
+// VolumeLocks serializes the operations on each volume while letting operations on different volumes run in
+// parallel.  A lock is only kept while it is held or waited for.  The client, purger and unmount queue must share one
+// so that none of them acts on a volume another is mounting or unmounting.
+type VolumeLocks struct {
+	sync.Mutex
+	locks map[mountKey]*volumeLock
+}
+
+type volumeLock struct {
+	held chan struct{}
+
+	// users counts the holder and waiters so that the lock can be dropped once the last of them is done
+	users int
+}
+
+func NewVolumeLocks() *VolumeLocks {
+	return &VolumeLocks{locks: map[mountKey]*volumeLock{}}
+}
+
+// lock waits until the volume is free or the context is done, returning the function to release the volume with
+func (l *VolumeLocks) lock(ctx context.Context, driverId string, volumeId string) (func(), error) {
+	key := mountKey{driverId, volumeId}
+
+	l.Lock()
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &volumeLock{held: make(chan struct{}, 1)}
+		l.locks[key] = lock
+	}
+	lock.users++
+	l.Unlock()
+
+	select {
+	case lock.held <- struct{}{}:
+		return func() {
+			<-lock.held
+			l.release(key, lock)
+		}, nil
+	case <-ctx.Done():
+		l.release(key, lock)
+		return nil, ctx.Err()
+	}
+}
+
+func (l *VolumeLocks) release(key mountKey, lock *volumeLock) {
+	l.Lock()
+	defer l.Unlock()
+
+	lock.users--
+	if lock.users == 0 {
+		delete(l.locks, key)
+	}
+}
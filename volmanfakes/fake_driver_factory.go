@@ -23,6 +23,30 @@ type FakeDriverFactory struct {
 		result1 voldriver.Driver
 		result2 error
 	}
+	SpecStub        func(logger lager.Logger, driverId string, driverPath, driverFileName string) (vollocal.DriverSpec, error)
+	specMutex       sync.RWMutex
+	specArgsForCall []struct {
+		logger         lager.Logger
+		driverId       string
+		driverPath     string
+		driverFileName string
+	}
+	specReturns struct {
+		result1 vollocal.DriverSpec
+		result2 error
+	}
+	DriverFromSpecStub        func(logger lager.Logger, driverId string, spec vollocal.DriverSpec, existing map[string]voldriver.Driver) (voldriver.Driver, error)
+	driverFromSpecMutex       sync.RWMutex
+	driverFromSpecArgsForCall []struct {
+		logger   lager.Logger
+		driverId string
+		spec     vollocal.DriverSpec
+		existing map[string]voldriver.Driver
+	}
+	driverFromSpecReturns struct {
+		result1 voldriver.Driver
+		result2 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
@@ -64,11 +88,87 @@ func (fake *FakeDriverFactory) DriverReturns(result1 voldriver.Driver, result2 e
 	}{result1, result2}
 }
 
+func (fake *FakeDriverFactory) Spec(logger lager.Logger, driverId string, driverPath string, driverFileName string) (vollocal.DriverSpec, error) {
+	fake.specMutex.Lock()
+	fake.specArgsForCall = append(fake.specArgsForCall, struct {
+		logger         lager.Logger
+		driverId       string
+		driverPath     string
+		driverFileName string
+	}{logger, driverId, driverPath, driverFileName})
+	fake.recordInvocation("Spec", []interface{}{logger, driverId, driverPath, driverFileName})
+	fake.specMutex.Unlock()
+	if fake.SpecStub != nil {
+		return fake.SpecStub(logger, driverId, driverPath, driverFileName)
+	}
+	return fake.specReturns.result1, fake.specReturns.result2
+}
+
+func (fake *FakeDriverFactory) SpecCallCount() int {
+	fake.specMutex.RLock()
+	defer fake.specMutex.RUnlock()
+	return len(fake.specArgsForCall)
+}
+
+func (fake *FakeDriverFactory) SpecArgsForCall(i int) (lager.Logger, string, string, string) {
+	fake.specMutex.RLock()
+	defer fake.specMutex.RUnlock()
+	return fake.specArgsForCall[i].logger, fake.specArgsForCall[i].driverId, fake.specArgsForCall[i].driverPath, fake.specArgsForCall[i].driverFileName
+}
+
+func (fake *FakeDriverFactory) SpecReturns(result1 vollocal.DriverSpec, result2 error) {
+	fake.SpecStub = nil
+	fake.specReturns = struct {
+		result1 vollocal.DriverSpec
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeDriverFactory) DriverFromSpec(logger lager.Logger, driverId string, spec vollocal.DriverSpec, existing map[string]voldriver.Driver) (voldriver.Driver, error) {
+	fake.driverFromSpecMutex.Lock()
+	fake.driverFromSpecArgsForCall = append(fake.driverFromSpecArgsForCall, struct {
+		logger   lager.Logger
+		driverId string
+		spec     vollocal.DriverSpec
+		existing map[string]voldriver.Driver
+	}{logger, driverId, spec, existing})
+	fake.recordInvocation("DriverFromSpec", []interface{}{logger, driverId, spec, existing})
+	fake.driverFromSpecMutex.Unlock()
+	if fake.DriverFromSpecStub != nil {
+		return fake.DriverFromSpecStub(logger, driverId, spec, existing)
+	}
+	return fake.driverFromSpecReturns.result1, fake.driverFromSpecReturns.result2
+}
+
+func (fake *FakeDriverFactory) DriverFromSpecCallCount() int {
+	fake.driverFromSpecMutex.RLock()
+	defer fake.driverFromSpecMutex.RUnlock()
+	return len(fake.driverFromSpecArgsForCall)
+}
+
+func (fake *FakeDriverFactory) DriverFromSpecArgsForCall(i int) (lager.Logger, string, vollocal.DriverSpec, map[string]voldriver.Driver) {
+	fake.driverFromSpecMutex.RLock()
+	defer fake.driverFromSpecMutex.RUnlock()
+	return fake.driverFromSpecArgsForCall[i].logger, fake.driverFromSpecArgsForCall[i].driverId, fake.driverFromSpecArgsForCall[i].spec, fake.driverFromSpecArgsForCall[i].existing
+}
+
+func (fake *FakeDriverFactory) DriverFromSpecReturns(result1 voldriver.Driver, result2 error) {
+	fake.DriverFromSpecStub = nil
+	fake.driverFromSpecReturns = struct {
+		result1 voldriver.Driver
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeDriverFactory) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
 	fake.driverMutex.RLock()
 	defer fake.driverMutex.RUnlock()
+	fake.specMutex.RLock()
+	defer fake.specMutex.RUnlock()
+	fake.driverFromSpecMutex.RLock()
+	defer fake.driverFromSpecMutex.RUnlock()
 	return fake.invocations
 }
 
@@ -2,6 +2,7 @@
 package volmanfakes
 
 import (
+	"context"
 	"sync"
 
 	"code.cloudfoundry.org/lager"
@@ -9,50 +10,122 @@ import (
 )
 
 type FakeManager struct {
-	ListDriversStub        func(logger lager.Logger) (volman.ListDriversResponse, error)
+	ListDriversStub        func(logger lager.Logger, ctx context.Context) (volman.ListDriversResponse, error)
 	listDriversMutex       sync.RWMutex
 	listDriversArgsForCall []struct {
 		logger lager.Logger
+		ctx    context.Context
 	}
 	listDriversReturns struct {
 		result1 volman.ListDriversResponse
 		result2 error
 	}
-	MountStub        func(logger lager.Logger, driverId string, volumeId string, config map[string]interface{}) (volman.MountResponse, error)
+	DiscoverStub        func(logger lager.Logger, ctx context.Context) (volman.ListDriversResponse, error)
+	discoverMutex       sync.RWMutex
+	discoverArgsForCall []struct {
+		logger lager.Logger
+		ctx    context.Context
+	}
+	discoverReturns struct {
+		result1 volman.ListDriversResponse
+		result2 error
+	}
+	ListMountsStub        func(logger lager.Logger, ctx context.Context, filter volman.ListMountsFilter) (volman.ListMountsResponse, error)
+	listMountsMutex       sync.RWMutex
+	listMountsArgsForCall []struct {
+		logger lager.Logger
+		ctx    context.Context
+		filter volman.ListMountsFilter
+	}
+	listMountsReturns struct {
+		result1 volman.ListMountsResponse
+		result2 error
+	}
+	ListPendingUnmountsStub        func(logger lager.Logger, ctx context.Context) (volman.ListPendingUnmountsResponse, error)
+	listPendingUnmountsMutex       sync.RWMutex
+	listPendingUnmountsArgsForCall []struct {
+		logger lager.Logger
+		ctx    context.Context
+	}
+	listPendingUnmountsReturns struct {
+		result1 volman.ListPendingUnmountsResponse
+		result2 error
+	}
+	MountStub        func(logger lager.Logger, ctx context.Context, driverId string, volumeId string, containerId string, config map[string]interface{}) (volman.MountResponse, error)
 	mountMutex       sync.RWMutex
 	mountArgsForCall []struct {
-		logger   lager.Logger
-		driverId string
-		volumeId string
-		config   map[string]interface{}
+		logger      lager.Logger
+		ctx         context.Context
+		driverId    string
+		volumeId    string
+		containerId string
+		config      map[string]interface{}
 	}
 	mountReturns struct {
 		result1 volman.MountResponse
 		result2 error
 	}
-	UnmountStub        func(logger lager.Logger, driverId string, volumeId string) error
+	UnmountStub        func(logger lager.Logger, ctx context.Context, driverId string, volumeId string, containerId string) error
 	unmountMutex       sync.RWMutex
 	unmountArgsForCall []struct {
+		logger      lager.Logger
+		ctx         context.Context
+		driverId    string
+		volumeId    string
+		containerId string
+	}
+	unmountReturns struct {
+		result1 error
+	}
+	PathStub        func(logger lager.Logger, ctx context.Context, driverId string, volumeId string) (volman.PathResponse, error)
+	pathMutex       sync.RWMutex
+	pathArgsForCall []struct {
 		logger   lager.Logger
+		ctx      context.Context
 		driverId string
 		volumeId string
 	}
-	unmountReturns struct {
-		result1 error
+	pathReturns struct {
+		result1 volman.PathResponse
+		result2 error
+	}
+	GetStub        func(logger lager.Logger, ctx context.Context, driverId string, volumeId string) (volman.GetResponse, error)
+	getMutex       sync.RWMutex
+	getArgsForCall []struct {
+		logger   lager.Logger
+		ctx      context.Context
+		driverId string
+		volumeId string
+	}
+	getReturns struct {
+		result1 volman.GetResponse
+		result2 error
+	}
+	PurgeStub        func(logger lager.Logger, ctx context.Context, dryRun bool) (volman.PurgeResponse, error)
+	purgeMutex       sync.RWMutex
+	purgeArgsForCall []struct {
+		logger lager.Logger
+		ctx    context.Context
+		dryRun bool
+	}
+	purgeReturns struct {
+		result1 volman.PurgeResponse
+		result2 error
 	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeManager) ListDrivers(logger lager.Logger) (volman.ListDriversResponse, error) {
+func (fake *FakeManager) ListDrivers(logger lager.Logger, ctx context.Context) (volman.ListDriversResponse, error) {
 	fake.listDriversMutex.Lock()
 	fake.listDriversArgsForCall = append(fake.listDriversArgsForCall, struct {
 		logger lager.Logger
-	}{logger})
-	fake.recordInvocation("ListDrivers", []interface{}{logger})
+		ctx    context.Context
+	}{logger, ctx})
+	fake.recordInvocation("ListDrivers", []interface{}{logger, ctx})
 	fake.listDriversMutex.Unlock()
 	if fake.ListDriversStub != nil {
-		return fake.ListDriversStub(logger)
+		return fake.ListDriversStub(logger, ctx)
 	}
 	return fake.listDriversReturns.result1, fake.listDriversReturns.result2
 }
@@ -63,10 +136,10 @@ func (fake *FakeManager) ListDriversCallCount() int {
 	return len(fake.listDriversArgsForCall)
 }
 
-func (fake *FakeManager) ListDriversArgsForCall(i int) lager.Logger {
+func (fake *FakeManager) ListDriversArgsForCall(i int) (lager.Logger, context.Context) {
 	fake.listDriversMutex.RLock()
 	defer fake.listDriversMutex.RUnlock()
-	return fake.listDriversArgsForCall[i].logger
+	return fake.listDriversArgsForCall[i].logger, fake.listDriversArgsForCall[i].ctx
 }
 
 func (fake *FakeManager) ListDriversReturns(result1 volman.ListDriversResponse, result2 error) {
@@ -77,18 +150,123 @@ func (fake *FakeManager) ListDriversReturns(result1 volman.ListDriversResponse,
 	}{result1, result2}
 }
 
-func (fake *FakeManager) Mount(logger lager.Logger, driverId string, volumeId string, config map[string]interface{}) (volman.MountResponse, error) {
+func (fake *FakeManager) Discover(logger lager.Logger, ctx context.Context) (volman.ListDriversResponse, error) {
+	fake.discoverMutex.Lock()
+	fake.discoverArgsForCall = append(fake.discoverArgsForCall, struct {
+		logger lager.Logger
+		ctx    context.Context
+	}{logger, ctx})
+	fake.recordInvocation("Discover", []interface{}{logger, ctx})
+	fake.discoverMutex.Unlock()
+	if fake.DiscoverStub != nil {
+		return fake.DiscoverStub(logger, ctx)
+	}
+	return fake.discoverReturns.result1, fake.discoverReturns.result2
+}
+
+func (fake *FakeManager) DiscoverCallCount() int {
+	fake.discoverMutex.RLock()
+	defer fake.discoverMutex.RUnlock()
+	return len(fake.discoverArgsForCall)
+}
+
+func (fake *FakeManager) DiscoverArgsForCall(i int) (lager.Logger, context.Context) {
+	fake.discoverMutex.RLock()
+	defer fake.discoverMutex.RUnlock()
+	return fake.discoverArgsForCall[i].logger, fake.discoverArgsForCall[i].ctx
+}
+
+func (fake *FakeManager) DiscoverReturns(result1 volman.ListDriversResponse, result2 error) {
+	fake.DiscoverStub = nil
+	fake.discoverReturns = struct {
+		result1 volman.ListDriversResponse
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeManager) ListMounts(logger lager.Logger, ctx context.Context, filter volman.ListMountsFilter) (volman.ListMountsResponse, error) {
+	fake.listMountsMutex.Lock()
+	fake.listMountsArgsForCall = append(fake.listMountsArgsForCall, struct {
+		logger lager.Logger
+		ctx    context.Context
+		filter volman.ListMountsFilter
+	}{logger, ctx, filter})
+	fake.recordInvocation("ListMounts", []interface{}{logger, ctx, filter})
+	fake.listMountsMutex.Unlock()
+	if fake.ListMountsStub != nil {
+		return fake.ListMountsStub(logger, ctx, filter)
+	}
+	return fake.listMountsReturns.result1, fake.listMountsReturns.result2
+}
+
+func (fake *FakeManager) ListMountsCallCount() int {
+	fake.listMountsMutex.RLock()
+	defer fake.listMountsMutex.RUnlock()
+	return len(fake.listMountsArgsForCall)
+}
+
+func (fake *FakeManager) ListMountsArgsForCall(i int) (lager.Logger, context.Context, volman.ListMountsFilter) {
+	fake.listMountsMutex.RLock()
+	defer fake.listMountsMutex.RUnlock()
+	return fake.listMountsArgsForCall[i].logger, fake.listMountsArgsForCall[i].ctx, fake.listMountsArgsForCall[i].filter
+}
+
+func (fake *FakeManager) ListMountsReturns(result1 volman.ListMountsResponse, result2 error) {
+	fake.ListMountsStub = nil
+	fake.listMountsReturns = struct {
+		result1 volman.ListMountsResponse
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeManager) ListPendingUnmounts(logger lager.Logger, ctx context.Context) (volman.ListPendingUnmountsResponse, error) {
+	fake.listPendingUnmountsMutex.Lock()
+	fake.listPendingUnmountsArgsForCall = append(fake.listPendingUnmountsArgsForCall, struct {
+		logger lager.Logger
+		ctx    context.Context
+	}{logger, ctx})
+	fake.recordInvocation("ListPendingUnmounts", []interface{}{logger, ctx})
+	fake.listPendingUnmountsMutex.Unlock()
+	if fake.ListPendingUnmountsStub != nil {
+		return fake.ListPendingUnmountsStub(logger, ctx)
+	}
+	return fake.listPendingUnmountsReturns.result1, fake.listPendingUnmountsReturns.result2
+}
+
+func (fake *FakeManager) ListPendingUnmountsCallCount() int {
+	fake.listPendingUnmountsMutex.RLock()
+	defer fake.listPendingUnmountsMutex.RUnlock()
+	return len(fake.listPendingUnmountsArgsForCall)
+}
+
+func (fake *FakeManager) ListPendingUnmountsArgsForCall(i int) (lager.Logger, context.Context) {
+	fake.listPendingUnmountsMutex.RLock()
+	defer fake.listPendingUnmountsMutex.RUnlock()
+	return fake.listPendingUnmountsArgsForCall[i].logger, fake.listPendingUnmountsArgsForCall[i].ctx
+}
+
+func (fake *FakeManager) ListPendingUnmountsReturns(result1 volman.ListPendingUnmountsResponse, result2 error) {
+	fake.ListPendingUnmountsStub = nil
+	fake.listPendingUnmountsReturns = struct {
+		result1 volman.ListPendingUnmountsResponse
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeManager) Mount(logger lager.Logger, ctx context.Context, driverId string, volumeId string, containerId string, config map[string]interface{}) (volman.MountResponse, error) {
 	fake.mountMutex.Lock()
 	fake.mountArgsForCall = append(fake.mountArgsForCall, struct {
-		logger   lager.Logger
-		driverId string
-		volumeId string
-		config   map[string]interface{}
-	}{logger, driverId, volumeId, config})
-	fake.recordInvocation("Mount", []interface{}{logger, driverId, volumeId, config})
+		logger      lager.Logger
+		ctx         context.Context
+		driverId    string
+		volumeId    string
+		containerId string
+		config      map[string]interface{}
+	}{logger, ctx, driverId, volumeId, containerId, config})
+	fake.recordInvocation("Mount", []interface{}{logger, ctx, driverId, volumeId, containerId, config})
 	fake.mountMutex.Unlock()
 	if fake.MountStub != nil {
-		return fake.MountStub(logger, driverId, volumeId, config)
+		return fake.MountStub(logger, ctx, driverId, volumeId, containerId, config)
 	}
 	return fake.mountReturns.result1, fake.mountReturns.result2
 }
@@ -99,10 +277,10 @@ func (fake *FakeManager) MountCallCount() int {
 	return len(fake.mountArgsForCall)
 }
 
-func (fake *FakeManager) MountArgsForCall(i int) (lager.Logger, string, string, map[string]interface{}) {
+func (fake *FakeManager) MountArgsForCall(i int) (lager.Logger, context.Context, string, string, string, map[string]interface{}) {
 	fake.mountMutex.RLock()
 	defer fake.mountMutex.RUnlock()
-	return fake.mountArgsForCall[i].logger, fake.mountArgsForCall[i].driverId, fake.mountArgsForCall[i].volumeId, fake.mountArgsForCall[i].config
+	return fake.mountArgsForCall[i].logger, fake.mountArgsForCall[i].ctx, fake.mountArgsForCall[i].driverId, fake.mountArgsForCall[i].volumeId, fake.mountArgsForCall[i].containerId, fake.mountArgsForCall[i].config
 }
 
 func (fake *FakeManager) MountReturns(result1 volman.MountResponse, result2 error) {
@@ -113,17 +291,19 @@ func (fake *FakeManager) MountReturns(result1 volman.MountResponse, result2 erro
 	}{result1, result2}
 }
 
-func (fake *FakeManager) Unmount(logger lager.Logger, driverId string, volumeId string) error {
+func (fake *FakeManager) Unmount(logger lager.Logger, ctx context.Context, driverId string, volumeId string, containerId string) error {
 	fake.unmountMutex.Lock()
 	fake.unmountArgsForCall = append(fake.unmountArgsForCall, struct {
-		logger   lager.Logger
-		driverId string
-		volumeId string
-	}{logger, driverId, volumeId})
-	fake.recordInvocation("Unmount", []interface{}{logger, driverId, volumeId})
+		logger      lager.Logger
+		ctx         context.Context
+		driverId    string
+		volumeId    string
+		containerId string
+	}{logger, ctx, driverId, volumeId, containerId})
+	fake.recordInvocation("Unmount", []interface{}{logger, ctx, driverId, volumeId, containerId})
 	fake.unmountMutex.Unlock()
 	if fake.UnmountStub != nil {
-		return fake.UnmountStub(logger, driverId, volumeId)
+		return fake.UnmountStub(logger, ctx, driverId, volumeId, containerId)
 	}
 	return fake.unmountReturns.result1
 }
@@ -134,10 +314,10 @@ func (fake *FakeManager) UnmountCallCount() int {
 	return len(fake.unmountArgsForCall)
 }
 
-func (fake *FakeManager) UnmountArgsForCall(i int) (lager.Logger, string, string) {
+func (fake *FakeManager) UnmountArgsForCall(i int) (lager.Logger, context.Context, string, string, string) {
 	fake.unmountMutex.RLock()
 	defer fake.unmountMutex.RUnlock()
-	return fake.unmountArgsForCall[i].logger, fake.unmountArgsForCall[i].driverId, fake.unmountArgsForCall[i].volumeId
+	return fake.unmountArgsForCall[i].logger, fake.unmountArgsForCall[i].ctx, fake.unmountArgsForCall[i].driverId, fake.unmountArgsForCall[i].volumeId, fake.unmountArgsForCall[i].containerId
 }
 
 func (fake *FakeManager) UnmountReturns(result1 error) {
@@ -147,15 +327,134 @@ func (fake *FakeManager) UnmountReturns(result1 error) {
 	}{result1}
 }
 
+func (fake *FakeManager) Path(logger lager.Logger, ctx context.Context, driverId string, volumeId string) (volman.PathResponse, error) {
+	fake.pathMutex.Lock()
+	fake.pathArgsForCall = append(fake.pathArgsForCall, struct {
+		logger   lager.Logger
+		ctx      context.Context
+		driverId string
+		volumeId string
+	}{logger, ctx, driverId, volumeId})
+	fake.recordInvocation("Path", []interface{}{logger, ctx, driverId, volumeId})
+	fake.pathMutex.Unlock()
+	if fake.PathStub != nil {
+		return fake.PathStub(logger, ctx, driverId, volumeId)
+	}
+	return fake.pathReturns.result1, fake.pathReturns.result2
+}
+
+func (fake *FakeManager) PathCallCount() int {
+	fake.pathMutex.RLock()
+	defer fake.pathMutex.RUnlock()
+	return len(fake.pathArgsForCall)
+}
+
+func (fake *FakeManager) PathArgsForCall(i int) (lager.Logger, context.Context, string, string) {
+	fake.pathMutex.RLock()
+	defer fake.pathMutex.RUnlock()
+	return fake.pathArgsForCall[i].logger, fake.pathArgsForCall[i].ctx, fake.pathArgsForCall[i].driverId, fake.pathArgsForCall[i].volumeId
+}
+
+func (fake *FakeManager) PathReturns(result1 volman.PathResponse, result2 error) {
+	fake.PathStub = nil
+	fake.pathReturns = struct {
+		result1 volman.PathResponse
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeManager) Get(logger lager.Logger, ctx context.Context, driverId string, volumeId string) (volman.GetResponse, error) {
+	fake.getMutex.Lock()
+	fake.getArgsForCall = append(fake.getArgsForCall, struct {
+		logger   lager.Logger
+		ctx      context.Context
+		driverId string
+		volumeId string
+	}{logger, ctx, driverId, volumeId})
+	fake.recordInvocation("Get", []interface{}{logger, ctx, driverId, volumeId})
+	fake.getMutex.Unlock()
+	if fake.GetStub != nil {
+		return fake.GetStub(logger, ctx, driverId, volumeId)
+	}
+	return fake.getReturns.result1, fake.getReturns.result2
+}
+
+func (fake *FakeManager) GetCallCount() int {
+	fake.getMutex.RLock()
+	defer fake.getMutex.RUnlock()
+	return len(fake.getArgsForCall)
+}
+
+func (fake *FakeManager) GetArgsForCall(i int) (lager.Logger, context.Context, string, string) {
+	fake.getMutex.RLock()
+	defer fake.getMutex.RUnlock()
+	return fake.getArgsForCall[i].logger, fake.getArgsForCall[i].ctx, fake.getArgsForCall[i].driverId, fake.getArgsForCall[i].volumeId
+}
+
+func (fake *FakeManager) GetReturns(result1 volman.GetResponse, result2 error) {
+	fake.GetStub = nil
+	fake.getReturns = struct {
+		result1 volman.GetResponse
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeManager) Purge(logger lager.Logger, ctx context.Context, dryRun bool) (volman.PurgeResponse, error) {
+	fake.purgeMutex.Lock()
+	fake.purgeArgsForCall = append(fake.purgeArgsForCall, struct {
+		logger lager.Logger
+		ctx    context.Context
+		dryRun bool
+	}{logger, ctx, dryRun})
+	fake.recordInvocation("Purge", []interface{}{logger, ctx, dryRun})
+	fake.purgeMutex.Unlock()
+	if fake.PurgeStub != nil {
+		return fake.PurgeStub(logger, ctx, dryRun)
+	}
+	return fake.purgeReturns.result1, fake.purgeReturns.result2
+}
+
+func (fake *FakeManager) PurgeCallCount() int {
+	fake.purgeMutex.RLock()
+	defer fake.purgeMutex.RUnlock()
+	return len(fake.purgeArgsForCall)
+}
+
+func (fake *FakeManager) PurgeArgsForCall(i int) (lager.Logger, context.Context, bool) {
+	fake.purgeMutex.RLock()
+	defer fake.purgeMutex.RUnlock()
+	return fake.purgeArgsForCall[i].logger, fake.purgeArgsForCall[i].ctx, fake.purgeArgsForCall[i].dryRun
+}
+
+func (fake *FakeManager) PurgeReturns(result1 volman.PurgeResponse, result2 error) {
+	fake.PurgeStub = nil
+	fake.purgeReturns = struct {
+		result1 volman.PurgeResponse
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeManager) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
 	fake.listDriversMutex.RLock()
 	defer fake.listDriversMutex.RUnlock()
+	fake.discoverMutex.RLock()
+	defer fake.discoverMutex.RUnlock()
+	fake.listMountsMutex.RLock()
+	defer fake.listMountsMutex.RUnlock()
+	fake.listPendingUnmountsMutex.RLock()
+	defer fake.listPendingUnmountsMutex.RUnlock()
 	fake.mountMutex.RLock()
 	defer fake.mountMutex.RUnlock()
 	fake.unmountMutex.RLock()
 	defer fake.unmountMutex.RUnlock()
+	fake.pathMutex.RLock()
+	defer fake.pathMutex.RUnlock()
+	fake.getMutex.RLock()
+	defer fake.getMutex.RUnlock()
+	fake.purgeMutex.RLock()
+	defer fake.purgeMutex.RUnlock()
 	return fake.invocations
 }
 